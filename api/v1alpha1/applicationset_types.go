@@ -26,6 +26,7 @@ import (
 	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // Utility struct for a reference to a secret key.
@@ -51,6 +52,25 @@ type ApplicationSetSpec struct {
 	Generators []ApplicationSetGenerator `json:"generators"`
 	Template   ApplicationSetTemplate    `json:"template"`
 	SyncPolicy *ApplicationSetSyncPolicy `json:"syncPolicy,omitempty"`
+	// IgnoreApplicationDifferences lists fields of the generated Applications whose live values are preserved when
+	// the Applications are updated, rather than being reset to the template.
+	IgnoreApplicationDifferences []ApplicationSetResourceIgnoreDifferences `json:"ignoreApplicationDifferences,omitempty"`
+	// AllowedProjects lists the AppProjects (names or glob patterns) that the generated Applications may reference.
+	// Applications which reference any other project are not created or updated. If empty, any project is allowed.
+	AllowedProjects []string `json:"allowedProjects,omitempty"`
+}
+
+// ApplicationSetResourceIgnoreDifferences configures which fields of generated Applications are preserved from the
+// live Applications on update. Fields are selected by JSON pointers or jq path expressions, relative to the
+// Application (for example '/spec/source/targetRevision' or '.spec.source.helm.parameters').
+type ApplicationSetResourceIgnoreDifferences struct {
+	// Name is the name of the Application the fields are preserved for. If empty, they are preserved for all
+	// Applications of the ApplicationSet.
+	Name string `json:"name,omitempty"`
+	// JSONPointers are the RFC 6901 JSON pointers of the fields to preserve.
+	JSONPointers []string `json:"jsonPointers,omitempty"`
+	// JQPathExpressions are the jq path expressions of the fields to preserve.
+	JQPathExpressions []string `json:"jqPathExpressions,omitempty"`
 }
 
 // ApplicationSetSyncPolicy configures how generated Applications will relate to their
@@ -58,6 +78,53 @@ type ApplicationSetSpec struct {
 type ApplicationSetSyncPolicy struct {
 	// PreserveResourcesOnDeletion will preserve resources on deletion. If PreserveResourcesOnDeletion is set to true, these Applications will not be deleted.
 	PreserveResourcesOnDeletion bool `json:"preserveResourcesOnDeletion,omitempty"`
+	// MaxApplicationDeletions is the maximum number (eg 5) or percentage (eg 10%) of the Applications of the
+	// ApplicationSet which may be deleted by a single reconciliation. When more Applications would be deleted, none
+	// of them are, until the deletion is confirmed with the confirm-deletion annotation on the ApplicationSet.
+	// Unlimited if not set.
+	// +kubebuilder:validation:XIntOrString
+	MaxApplicationDeletions *intstr.IntOrString `json:"maxApplicationDeletions,omitempty"`
+	// ApplicationRemovalPolicy is what happens to an Application which is no longer generated by the ApplicationSet:
+	// it is either deleted (the default), or orphaned.
+	ApplicationRemovalPolicy ApplicationRemovalPolicy `json:"applicationRemovalPolicy,omitempty"`
+	// DeletionPolicy is what happens to the Applications of the ApplicationSet when the ApplicationSet is deleted.
+	// If set, the ApplicationSet is kept (with a finalizer) until all of its Applications have been deleted or
+	// orphaned; otherwise, its Applications are deleted by the Kubernetes garbage collector.
+	DeletionPolicy ApplicationSetDeletionPolicy `json:"deletionPolicy,omitempty"`
+}
+
+// ApplicationSetDeletionPolicy is what happens to the Applications of an ApplicationSet when it is deleted.
+// +kubebuilder:validation:Enum=DeleteApplicationsAndResources;DeleteApplications;OrphanApplications
+type ApplicationSetDeletionPolicy string
+
+const (
+	// ApplicationSetDeletionPolicyDeleteApplicationsAndResources deletes the Applications, and their resources (by
+	// adding the Argo CD resources finalizer to the Applications, if needed)
+	ApplicationSetDeletionPolicyDeleteApplicationsAndResources ApplicationSetDeletionPolicy = "DeleteApplicationsAndResources"
+	// ApplicationSetDeletionPolicyDeleteApplications deletes the Applications, but keeps their resources (by removing
+	// the Argo CD resources finalizer from the Applications)
+	ApplicationSetDeletionPolicyDeleteApplications ApplicationSetDeletionPolicy = "DeleteApplications"
+	// ApplicationSetDeletionPolicyOrphanApplications orphans the Applications, in the same way as the Orphan
+	// ApplicationRemovalPolicy, so that both the Applications and their resources are kept
+	ApplicationSetDeletionPolicyOrphanApplications ApplicationSetDeletionPolicy = "OrphanApplications"
+)
+
+// ApplicationRemovalPolicy is what happens to an Application which is no longer generated by its ApplicationSet.
+// +kubebuilder:validation:Enum=Delete;Orphan
+type ApplicationRemovalPolicy string
+
+const (
+	// ApplicationRemovalPolicyDelete deletes the Application
+	ApplicationRemovalPolicyDelete ApplicationRemovalPolicy = "Delete"
+	// ApplicationRemovalPolicyOrphan orphans the Application: its owner reference to the ApplicationSet is removed,
+	// and it is labelled as orphaned, so that it (and its resources) are kept until they are deleted by an operator
+	ApplicationRemovalPolicyOrphan ApplicationRemovalPolicy = "Orphan"
+)
+
+// OrphanRemovedApplications returns true if the Applications which are no longer generated by the ApplicationSet
+// are orphaned, rather than deleted
+func (p *ApplicationSetSyncPolicy) OrphanRemovedApplications() bool {
+	return p != nil && p.ApplicationRemovalPolicy == ApplicationRemovalPolicyOrphan
 }
 
 // ApplicationSetTemplate represents argocd ApplicationSpec
@@ -253,8 +320,33 @@ type ClusterGenerator struct {
 
 	// Values contains key/value pairs which are passed directly as parameters to the template
 	Values map[string]string `json:"values,omitempty"`
+
+	// AdditionalParams lists the optional cluster parameters to generate, in addition to the name, server, labels
+	// and annotations of each cluster.
+	AdditionalParams []ClusterGeneratorParam `json:"additionalParams,omitempty"`
 }
 
+// ClusterGeneratorParam is an optional parameter of the cluster generator.
+// +kubebuilder:validation:Enum=project;namespaces;clusterResources;shard;info
+type ClusterGeneratorParam string
+
+const (
+	// ClusterGeneratorParamProject generates the 'project' parameter: the project the cluster is scoped to, if any
+	ClusterGeneratorParamProject ClusterGeneratorParam = "project"
+	// ClusterGeneratorParamNamespaces generates the 'namespaces' parameter: the comma separated list of namespaces
+	// Argo CD is restricted to on the cluster, which is empty if it is not restricted
+	ClusterGeneratorParamNamespaces ClusterGeneratorParam = "namespaces"
+	// ClusterGeneratorParamClusterResources generates the 'clusterResources' parameter: 'true' if Argo CD may manage
+	// cluster-scoped resources on a namespace-restricted cluster, otherwise 'false'
+	ClusterGeneratorParamClusterResources ClusterGeneratorParam = "clusterResources"
+	// ClusterGeneratorParamShard generates the 'shard' parameter: the application controller shard of the cluster, if
+	// set
+	ClusterGeneratorParamShard ClusterGeneratorParam = "shard"
+	// ClusterGeneratorParamInfo generates the 'info.serverVersion' and 'info.connectionState.status' parameters, by
+	// connecting to the cluster
+	ClusterGeneratorParamInfo ClusterGeneratorParam = "info"
+)
+
 // DuckType defines a generator to match against clusters registered with ArgoCD.
 type DuckTypeGenerator struct {
 	// ConfigMapRef is a ConfigMap with the duck type definitions needed to retrieve the data
@@ -278,11 +370,27 @@ type GitGenerator struct {
 	Revision            string                      `json:"revision"`
 	RequeueAfterSeconds *int64                      `json:"requeueAfterSeconds,omitempty"`
 	Template            ApplicationSetTemplate      `json:"template,omitempty"`
+
+	// VerifyCommitSignature requires the commit that Revision resolves to be signed by a key in the Argo CD GnuPG
+	// keyring. If the signature can't be verified, no parameters are generated.
+	VerifyCommitSignature bool `json:"verifyCommitSignature,omitempty"`
+	// SignatureKeys restricts the GnuPG keys (by key ID) that may have signed the commit that Revision resolves to.
+	// Setting SignatureKeys implies VerifyCommitSignature.
+	SignatureKeys []string `json:"signatureKeys,omitempty"`
+}
+
+// RequiresCommitSignature returns true if the commit of the Git generator's revision must be signed.
+func (g *GitGenerator) RequiresCommitSignature() bool {
+	return g.VerifyCommitSignature || len(g.SignatureKeys) > 0
 }
 
 type GitDirectoryGeneratorItem struct {
 	Path    string `json:"path"`
 	Exclude bool   `json:"exclude,omitempty"`
+	// ValuesFile is the path, relative to each matched directory, of a JSON/YAML file whose flattened
+	// contents are added to the parameters generated for that directory. Directories without the file
+	// are still included, with only the path parameters.
+	ValuesFile string `json:"valuesFile,omitempty"`
 }
 
 type GitFileGeneratorItem struct {
@@ -313,6 +421,9 @@ type SCMProviderGeneratorGithub struct {
 	API string `json:"api,omitempty"`
 	// Authentication token reference.
 	TokenRef *SecretRef `json:"tokenRef,omitempty"`
+	// Name of a Secret holding the credentials of a GitHub App (githubAppID, githubAppInstallationID and
+	// githubAppPrivateKey keys), to authenticate as its installation instead of with a token.
+	AppSecretName string `json:"appSecretName,omitempty"`
 	// Scan all branches instead of just the default branch.
 	AllBranches bool `json:"allBranches,omitempty"`
 }
@@ -376,6 +487,9 @@ type PullRequestGeneratorGithub struct {
 	API string `json:"api,omitempty"`
 	// Authentication token reference.
 	TokenRef *SecretRef `json:"tokenRef,omitempty"`
+	// Name of a Secret holding the credentials of a GitHub App (githubAppID, githubAppInstallationID and
+	// githubAppPrivateKey keys), to authenticate as its installation instead of with a token.
+	AppSecretName string `json:"appSecretName,omitempty"`
 	// Labels is used to filter the PRs that you want to target
 	Labels []string `json:"labels,omitempty"`
 }
@@ -385,6 +499,20 @@ type ApplicationSetStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 	Conditions []ApplicationSetCondition `json:"conditions,omitempty"`
+	// Deletion is the progress of the deletion of the ApplicationSet, when it has a deletion policy
+	Deletion *ApplicationSetDeletionStatus `json:"deletion,omitempty"`
+}
+
+// ApplicationSetDeletionStatus is the progress of the deletion of an ApplicationSet, according to its deletion policy
+type ApplicationSetDeletionStatus struct {
+	// Policy is the deletion policy which is applied to the Applications
+	Policy ApplicationSetDeletionPolicy `json:"policy"`
+	// RemainingApplications is the number of Applications which have not been deleted or orphaned yet
+	RemainingApplications int `json:"remainingApplications"`
+	// Message describes the state of the deletion, including any error which prevents it from progressing
+	Message string `json:"message,omitempty"`
+	// LastUpdateTime is the time the deletion status was last updated
+	LastUpdateTime *metav1.Time `json:"lastUpdateTime,omitempty"`
 }
 
 // ApplicationSetCondition contains details about an applicationset condition, which is usally an error or warning
@@ -441,6 +569,9 @@ const (
 	ApplicationSetReasonDeleteApplicationError           = "DeleteApplicationError"
 	ApplicationSetReasonRefreshApplicationError          = "RefreshApplicationError"
 	ApplicationSetReasonApplicationValidationError       = "ApplicationValidationError"
+	ApplicationSetReasonDeleteApplicationBlocked         = "DeleteApplicationBlocked"
+	ApplicationSetReasonProjectNotAllowed                = "ProjectNotAllowed"
+	ApplicationSetReasonSecretNotAllowed                 = "SecretNotAllowed"
 )
 
 // ApplicationSetList contains a list of ApplicationSet
@@ -461,6 +592,12 @@ func (a *ApplicationSet) RefreshRequired() bool {
 	return found
 }
 
+// DeletionConfirmed checks if the deletion of more Applications than allowed by MaxApplicationDeletions has been
+// confirmed, with the given confirmation value of that deletion
+func (a *ApplicationSet) DeletionConfirmed(confirmation string) bool {
+	return a.Annotations[common.AnnotationApplicationSetConfirmDeletion] == confirmation
+}
+
 // SetConditions updates the applicationset status conditions for a subset of evaluated types.
 // If the applicationset has a pre-existing condition of a type that is not in the evaluated list,
 // it will be preserved. If the applicationset has a pre-existing condition of a type, status, reason that
@@ -22,6 +22,7 @@ package v1alpha1
 import (
 	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
@@ -70,6 +71,25 @@ func (in *ApplicationSetCondition) DeepCopy() *ApplicationSetCondition {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ApplicationSetDeletionStatus) DeepCopyInto(out *ApplicationSetDeletionStatus) {
+	*out = *in
+	if in.LastUpdateTime != nil {
+		in, out := &in.LastUpdateTime, &out.LastUpdateTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ApplicationSetDeletionStatus.
+func (in *ApplicationSetDeletionStatus) DeepCopy() *ApplicationSetDeletionStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ApplicationSetDeletionStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ApplicationSetGenerator) DeepCopyInto(out *ApplicationSetGenerator) {
 	*out = *in
@@ -233,6 +253,31 @@ func (in ApplicationSetNestedGenerators) DeepCopy() ApplicationSetNestedGenerato
 	return *out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ApplicationSetResourceIgnoreDifferences) DeepCopyInto(out *ApplicationSetResourceIgnoreDifferences) {
+	*out = *in
+	if in.JSONPointers != nil {
+		in, out := &in.JSONPointers, &out.JSONPointers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.JQPathExpressions != nil {
+		in, out := &in.JQPathExpressions, &out.JQPathExpressions
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ApplicationSetResourceIgnoreDifferences.
+func (in *ApplicationSetResourceIgnoreDifferences) DeepCopy() *ApplicationSetResourceIgnoreDifferences {
+	if in == nil {
+		return nil
+	}
+	out := new(ApplicationSetResourceIgnoreDifferences)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ApplicationSetSpec) DeepCopyInto(out *ApplicationSetSpec) {
 	*out = *in
@@ -247,7 +292,19 @@ func (in *ApplicationSetSpec) DeepCopyInto(out *ApplicationSetSpec) {
 	if in.SyncPolicy != nil {
 		in, out := &in.SyncPolicy, &out.SyncPolicy
 		*out = new(ApplicationSetSyncPolicy)
-		**out = **in
+		(*in).DeepCopyInto(*out)
+	}
+	if in.IgnoreApplicationDifferences != nil {
+		in, out := &in.IgnoreApplicationDifferences, &out.IgnoreApplicationDifferences
+		*out = make([]ApplicationSetResourceIgnoreDifferences, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.AllowedProjects != nil {
+		in, out := &in.AllowedProjects, &out.AllowedProjects
+		*out = make([]string, len(*in))
+		copy(*out, *in)
 	}
 }
 
@@ -271,6 +328,11 @@ func (in *ApplicationSetStatus) DeepCopyInto(out *ApplicationSetStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Deletion != nil {
+		in, out := &in.Deletion, &out.Deletion
+		*out = new(ApplicationSetDeletionStatus)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ApplicationSetStatus.
@@ -286,6 +348,11 @@ func (in *ApplicationSetStatus) DeepCopy() *ApplicationSetStatus {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ApplicationSetSyncPolicy) DeepCopyInto(out *ApplicationSetSyncPolicy) {
 	*out = *in
+	if in.MaxApplicationDeletions != nil {
+		in, out := &in.MaxApplicationDeletions, &out.MaxApplicationDeletions
+		*out = new(intstr.IntOrString)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ApplicationSetSyncPolicy.
@@ -427,6 +494,11 @@ func (in *ClusterGenerator) DeepCopyInto(out *ClusterGenerator) {
 			(*out)[key] = val
 		}
 	}
+	if in.AdditionalParams != nil {
+		in, out := &in.AdditionalParams, &out.AdditionalParams
+		*out = make([]ClusterGeneratorParam, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterGenerator.
@@ -517,6 +589,11 @@ func (in *GitGenerator) DeepCopyInto(out *GitGenerator) {
 		**out = **in
 	}
 	in.Template.DeepCopyInto(&out.Template)
+	if in.SignatureKeys != nil {
+		in, out := &in.SignatureKeys, &out.SignatureKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GitGenerator.
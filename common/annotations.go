@@ -3,4 +3,22 @@ package common
 const (
 	// AnnotationApplicationRefresh is an annotation that is added when an ApplicationSet is requested to be refreshed by a webhook. The ApplicationSet controller will remove this annotation at the end of reconcilation.
 	AnnotationApplicationSetRefresh = "argocd.argoproj.io/application-set-refresh"
+	// AnnotationApplicationSetConfirmDeletion is an annotation that is added to an ApplicationSet, with the value given in the blocked deletion error, to confirm the deletion of more Applications than allowed by its maxApplicationDeletions. The ApplicationSet controller will remove this annotation at the end of reconcilation.
+	AnnotationApplicationSetConfirmDeletion = "argocd.argoproj.io/application-set-confirm-deletion"
+	// LabelApplicationSetOrphaned is a label that is added to an Application, with the value "true", when it is orphaned by its ApplicationSet
+	LabelApplicationSetOrphaned = "argocd.argoproj.io/application-set-orphaned"
+	// AnnotationApplicationSetOrphanedFrom is an annotation that is added to an Application when it is orphaned by its ApplicationSet, with the name of the ApplicationSet
+	AnnotationApplicationSetOrphanedFrom = "argocd.argoproj.io/application-set-orphaned-from"
+	// AnnotationApplicationSetOwner is an annotation that is added to an Application generated by an ApplicationSet in another namespace than Argo CD, with the namespace and name ('namespace/name') of the ApplicationSet, as owner references can't refer to an owner in another namespace
+	AnnotationApplicationSetOwner = "argocd.argoproj.io/application-set-owner"
+	// AnnotationApplicationSetAllowedProjects is an annotation that is added to a Namespace, with a comma separated list of AppProject names or glob patterns, to restrict the projects that the Applications of ApplicationSets in that namespace may reference
+	AnnotationApplicationSetAllowedProjects = "argocd.argoproj.io/application-set-allowed-projects"
+	// LabelApplicationSetSecret is a label that is added to a Secret, with the value "true", to allow the SCM Provider and Pull Request generators of ApplicationSets to read it
+	LabelApplicationSetSecret = "argocd.argoproj.io/application-set-secret"
+	// LabelApplicationSetShard is a label that is added to an ApplicationSet, with the index of a shard, to assign it to that shard of a sharded ApplicationSet controller
+	LabelApplicationSetShard = "argocd.argoproj.io/application-set-shard"
+	// LabelApplicationSetControllerReplica is a label of the Leases held by the replicas of a sharded ApplicationSet controller, with the name of the replica
+	LabelApplicationSetControllerReplica = "argocd.argoproj.io/application-set-controller-replica"
+	// ApplicationSetFinalizer is the finalizer of ApplicationSets with a deletion policy (and of ApplicationSets in another namespace than Argo CD), which is removed once all of their Applications have been deleted or orphaned
+	ApplicationSetFinalizer = "applications-finalizer.argocd.argoproj.io"
 )
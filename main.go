@@ -31,12 +31,16 @@ import (
 	"github.com/argoproj/applicationset/pkg/controllers"
 	"github.com/argoproj/applicationset/pkg/generators"
 	"github.com/argoproj/applicationset/pkg/services"
+	"github.com/argoproj/applicationset/pkg/services/scm_provider"
 	"github.com/argoproj/applicationset/pkg/utils"
 
 	"github.com/argoproj/applicationset/common"
+	argocommon "github.com/argoproj/argo-cd/v2/common"
 	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
 	"github.com/argoproj/argo-cd/v2/util/db"
+	"github.com/argoproj/argo-cd/v2/util/gpg"
 	argosettings "github.com/argoproj/argo-cd/v2/util/settings"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
@@ -53,6 +57,9 @@ import (
 
 const (
 	JsonFormat = "json"
+
+	// gnuPGSourcePath is where the keys of the argocd-gpg-keys-cm ConfigMap are mounted by default
+	gnuPGSourcePath = "/app/config/gpg/source"
 )
 
 var (
@@ -73,27 +80,59 @@ func main() {
 	var metricsAddr string
 	var probeBindAddr string
 	var webhookAddr string
+	var webhookParallelism int
+	var webhookQueueSize int
 	var enableLeaderElection bool
 	var namespace string
+	var applicationSetNamespaces string
 	var argocdRepoServer string
+	var gitWorkDir string
+	var gitWorkDirQuota string
+	var localClusterLabels string
+	var localClusterAnnotations string
 	var policy string
 	var debugLog bool
 	var dryRun bool
+	var enableServerSideApply bool
+	var concurrentReconciliations int
+	var reconcileTimeout time.Duration
+	var generatorTimeouts string
+	var scmProviderCacheTTL time.Duration
+	var scmProviderMaxRateLimitWait time.Duration
+	var enableSharding bool
+	var shardingReplica string
+	var shardingLeaseDuration time.Duration
 	var logFormat string
 	var logLevel string
 
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeBindAddr, "probe-addr", ":8081", "The address the probe endpoint binds to.")
 	flag.StringVar(&webhookAddr, "webhook-addr", ":7000", "The address the webhook endpoint binds to.")
+	flag.IntVar(&webhookParallelism, "webhook-parallelism", 10, "The number of webhook events handled concurrently.")
+	flag.IntVar(&webhookQueueSize, "webhook-queue-size", 1000, "The maximum number of webhook events waiting to be handled; further events are rejected until the queue drains.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
 	flag.StringVar(&namespace, "namespace", "", "Argo CD repo namespace (default: argocd)")
-	flag.StringVar(&argocdRepoServer, "argocd-repo-server", "argocd-repo-server:8081", "Argo CD repo server address")
+	flag.StringVar(&applicationSetNamespaces, "applicationset-namespaces", "", "Comma separated list of namespaces (or glob patterns, eg 'team-*'), other than the Argo CD namespace, in which ApplicationSets are reconciled")
+	flag.StringVar(&argocdRepoServer, "argocd-repo-server", "argocd-repo-server:8081", "Argo CD repo server address (currently unused: Git repositories are fetched by the ApplicationSet controller)")
+	flag.StringVar(&gitWorkDir, "git-workdir", os.TempDir(), "Directory under which Git repositories are fetched by the Git generator")
+	flag.StringVar(&gitWorkDirQuota, "git-workdir-quota", "", "Maximum disk space used by fetched Git repositories (e.g. '10Gi'); least recently used repositories are removed when exceeded. Unlimited if empty")
+	flag.StringVar(&localClusterLabels, "local-cluster-labels", "", "Comma separated key=value labels of the local cluster, for cluster generators, when it is not defined by a cluster secret")
+	flag.StringVar(&localClusterAnnotations, "local-cluster-annotations", "", "Comma separated key=value annotations of the local cluster, for cluster generators, when it is not defined by a cluster secret")
 	flag.StringVar(&policy, "policy", "sync", "Modify how application is synced between the generator and the cluster. Default is 'sync' (create & update & delete), options: 'create-only', 'create-update' (no deletion)")
 	flag.BoolVar(&debugLog, "debug", false, "Print debug logs. Takes precedence over loglevel")
 	flag.StringVar(&logLevel, "loglevel", "info", "Set the logging level. One of: debug|info|warn|error")
 	flag.BoolVar(&dryRun, "dry-run", false, "Enable dry run mode")
+	flag.BoolVar(&enableServerSideApply, "enable-server-side-apply", false, "Apply generated Applications with server-side apply, as the '"+controllers.ApplicationSetFieldManager+"' field manager, so that only the fields set by the ApplicationSet are managed by it")
+	flag.IntVar(&concurrentReconciliations, "concurrent-reconciliations", 1, "Maximum number of ApplicationSets which are reconciled concurrently")
+	flag.DurationVar(&scmProviderCacheTTL, "scm-provider-cache-ttl", time.Minute, "Duration for which the repositories, branches and paths listed by SCM Provider generators are reused by all ApplicationSets (e.g. '5m'); not reused if 0. ApplicationSets refreshed by a webhook event or the refresh annotation ignore the cached results")
+	flag.DurationVar(&scmProviderMaxRateLimitWait, "scm-provider-max-rate-limit-wait", time.Minute, "Maximum duration SCM Provider requests wait for an exhausted API rate limit to be reset, before failing")
+	flag.DurationVar(&reconcileTimeout, "reconcile-timeout", 0, "Maximum duration of the reconciliation of an ApplicationSet, including the generation of its parameters (e.g. '5m'); unlimited if 0")
+	flag.StringVar(&generatorTimeouts, "generator-timeouts", "", "Comma separated generator=duration timeouts of the parameter generation of each generator type (e.g. 'Git=2m,SCMProvider=5m'); unlimited for the other generators")
+	flag.BoolVar(&enableSharding, "enable-sharding", false, "Distribute the ApplicationSets across all the replicas of the controller, rather than electing a leader. Each replica reconciles the ApplicationSets of its shard")
+	flag.StringVar(&shardingReplica, "sharding-replica", "", "Unique name of this replica, when sharding is enabled (default: the POD_NAME env var, or the hostname)")
+	flag.DurationVar(&shardingLeaseDuration, "sharding-lease-duration", 30*time.Second, "Duration after which a replica which has stopped renewing its lease is removed from the shards, when sharding is enabled")
 	flag.StringVar(&logFormat, "logformat", "text", "Set the logging format. One of: text|json")
 	flag.Parse()
 
@@ -112,6 +151,32 @@ func main() {
 
 	setLoggingLevel(debugLog, logLevel)
 
+	var gitWorkDirQuotaBytes int64
+	if gitWorkDirQuota != "" {
+		quantity, err := resource.ParseQuantity(gitWorkDirQuota)
+		if err != nil {
+			setupLog.Error(err, "unable to parse git-workdir-quota", "git-workdir-quota", gitWorkDirQuota)
+			os.Exit(1)
+		}
+		gitWorkDirQuotaBytes = quantity.Value()
+	}
+
+	localClusterLabelsMap, err := parseKeyValuePairs(localClusterLabels)
+	if err != nil {
+		setupLog.Error(err, "unable to parse local-cluster-labels", "local-cluster-labels", localClusterLabels)
+		os.Exit(1)
+	}
+	localClusterAnnotationsMap, err := parseKeyValuePairs(localClusterAnnotations)
+	if err != nil {
+		setupLog.Error(err, "unable to parse local-cluster-annotations", "local-cluster-annotations", localClusterAnnotations)
+		os.Exit(1)
+	}
+	generatorTimeoutsMap, err := parseDurations(generatorTimeouts)
+	if err != nil {
+		setupLog.Error(err, "unable to parse generator-timeouts", "generator-timeouts", generatorTimeouts)
+		os.Exit(1)
+	}
+
 	// If user has not specified a namespace on the CLI, then use the value from NAMESPACE env var
 	if len(namespace) == 0 {
 		// Determine the namespace we're running in. Normally injected into the pod as an env
@@ -129,13 +194,37 @@ func main() {
 	version := common.GetVersion()
 	setupLog.Info(fmt.Sprintf("ApplicationSet controller %s using namespace '%s'", version.Version, namespace), "namespace", namespace, "COMMIT_ID", version.GitCommit)
 
+	var applicationSetNamespacesList []string
+	for _, ns := range strings.Split(applicationSetNamespaces, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			applicationSetNamespacesList = append(applicationSetNamespacesList, ns)
+		}
+	}
+
+	// Our cache and thus watches and client queries are restricted to the namespace we're running in, and the
+	// namespaces of ApplicationSets. This assumes the applicationset controller is in the same namespace as argocd,
+	// which should be the namespace of all cluster Secrets, and of the Applications of ApplicationSets in the argocd
+	// namespace. Glob patterns can only be matched by a cluster-wide cache.
+	newCache := cache.MultiNamespacedCacheBuilder(append([]string{namespace}, applicationSetNamespacesList...))
+	for _, ns := range applicationSetNamespacesList {
+		if utils.IsNamespaceGlob(ns) {
+			newCache = cache.New
+			break
+		}
+	}
+	if len(applicationSetNamespacesList) > 0 {
+		setupLog.Info("reconciling ApplicationSets in additional namespaces", "applicationset-namespaces", applicationSetNamespacesList)
+	}
+
+	if enableSharding && enableLeaderElection {
+		setupLog.Info("sharding and leader election can't be enabled together")
+		os.Exit(1)
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:             scheme,
-		MetricsBindAddress: metricsAddr,
-		// Our cache and thus watches and client queries are restricted to the namespace we're running in. This assumes
-		// the applicationset controller is in the same namespace as argocd, which should be the same namespace of
-		// all cluster Secrets and Applications we interact with.
-		NewCache:               cache.MultiNamespacedCacheBuilder([]string{namespace}),
+		Scheme:                 scheme,
+		MetricsBindAddress:     metricsAddr,
+		NewCache:               newCache,
 		HealthProbeBindAddress: probeBindAddr,
 		Port:                   9443,
 		LeaderElection:         enableLeaderElection,
@@ -147,30 +236,50 @@ func main() {
 		os.Exit(1)
 	}
 
+	// ctx is cancelled on shutdown, which stops the manager and, through it, the reconciliations in progress
+	ctx := ctrl.SetupSignalHandler()
+
 	k8s := kubernetes.NewForConfigOrDie(mgr.GetConfig())
 	dynClient := dynamic.NewForConfigOrDie(mgr.GetConfig())
-	argoSettingsMgr := argosettings.NewSettingsManager(context.Background(), k8s, namespace)
+	argoSettingsMgr := argosettings.NewSettingsManager(ctx, k8s, namespace)
 	appSetConfig := appclientset.NewForConfigOrDie(mgr.GetConfig())
 
 	argoCDDB := db.NewDB(namespace, argoSettingsMgr, k8s)
 
+	// The GnuPG keyring is used to verify commit signatures for Git generators
+	if gpg.IsGPGEnabled() {
+		initializeGnuPG()
+	}
+
 	// start a webhook server that listens to incoming webhook payloads
-	webhookHandler, err := utils.NewWebhookHandler(namespace, argoSettingsMgr, mgr.GetClient())
+	webhookHandler, err := utils.NewWebhookHandler(namespace, applicationSetNamespacesList, argoSettingsMgr, mgr.GetClient(), webhookParallelism, webhookQueueSize)
 	if err != nil {
 		setupLog.Error(err, "failed to create webhook handler")
 	}
 
 	if webhookHandler != nil {
-		startWebhookServer(webhookHandler, webhookAddr)
+		if err := mgr.Add(&webhookServer{handler: webhookHandler, addr: webhookAddr}); err != nil {
+			setupLog.Error(err, "unable to add webhook server to the manager")
+			os.Exit(1)
+		}
 	}
 
 	terminalGenerators := map[string]generators.Generator{
 		"List":                    generators.NewListGenerator(),
-		"Clusters":                generators.NewClusterGenerator(mgr.GetClient(), context.Background(), k8s, namespace),
-		"Git":                     generators.NewGitGenerator(services.NewArgoCDService(argoCDDB, argocdRepoServer)),
-		"SCMProvider":             generators.NewSCMProviderGenerator(mgr.GetClient()),
-		"ClusterDecisionResource": generators.NewDuckTypeGenerator(context.Background(), dynClient, k8s, namespace),
-		"PullRequest":             generators.NewPullRequestGenerator(mgr.GetClient()),
+		"Clusters":                generators.NewClusterGenerator(mgr.GetClient(), ctx, k8s, namespace, localClusterLabelsMap, localClusterAnnotationsMap),
+		"Git":                     generators.NewGitGenerator(services.NewArgoCDService(argoCDDB, argocdRepoServer, gitWorkDir, gitWorkDirQuotaBytes)),
+		"SCMProvider":             generators.NewSCMProviderGenerator(mgr.GetClient(), scm_provider.NewCache(scmProviderCacheTTL, scmProviderMaxRateLimitWait), namespace),
+		"ClusterDecisionResource": generators.NewDuckTypeGenerator(ctx, dynClient, k8s, namespace),
+		"PullRequest":             generators.NewPullRequestGenerator(mgr.GetClient(), namespace),
+	}
+
+	for name, timeout := range generatorTimeoutsMap {
+		generator, exists := terminalGenerators[name]
+		if !exists {
+			setupLog.Info("unknown generator in generator-timeouts", "generator", name)
+			os.Exit(1)
+		}
+		terminalGenerators[name] = generators.NewTimeoutGenerator(generator, timeout)
 	}
 
 	nestedGenerators := map[string]generators.Generator{
@@ -195,17 +304,42 @@ func main() {
 		"Merge":                   generators.NewMergeGenerator(nestedGenerators),
 	}
 
+	var shards *utils.Shards
+	if enableSharding {
+		if shardingReplica == "" {
+			shardingReplica = os.Getenv("POD_NAME")
+		}
+		if shardingReplica == "" {
+			if shardingReplica, err = os.Hostname(); err != nil {
+				setupLog.Error(err, "unable to determine the sharding replica name")
+				os.Exit(1)
+			}
+		}
+		setupLog.Info("sharding enabled", "sharding-replica", shardingReplica)
+		shards = utils.NewShards(k8s, namespace, shardingReplica, shardingLeaseDuration)
+		if err := mgr.Add(shards); err != nil {
+			setupLog.Error(err, "unable to add the shards to the manager")
+			os.Exit(1)
+		}
+	}
+
 	if err = (&controllers.ApplicationSetReconciler{
-		Generators:       topLevelGenerators,
-		Client:           mgr.GetClient(),
-		Log:              ctrl.Log.WithName("controllers").WithName("ApplicationSet"),
-		Scheme:           mgr.GetScheme(),
-		Recorder:         mgr.GetEventRecorderFor("applicationset-controller"),
-		Renderer:         &utils.Render{},
-		Policy:           policyObj,
-		ArgoAppClientset: appSetConfig,
-		KubeClientset:    k8s,
-		ArgoDB:           argoCDDB,
+		Generators:               topLevelGenerators,
+		Client:                   mgr.GetClient(),
+		Log:                      ctrl.Log.WithName("controllers").WithName("ApplicationSet"),
+		Scheme:                   mgr.GetScheme(),
+		Recorder:                 mgr.GetEventRecorderFor("applicationset-controller"),
+		Renderer:                 &utils.Render{},
+		Policy:                   policyObj,
+		ArgoAppClientset:         appSetConfig,
+		KubeClientset:            k8s,
+		ArgoDB:                   argoCDDB,
+		ArgoCDNamespace:          namespace,
+		ApplicationSetNamespaces: applicationSetNamespacesList,
+		EnableServerSideApply:    enableServerSideApply,
+		MaxConcurrentReconciles:  concurrentReconciliations,
+		ReconcileTimeout:         reconcileTimeout,
+		Shards:                   shards,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ApplicationSet")
 		os.Exit(1)
@@ -216,7 +350,7 @@ func main() {
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("Starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	if err := mgr.Start(ctx); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
@@ -236,15 +370,108 @@ func setLoggingLevel(debug bool, logLevel string) {
 	}
 }
 
-func startWebhookServer(webhookHandler *utils.WebhookHandler, webhookAddr string) {
+// parseKeyValuePairs parses a comma separated list of key=value pairs.
+func parseKeyValuePairs(s string) (map[string]string, error) {
+	res := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("'%s' is not a key=value pair", pair)
+		}
+		res[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return res, nil
+}
+
+// parseDurations parses comma separated key=duration pairs. Durations must be positive.
+func parseDurations(s string) (map[string]time.Duration, error) {
+	pairs, err := parseKeyValuePairs(s)
+	if err != nil {
+		return nil, err
+	}
+	res := map[string]time.Duration{}
+	for key, value := range pairs {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration for '%s': %w", key, err)
+		}
+		if duration <= 0 {
+			return nil, fmt.Errorf("duration for '%s' must be positive", key)
+		}
+		res[key] = duration
+	}
+	return res, nil
+}
+
+// initializeGnuPG initializes the GnuPG keyring from the Argo CD GnuPG keys, and keeps it in sync with them. Errors
+// are only logged, since the keyring is only needed by Git generators which verify commit signatures.
+func initializeGnuPG() {
+	sourcePath := gnuPGSourcePath
+	if path := os.Getenv("ARGOCD_GPG_DATA_PATH"); path != "" {
+		sourcePath = path
+	}
+
+	setupLog.Info("Initializing GnuPG keyring", "path", argocommon.GetGnuPGHomePath())
+	if err := gpg.InitializeGnuPG(); err != nil {
+		setupLog.Error(err, "unable to initialize GnuPG keyring, commit signatures cannot be verified")
+		return
+	}
+
+	added, removed, err := gpg.SyncKeyRingFromDirectory(sourcePath)
+	if err != nil {
+		setupLog.Error(err, "unable to populate GnuPG keyring", "source", sourcePath)
+		return
+	}
+	setupLog.Info(fmt.Sprintf("Loaded %d (and removed %d) keys from keyring", len(added), len(removed)), "source", sourcePath)
+
+	go func() {
+		if err := utils.StartGPGWatcher(sourcePath); err != nil {
+			setupLog.Error(err, "GnuPG keyring is no longer synced", "source", sourcePath)
+		}
+	}()
+}
+
+// webhookShutdownTimeout bounds the time taken to drain the webhook events on shutdown, within the default
+// graceful shutdown timeout of the manager
+const webhookShutdownTimeout = 20 * time.Second
+
+// webhookServer serves the webhook endpoints. It is run by the manager, so that the queued webhook events are
+// handled before the manager stops.
+type webhookServer struct {
+	handler *utils.WebhookHandler
+	addr    string
+}
+
+func (s *webhookServer) Start(ctx context.Context) error {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/api/webhook", webhookHandler.Handler)
+	mux.HandleFunc("/api/webhook", s.handler.Handler)
+	mux.HandleFunc("/api/webhook/refresh", s.handler.RefreshHandler)
+	server := &http.Server{Addr: s.addr, Handler: mux}
+
+	errCh := make(chan error, 1)
 	go func() {
 		setupLog.Info("Starting webhook server")
-		err := http.ListenAndServe(webhookAddr, mux)
-		if err != nil {
-			setupLog.Error(err, "failed to start webhook server")
-			os.Exit(1)
-		}
+		errCh <- server.ListenAndServe()
 	}()
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("failed to start webhook server: %w", err)
+	case <-ctx.Done():
+	}
+
+	setupLog.Info("Stopping webhook server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), webhookShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		setupLog.Error(err, "failed to stop webhook server")
+	}
+	return s.handler.Shutdown(shutdownCtx)
+}
+
+// NeedLeaderElection returns false, since every replica receives webhook events
+func (s *webhookServer) NeedLeaderElection() bool {
+	return false
 }
@@ -0,0 +1,187 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
+
+	argoprojiov1alpha1 "github.com/argoproj/applicationset/api/v1alpha1"
+	"github.com/argoproj/applicationset/common"
+	"github.com/argoproj/applicationset/pkg/utils"
+)
+
+// ApplicationSetFieldManager is the field manager of the Application fields which are applied by the ApplicationSet
+// controller, when server-side apply is enabled.
+const ApplicationSetFieldManager = "applicationset-controller"
+
+// applyApplication applies the generated Application with server-side apply, as the ApplicationSet field manager.
+// Only the fields set by the template are owned by the field manager, so fields added to the Application by other
+// managers (such as the argo cd notifications state) are left as they are, and a template field which was changed
+// by another manager is reported as a conflict, rather than being overwritten.
+// found is set to the Application as it was before it was applied.
+// Returns the performed operation, and the fields of an existing Application that were changed by the apply.
+func (r *ApplicationSetReconciler) applyApplication(ctx context.Context, applicationSet argoprojiov1alpha1.ApplicationSet, generatedApp argov1alpha1.Application, found *argov1alpha1.Application) (controllerutil.OperationResult, []string, error) {
+
+	exists := true
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(found), found); err != nil {
+		if !apierr.IsNotFound(err) {
+			return controllerutil.OperationResultNone, nil, err
+		}
+		exists = false
+	}
+
+	if exists {
+		if err := r.checkApplicationOwner(applicationSet, found); err != nil {
+			return controllerutil.OperationResultNone, nil, err
+		}
+	}
+
+	if exists && found.Labels[common.LabelApplicationSetOrphaned] != "" {
+		// The orphaned Application is adopted again: the orphan label and annotation are not owned by the
+		// ApplicationSet field manager, so they would otherwise be left as they are by the apply
+		adopted := found.DeepCopy()
+		delete(adopted.Labels, common.LabelApplicationSetOrphaned)
+		delete(adopted.Annotations, common.AnnotationApplicationSetOrphanedFrom)
+		if err := r.Client.Patch(ctx, adopted, client.MergeFrom(found)); err != nil {
+			return controllerutil.OperationResultNone, nil, err
+		}
+	}
+
+	if exists {
+		if err := r.upgradeManagedFields(ctx, found); err != nil {
+			return controllerutil.OperationResultNone, nil, fmt.Errorf("failed to upgrade the managed fields of the Application: %w", err)
+		}
+	}
+
+	desired := generatedApp.DeepCopy()
+	if exists {
+		// Applying the live value of an ignored field does not conflict with the manager which set it
+		if err := utils.ApplyIgnoreDifferences(applicationSet.Spec.IgnoreApplicationDifferences, found, desired); err != nil {
+			return controllerutil.OperationResultNone, nil, fmt.Errorf("failed to apply ignoreApplicationDifferences: %w", err)
+		}
+	}
+
+	applyConfig, err := applicationApplyConfiguration(applicationSet, desired, r.ownsApplicationsByReference(applicationSet))
+	if err != nil {
+		return controllerutil.OperationResultNone, nil, err
+	}
+
+	if err := r.Client.Patch(ctx, applyConfig, client.Apply, client.FieldOwner(ApplicationSetFieldManager)); err != nil {
+		return controllerutil.OperationResultNone, nil, err
+	}
+
+	if !exists {
+		return controllerutil.OperationResultCreated, nil, nil
+	}
+	if applyConfig.GetResourceVersion() == found.ResourceVersion {
+		return controllerutil.OperationResultNone, nil, nil
+	}
+
+	// The unstructured converter can't be used with Applications, which have unexported fields
+	appliedJSON, err := applyConfig.MarshalJSON()
+	if err != nil {
+		return controllerutil.OperationResultUpdated, nil, err
+	}
+	applied := &argov1alpha1.Application{}
+	if err := json.Unmarshal(appliedJSON, applied); err != nil {
+		return controllerutil.OperationResultUpdated, nil, err
+	}
+	return controllerutil.OperationResultUpdated, applicationDrift(found, applied), nil
+}
+
+// upgradeManagedFields hands the fields which the controller set with updates (before server-side apply was
+// enabled) over to the ApplicationSet field manager. The apiserver records them as owned by the same manager name
+// (derived from the name of the controller binary), but with the Update operation, which is a different owner than
+// the apply: the apply would otherwise conflict with them when the template changes, and would never remove them
+// from the Application when they are removed from the template. found is updated with the patched Application.
+func (r *ApplicationSetReconciler) upgradeManagedFields(ctx context.Context, found *argov1alpha1.Application) error {
+	upgrade := false
+	owned := &fieldpath.Set{}
+	managedFields := []metav1.ManagedFieldsEntry{}
+	for _, entry := range found.ManagedFields {
+		if entry.Manager != ApplicationSetFieldManager || entry.Subresource != "" || entry.FieldsV1 == nil ||
+			(entry.Operation != metav1.ManagedFieldsOperationUpdate && entry.Operation != metav1.ManagedFieldsOperationApply) {
+			managedFields = append(managedFields, entry)
+			continue
+		}
+		if entry.Operation == metav1.ManagedFieldsOperationUpdate {
+			upgrade = true
+		}
+		fields := &fieldpath.Set{}
+		if err := fields.FromJSON(bytes.NewReader(entry.FieldsV1.Raw)); err != nil {
+			return err
+		}
+		owned = owned.Union(fields)
+	}
+	if !upgrade {
+		return nil
+	}
+
+	ownedJSON, err := owned.ToJSON()
+	if err != nil {
+		return err
+	}
+	now := metav1.Now()
+	managedFields = append(managedFields, metav1.ManagedFieldsEntry{
+		Manager:    ApplicationSetFieldManager,
+		Operation:  metav1.ManagedFieldsOperationApply,
+		APIVersion: argov1alpha1.ApplicationSchemaGroupVersionKind.GroupVersion().String(),
+		Time:       &now,
+		FieldsType: "FieldsV1",
+		FieldsV1:   &metav1.FieldsV1{Raw: ownedJSON},
+	})
+
+	upgraded := found.DeepCopy()
+	upgraded.ManagedFields = managedFields
+	if err := r.Client.Patch(ctx, upgraded, client.MergeFromWithOptions(found, client.MergeFromWithOptimisticLock{})); err != nil {
+		return err
+	}
+	upgraded.DeepCopyInto(found)
+	return nil
+}
+
+// applicationApplyConfiguration returns the server-side apply configuration of the generated Application: only the
+// significant Application/ObjectMeta fields, and the owner reference (or, if ownerReference is false, the owner
+// annotation) to the ApplicationSet. Unlike the typed Application, it does not contain any (empty) status or metadata
+// fields, which would otherwise become owned by the ApplicationSet field manager.
+func applicationApplyConfiguration(applicationSet argoprojiov1alpha1.ApplicationSet, app *argov1alpha1.Application, ownerReference bool) (*unstructured.Unstructured, error) {
+	specJSON, err := json.Marshal(app.Spec)
+	if err != nil {
+		return nil, err
+	}
+	spec := map[string]interface{}{}
+	if err := json.Unmarshal(specJSON, &spec); err != nil {
+		return nil, err
+	}
+
+	applyConfig := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	applyConfig.SetGroupVersionKind(argov1alpha1.ApplicationSchemaGroupVersionKind)
+	applyConfig.SetName(app.Name)
+	applyConfig.SetNamespace(app.Namespace)
+	applyConfig.SetLabels(app.Labels)
+	applyConfig.SetFinalizers(app.Finalizers)
+	if ownerReference {
+		applyConfig.SetAnnotations(app.Annotations)
+		applyConfig.SetOwnerReferences([]metav1.OwnerReference{
+			*metav1.NewControllerRef(&applicationSet, argoprojiov1alpha1.GroupVersion.WithKind("ApplicationSet")),
+		})
+		return applyConfig, nil
+	}
+
+	annotations := map[string]string{}
+	for key, value := range app.Annotations {
+		annotations[key] = value
+	}
+	annotations[common.AnnotationApplicationSetOwner] = applicationSetKey(applicationSet)
+	applyConfig.SetAnnotations(annotations)
+	return applyConfig, nil
+}
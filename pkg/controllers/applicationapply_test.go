@@ -0,0 +1,409 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	crtclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	argoprojiov1alpha1 "github.com/argoproj/applicationset/api/v1alpha1"
+	"github.com/argoproj/applicationset/common"
+)
+
+// applyClient emulates server-side apply on top of the fake client, which does not support apply patches: the
+// apply configuration is created, or merged into the existing object. fieldManagers records the field manager of
+// each apply, and conflict (if set) is returned instead of applying.
+type applyClient struct {
+	crtclient.Client
+	fieldManagers []string
+	conflict      error
+}
+
+func (c *applyClient) Patch(ctx context.Context, obj crtclient.Object, patch crtclient.Patch, opts ...crtclient.PatchOption) error {
+	if patch.Type() != types.ApplyPatchType {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+	patchOptions := &crtclient.PatchOptions{}
+	patchOptions.ApplyOptions(opts)
+	c.fieldManagers = append(c.fieldManagers, patchOptions.FieldManager)
+	if c.conflict != nil {
+		return c.conflict
+	}
+
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(obj.GetObjectKind().GroupVersionKind())
+	if err := c.Client.Get(ctx, crtclient.ObjectKeyFromObject(obj), existing); err != nil {
+		if !apierr.IsNotFound(err) {
+			return err
+		}
+		return c.Client.Create(ctx, obj)
+	}
+	return c.Client.Patch(ctx, obj, crtclient.RawPatch(types.MergePatchType, data))
+}
+
+func TestApplicationApplyConfiguration(t *testing.T) {
+	appSet := argoprojiov1alpha1.ApplicationSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "name",
+			Namespace: "namespace",
+			UID:       "uid",
+		},
+	}
+	app := &argov1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app1",
+			Namespace: "namespace",
+			Labels:    map[string]string{"label-key": "label-value"},
+		},
+		Spec: argov1alpha1.ApplicationSpec{
+			Project: "project",
+		},
+	}
+
+	applyConfig, err := applicationApplyConfiguration(appSet, app, true)
+	assert.Nil(t, err)
+
+	isController := true
+	blockOwnerDeletion := true
+	assert.Equal(t, map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "Application",
+		"metadata": map[string]interface{}{
+			"name":      "app1",
+			"namespace": "namespace",
+			"labels":    map[string]interface{}{"label-key": "label-value"},
+			"ownerReferences": []interface{}{
+				map[string]interface{}{
+					"apiVersion":         "argoproj.io/v1alpha1",
+					"kind":               "ApplicationSet",
+					"name":               "name",
+					"uid":                "uid",
+					"controller":         isController,
+					"blockOwnerDeletion": blockOwnerDeletion,
+				},
+			},
+		},
+		"spec": map[string]interface{}{
+			"destination": map[string]interface{}{},
+			"project":     "project",
+			"source":      map[string]interface{}{"repoURL": ""},
+		},
+	}, applyConfig.Object)
+}
+
+func TestApplicationApplyConfigurationInOtherNamespace(t *testing.T) {
+	appSet := argoprojiov1alpha1.ApplicationSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "name",
+			Namespace: "team",
+			UID:       "uid",
+		},
+	}
+	app := &argov1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app1",
+			Namespace:   "argocd",
+			Annotations: map[string]string{"annotation-key": "annotation-value"},
+		},
+		Spec: argov1alpha1.ApplicationSpec{
+			Project: "project",
+		},
+	}
+
+	applyConfig, err := applicationApplyConfiguration(appSet, app, false)
+	assert.Nil(t, err)
+
+	// The ApplicationSet is referenced by the owner annotation, rather than an owner reference
+	assert.Equal(t, "argocd", applyConfig.GetNamespace())
+	assert.Empty(t, applyConfig.GetOwnerReferences())
+	assert.Equal(t, map[string]string{
+		"annotation-key":                     "annotation-value",
+		common.AnnotationApplicationSetOwner: "team/name",
+	}, applyConfig.GetAnnotations())
+	// The generated Application is left as it is
+	assert.Len(t, app.Annotations, 1)
+}
+
+func TestCreateOrUpdateInClusterWithServerSideApply(t *testing.T) {
+	scheme := runtime.NewScheme()
+	err := argoprojiov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+	err = argov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+
+	appSet := argoprojiov1alpha1.ApplicationSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "name",
+			Namespace: "namespace",
+		},
+	}
+	existingApp := argov1alpha1.Application{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Application",
+			APIVersion: "argoproj.io/v1alpha1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app1",
+			Namespace: "namespace",
+			// Set by another field manager
+			Annotations: map[string]string{"annot-key": "annot-value"},
+		},
+		Spec: argov1alpha1.ApplicationSpec{
+			Project: "test",
+		},
+	}
+	err = controllerutil.SetControllerReference(&appSet, &existingApp, scheme)
+	assert.Nil(t, err)
+
+	desiredApps := []argov1alpha1.Application{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "app1",
+			},
+			Spec: argov1alpha1.ApplicationSpec{
+				Project: "project",
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "app2",
+			},
+			Spec: argov1alpha1.ApplicationSpec{
+				Project: "project",
+			},
+		},
+	}
+
+	client := &applyClient{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(&appSet, &existingApp).Build()}
+	recorder := record.NewFakeRecorder(2)
+	r := ApplicationSetReconciler{
+		Client:                client,
+		Scheme:                scheme,
+		Recorder:              recorder,
+		EnableServerSideApply: true,
+	}
+
+	err = r.createOrUpdateInCluster(context.TODO(), appSet, desiredApps)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{ApplicationSetFieldManager, ApplicationSetFieldManager}, client.fieldManagers)
+
+	got := &argov1alpha1.Application{}
+	err = client.Get(context.Background(), crtclient.ObjectKey{Namespace: "namespace", Name: "app1"}, got)
+	assert.Nil(t, err)
+	assert.Equal(t, "project", got.Spec.Project)
+	// Fields which are not set by the template are left as they are
+	assert.Equal(t, map[string]string{"annot-key": "annot-value"}, got.Annotations)
+
+	err = client.Get(context.Background(), crtclient.ObjectKey{Namespace: "namespace", Name: "app2"}, got)
+	assert.Nil(t, err)
+	assert.Equal(t, "project", got.Spec.Project)
+	assert.True(t, metav1.IsControlledBy(got, &appSet))
+
+	assert.Equal(t, `Normal updated updated Application "app1": spec changed to match the template`, <-recorder.Events)
+	assert.Equal(t, `Normal created created Application "app2"`, <-recorder.Events)
+}
+
+func TestCreateOrUpdateInClusterWithServerSideApplyUpgradesManagedFields(t *testing.T) {
+	scheme := runtime.NewScheme()
+	err := argoprojiov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+	err = argov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+
+	appSet := argoprojiov1alpha1.ApplicationSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "name",
+			Namespace: "namespace",
+		},
+	}
+	// Created by the controller with updates, before server-side apply was enabled
+	existingApp := argov1alpha1.Application{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Application",
+			APIVersion: "argoproj.io/v1alpha1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app1",
+			Namespace:   "namespace",
+			Labels:      map[string]string{"removed-from-template": "value"},
+			Annotations: map[string]string{"annot-key": "annot-value"},
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				{
+					Manager:    ApplicationSetFieldManager,
+					Operation:  metav1.ManagedFieldsOperationUpdate,
+					APIVersion: "argoproj.io/v1alpha1",
+					FieldsType: "FieldsV1",
+					FieldsV1:   &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:labels":{"f:removed-from-template":{}}},"f:spec":{"f:project":{}}}`)},
+				},
+				{
+					Manager:    "kubectl-edit",
+					Operation:  metav1.ManagedFieldsOperationUpdate,
+					APIVersion: "argoproj.io/v1alpha1",
+					FieldsType: "FieldsV1",
+					FieldsV1:   &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:annotations":{"f:annot-key":{}}}}`)},
+				},
+			},
+		},
+		Spec: argov1alpha1.ApplicationSpec{
+			Project: "test",
+		},
+	}
+	err = controllerutil.SetControllerReference(&appSet, &existingApp, scheme)
+	assert.Nil(t, err)
+
+	desiredApps := []argov1alpha1.Application{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "app1",
+			},
+			Spec: argov1alpha1.ApplicationSpec{
+				Project: "project",
+			},
+		},
+	}
+
+	client := &applyClient{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(&appSet, &existingApp).Build()}
+	r := ApplicationSetReconciler{
+		Client:                client,
+		Scheme:                scheme,
+		Recorder:              record.NewFakeRecorder(1),
+		EnableServerSideApply: true,
+	}
+
+	err = r.createOrUpdateInCluster(context.TODO(), appSet, desiredApps)
+	assert.Nil(t, err)
+
+	got := &argov1alpha1.Application{}
+	err = client.Get(context.Background(), crtclient.ObjectKey{Namespace: "namespace", Name: "app1"}, got)
+	assert.Nil(t, err)
+	assert.Equal(t, "project", got.Spec.Project)
+	// The fields set by the controller's updates are now owned by its apply, so that the apply doesn't conflict
+	// with them, and removes those which are no longer in the template
+	if assert.Len(t, got.ManagedFields, 2) {
+		assert.Equal(t, "kubectl-edit", got.ManagedFields[0].Manager)
+		assert.Equal(t, ApplicationSetFieldManager, got.ManagedFields[1].Manager)
+		assert.Equal(t, metav1.ManagedFieldsOperationApply, got.ManagedFields[1].Operation)
+		assert.JSONEq(t, `{"f:metadata":{"f:labels":{"f:removed-from-template":{}}},"f:spec":{"f:project":{}}}`, string(got.ManagedFields[1].FieldsV1.Raw))
+	}
+
+	// The managed fields are only upgraded once
+	before := got.ResourceVersion
+	err = r.upgradeManagedFields(context.TODO(), got)
+	assert.Nil(t, err)
+	assert.Equal(t, before, got.ResourceVersion)
+}
+
+func TestCreateOrUpdateInClusterWithServerSideApplyConflict(t *testing.T) {
+	scheme := runtime.NewScheme()
+	err := argoprojiov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+	err = argov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+
+	appSet := argoprojiov1alpha1.ApplicationSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "name",
+			Namespace: "namespace",
+		},
+	}
+
+	conflict := apierr.NewApplyConflict([]metav1.StatusCause{
+		{
+			Type:    metav1.CauseTypeFieldManagerConflict,
+			Message: `conflict with "kubectl-edit"`,
+			Field:   ".spec.project",
+		},
+	}, `Apply failed with 1 conflict: conflict with "kubectl-edit": .spec.project`)
+	client := &applyClient{
+		Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(&appSet).Build(),
+		conflict: conflict,
+	}
+	recorder := record.NewFakeRecorder(1)
+	r := ApplicationSetReconciler{
+		Client:                client,
+		Scheme:                scheme,
+		Recorder:              recorder,
+		EnableServerSideApply: true,
+	}
+
+	err = r.createOrUpdateInCluster(context.TODO(), appSet, []argov1alpha1.Application{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "app1",
+			},
+		},
+	})
+	assert.Equal(t, conflict, err)
+	assert.Equal(t, `Warning ApplyConflict failed to apply Application "app1": Apply failed with 1 conflict: conflict with "kubectl-edit": .spec.project`, <-recorder.Events)
+}
+
+func TestCreateOrUpdateInClusterWithServerSideApplyAdoptsOrphan(t *testing.T) {
+	scheme := runtime.NewScheme()
+	err := argoprojiov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+	err = argov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+
+	appSet := argoprojiov1alpha1.ApplicationSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "name",
+			Namespace: "namespace",
+		},
+	}
+	orphanedApp := argov1alpha1.Application{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Application",
+			APIVersion: "argoproj.io/v1alpha1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app1",
+			Namespace:   "namespace",
+			Labels:      map[string]string{common.LabelApplicationSetOrphaned: "true"},
+			Annotations: map[string]string{common.AnnotationApplicationSetOrphanedFrom: "name"},
+		},
+		Spec: argov1alpha1.ApplicationSpec{
+			Project: "project",
+		},
+	}
+
+	client := &applyClient{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(&appSet, &orphanedApp).Build()}
+	r := ApplicationSetReconciler{
+		Client:                client,
+		Scheme:                scheme,
+		Recorder:              record.NewFakeRecorder(1),
+		EnableServerSideApply: true,
+	}
+
+	err = r.createOrUpdateInCluster(context.TODO(), appSet, []argov1alpha1.Application{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "app1",
+			},
+			Spec: argov1alpha1.ApplicationSpec{
+				Project: "project",
+			},
+		},
+	})
+	assert.Nil(t, err)
+
+	got := &argov1alpha1.Application{}
+	err = client.Get(context.Background(), crtclient.ObjectKey{Namespace: "namespace", Name: "app1"}, got)
+	assert.Nil(t, err)
+	assert.True(t, metav1.IsControlledBy(got, &appSet))
+	assert.Empty(t, got.Labels)
+	assert.Empty(t, got.Annotations)
+}
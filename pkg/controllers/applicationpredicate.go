@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	argoprojiov1alpha1 "github.com/argoproj/applicationset/api/v1alpha1"
+	"github.com/argoproj/applicationset/pkg/utils"
+)
+
+// preservedAnnotationKeys are the annotations of generated Applications which are set by other controllers or by
+// users, and thus are preserved rather than reverted to the template.
+var preservedAnnotationKeys = []string{
+	// argo cd notifications state (https://github.com/argoproj/applicationset/issues/180)
+	NotifiedAnnotationKey,
+	// refresh requests, which are removed by the Argo CD application controller once processed
+	argov1alpha1.AnnotationKeyRefresh,
+}
+
+// ownedApplicationPredicate filters the events of owned Applications down to those which may have caused them to
+// drift from their ApplicationSet's template, so that ApplicationSets are not reconciled for every status update
+// of their Applications. Changes to the fields which the ApplicationSet (read with c) ignores are not drift either.
+func ownedApplicationPredicate(c client.Reader) predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldApp, isApp := e.ObjectOld.(*argov1alpha1.Application)
+			if !isApp {
+				return false
+			}
+			newApp, isApp := e.ObjectNew.(*argov1alpha1.Application)
+			if !isApp {
+				return false
+			}
+			if !utils.DeepEqual(oldApp.OwnerReferences, newApp.OwnerReferences) {
+				return true
+			}
+			ignoreDifferences, err := ownerIgnoreDifferences(c, newApp)
+			if err != nil {
+				log.WithError(err).WithField("app", newApp.Name).Warn("unable to get the ignoreApplicationDifferences of the ApplicationSet")
+				return true
+			}
+			if len(ignoreDifferences) > 0 {
+				// The ignored fields of the old Application are set to those of the new one, so that they don't differ
+				ignored := oldApp.DeepCopy()
+				if err := utils.ApplyIgnoreDifferences(ignoreDifferences, newApp, ignored); err != nil {
+					log.WithError(err).WithField("app", newApp.Name).Warn("failed to apply ignoreApplicationDifferences")
+					return true
+				}
+				oldApp = ignored
+			}
+			return len(applicationDrift(oldApp, newApp)) > 0
+		},
+	}
+}
+
+// ownerIgnoreDifferences returns the ignoreApplicationDifferences of the ApplicationSet which owns the Application,
+// if any.
+func ownerIgnoreDifferences(c client.Reader, app *argov1alpha1.Application) ([]argoprojiov1alpha1.ApplicationSetResourceIgnoreDifferences, error) {
+	owner := applicationOwner(app)
+	if owner == "" || strings.HasPrefix(owner, foreignOwnerPrefix) {
+		return nil, nil
+	}
+	key := strings.SplitN(owner, "/", 2)
+	if len(key) != 2 {
+		return nil, nil
+	}
+	applicationSet := &argoprojiov1alpha1.ApplicationSet{}
+	if err := c.Get(context.Background(), types.NamespacedName{Namespace: key[0], Name: key[1]}, applicationSet); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return applicationSet.Spec.IgnoreApplicationDifferences, nil
+}
+
+// applicationDrift returns the names of the fields managed by the ApplicationSet controller (spec, labels,
+// annotations and finalizers) which differ between the live and the desired Application. Preserved annotations are
+// ignored.
+func applicationDrift(live *argov1alpha1.Application, desired *argov1alpha1.Application) []string {
+	res := []string{}
+	if !utils.DeepEqual(live.Spec, desired.Spec) {
+		res = append(res, "spec")
+	}
+	if !utils.DeepEqual(live.Labels, desired.Labels) {
+		res = append(res, "labels")
+	}
+	if !utils.DeepEqual(withoutPreservedAnnotations(live.Annotations), withoutPreservedAnnotations(desired.Annotations)) {
+		res = append(res, "annotations")
+	}
+	liveFinalizers := append([]string{}, live.Finalizers...)
+	desiredFinalizers := append([]string{}, desired.Finalizers...)
+	sort.Strings(liveFinalizers)
+	sort.Strings(desiredFinalizers)
+	if !utils.DeepEqual(liveFinalizers, desiredFinalizers) {
+		res = append(res, "finalizers")
+	}
+	return res
+}
+
+func withoutPreservedAnnotations(annotations map[string]string) map[string]string {
+	res := map[string]string{}
+	for key, value := range annotations {
+		res[key] = value
+	}
+	for _, key := range preservedAnnotationKeys {
+		delete(res, key)
+	}
+	return res
+}
@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	argoprojiov1alpha1 "github.com/argoproj/applicationset/api/v1alpha1"
+	"github.com/argoproj/applicationset/common"
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	crtclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestOwnedApplicationPredicate(t *testing.T) {
+	app := argov1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app",
+			Namespace:   "argocd",
+			Labels:      map[string]string{"label-key": "label-value"},
+			Annotations: map[string]string{"annot-key": "annot-value"},
+			Finalizers:  []string{"resources-finalizer.argocd.argoproj.io"},
+		},
+		Spec: argov1alpha1.ApplicationSpec{
+			Project: "default",
+		},
+	}
+
+	scheme := runtime.NewScheme()
+	err := argoprojiov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+	client := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+	for _, c := range []struct {
+		name     string
+		mutate   func(app *argov1alpha1.Application)
+		expected bool
+	}{
+		{
+			name: "status change is ignored",
+			mutate: func(app *argov1alpha1.Application) {
+				app.Status.Sync.Status = argov1alpha1.SyncStatusCodeOutOfSync
+				app.Status.Health.Status = "Degraded"
+			},
+			expected: false,
+		},
+		{
+			name: "operation change is ignored",
+			mutate: func(app *argov1alpha1.Application) {
+				app.Operation = &argov1alpha1.Operation{Sync: &argov1alpha1.SyncOperation{}}
+			},
+			expected: false,
+		},
+		{
+			name: "preserved annotation change is ignored",
+			mutate: func(app *argov1alpha1.Application) {
+				app.Annotations[NotifiedAnnotationKey] = "{}"
+				app.Annotations[argov1alpha1.AnnotationKeyRefresh] = "normal"
+			},
+			expected: false,
+		},
+		{
+			name: "spec change",
+			mutate: func(app *argov1alpha1.Application) {
+				app.Spec.Project = "other"
+			},
+			expected: true,
+		},
+		{
+			name: "label change",
+			mutate: func(app *argov1alpha1.Application) {
+				app.Labels["label-key"] = "other"
+			},
+			expected: true,
+		},
+		{
+			name: "annotation change",
+			mutate: func(app *argov1alpha1.Application) {
+				delete(app.Annotations, "annot-key")
+			},
+			expected: true,
+		},
+		{
+			name: "finalizer change",
+			mutate: func(app *argov1alpha1.Application) {
+				app.Finalizers = nil
+			},
+			expected: true,
+		},
+		{
+			name: "owner reference change",
+			mutate: func(app *argov1alpha1.Application) {
+				app.OwnerReferences = nil
+			},
+			expected: true,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			oldApp := app.DeepCopy()
+			oldApp.OwnerReferences = []metav1.OwnerReference{{Kind: "ApplicationSet", Name: "appset"}}
+			newApp := oldApp.DeepCopy()
+			c.mutate(newApp)
+
+			got := ownedApplicationPredicate(client).Update(event.UpdateEvent{ObjectOld: oldApp, ObjectNew: newApp})
+			assert.Equal(t, c.expected, got)
+		})
+	}
+}
+
+func TestOwnedApplicationPredicateIgnoreDifferences(t *testing.T) {
+	scheme := runtime.NewScheme()
+	err := argoprojiov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+
+	appSet := &argoprojiov1alpha1.ApplicationSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "appset", Namespace: "argocd"},
+		Spec: argoprojiov1alpha1.ApplicationSetSpec{
+			IgnoreApplicationDifferences: []argoprojiov1alpha1.ApplicationSetResourceIgnoreDifferences{
+				{JQPathExpressions: []string{`.spec.source.helm.parameters[] | select(.name == "image.tag").value`}},
+			},
+		},
+	}
+	tenantAppSet := appSet.DeepCopy()
+	tenantAppSet.Namespace = "team-a"
+	client := fake.NewClientBuilder().WithScheme(scheme).WithObjects(appSet, tenantAppSet).Build()
+
+	owned := &argov1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "argocd"},
+		Spec: argov1alpha1.ApplicationSpec{
+			Source: argov1alpha1.ApplicationSource{
+				TargetRevision: "main",
+				Helm: &argov1alpha1.ApplicationSourceHelm{
+					Parameters: []argov1alpha1.HelmParameter{{Name: "replicas", Value: "1"}, {Name: "image.tag", Value: "v1"}},
+				},
+			},
+		},
+	}
+	err = controllerutil.SetControllerReference(appSet, owned, scheme)
+	assert.Nil(t, err)
+	annotated := owned.DeepCopy()
+	annotated.OwnerReferences = nil
+	annotated.Annotations = map[string]string{common.AnnotationApplicationSetOwner: "team-a/appset"}
+	unknownOwner := owned.DeepCopy()
+	unknownOwner.OwnerReferences = nil
+	unknownOwner.Annotations = map[string]string{common.AnnotationApplicationSetOwner: "team-a/unknown"}
+
+	for _, app := range []*argov1alpha1.Application{owned, annotated} {
+		// A change to an ignored field is not drift
+		newApp := app.DeepCopy()
+		newApp.Spec.Source.Helm.Parameters[1].Value = "v2"
+		assert.False(t, ownedApplicationPredicate(client).Update(event.UpdateEvent{ObjectOld: app, ObjectNew: newApp}))
+
+		// A change to another field is
+		newApp.Spec.Source.TargetRevision = "dev"
+		assert.True(t, ownedApplicationPredicate(client).Update(event.UpdateEvent{ObjectOld: app, ObjectNew: newApp}))
+	}
+
+	// Without its ApplicationSet, any change to an Application is drift
+	newApp := unknownOwner.DeepCopy()
+	newApp.Spec.Source.Helm.Parameters[1].Value = "v2"
+	assert.True(t, ownedApplicationPredicate(client).Update(event.UpdateEvent{ObjectOld: unknownOwner, ObjectNew: newApp}))
+}
+
+func TestCreateOrUpdateInClusterRevertsDrift(t *testing.T) {
+	scheme := runtime.NewScheme()
+	err := argoprojiov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+	err = argov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+
+	appSet := argoprojiov1alpha1.ApplicationSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "name",
+			Namespace: "namespace",
+		},
+	}
+	existingApp := argov1alpha1.Application{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Application",
+			APIVersion: "argoproj.io/v1alpha1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app1",
+			Namespace: "namespace",
+			Labels:    map[string]string{"label-key": "edited"},
+			Annotations: map[string]string{
+				argov1alpha1.AnnotationKeyRefresh: "normal",
+			},
+		},
+		Spec: argov1alpha1.ApplicationSpec{
+			Project: "edited",
+		},
+	}
+	err = controllerutil.SetControllerReference(&appSet, &existingApp, scheme)
+	assert.Nil(t, err)
+
+	client := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&appSet, &existingApp).Build()
+	recorder := record.NewFakeRecorder(1)
+	r := ApplicationSetReconciler{
+		Client:   client,
+		Scheme:   scheme,
+		Recorder: recorder,
+	}
+
+	err = r.createOrUpdateInCluster(context.TODO(), appSet, []argov1alpha1.Application{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "app1",
+				Labels: map[string]string{"label-key": "label-value"},
+			},
+			Spec: argov1alpha1.ApplicationSpec{
+				Project: "project",
+			},
+		},
+	})
+	assert.Nil(t, err)
+
+	got := &argov1alpha1.Application{}
+	err = client.Get(context.Background(), crtclient.ObjectKey{Namespace: "namespace", Name: "app1"}, got)
+	assert.Nil(t, err)
+	assert.Equal(t, "project", got.Spec.Project)
+	assert.Equal(t, map[string]string{"label-key": "label-value"}, got.Labels)
+	// The pending refresh request is preserved
+	assert.Equal(t, map[string]string{argov1alpha1.AnnotationKeyRefresh: "normal"}, got.Annotations)
+
+	assert.Equal(t, `Normal updated updated Application "app1": spec, labels changed to match the template`, <-recorder.Events)
+}
@@ -16,7 +16,12 @@ package controllers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/argoproj/applicationset/common"
@@ -28,10 +33,16 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	log "github.com/sirupsen/logrus"
@@ -64,6 +75,22 @@ type ApplicationSetReconciler struct {
 	ArgoDB           db.ArgoDB
 	ArgoAppClientset appclientset.Interface
 	KubeClientset    kubernetes.Interface
+	// ArgoCDNamespace is the namespace of Argo CD, where its cluster secrets and AppProjects are read from. Defaults
+	// to the namespace of each ApplicationSet.
+	ArgoCDNamespace string
+	// ApplicationSetNamespaces are the namespaces (or glob patterns) other than the Argo CD namespace, in which
+	// ApplicationSets are reconciled
+	ApplicationSetNamespaces []string
+	// EnableServerSideApply applies the generated Applications with server-side apply, rather than updating them
+	EnableServerSideApply bool
+	// MaxConcurrentReconciles is the maximum number of ApplicationSets which are reconciled concurrently. Defaults to 1.
+	MaxConcurrentReconciles int
+	// ReconcileTimeout is the maximum duration of the reconciliation of an ApplicationSet, including the generation
+	// of its parameters, if greater than 0
+	ReconcileTimeout time.Duration
+	// Shards assigns the ApplicationSets to the replicas of a sharded controller, if set: only the ApplicationSets of
+	// the shard of this replica are reconciled
+	Shards *utils.Shards
 	utils.Policy
 	utils.Renderer
 }
@@ -78,6 +105,20 @@ func (r *ApplicationSetReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 	var applicationSetInfo argoprojiov1alpha1.ApplicationSet
 	parametersGenerated := false
 
+	if r.ArgoCDNamespace != "" && !utils.IsNamespaceAllowed(req.Namespace, r.ArgoCDNamespace, r.ApplicationSetNamespaces) {
+		log.WithField("applicationset", req.NamespacedName).Warn("ignoring ApplicationSet, as its namespace is not allowed")
+		return ctrl.Result{}, nil
+	}
+
+	// The status is updated with the context of the reconciliation, rather than with the context bound by the
+	// reconcile timeout, so that a timeout is still reported in the status
+	statusCtx := ctx
+	if r.ReconcileTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.ReconcileTimeout)
+		defer cancel()
+	}
+
 	if err := r.Get(ctx, req.NamespacedName, &applicationSetInfo); err != nil {
 		if client.IgnoreNotFound(err) != nil {
 			log.WithError(err).Infof("unable to get ApplicationSet: '%v' ", err)
@@ -85,17 +126,28 @@ func (r *ApplicationSetReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	// Do not attempt to further reconcile the ApplicationSet if it is being deleted.
-	if applicationSetInfo.ObjectMeta.DeletionTimestamp != nil {
+	if r.Shards != nil && !r.Shards.IsOwned(applicationSetInfo.Namespace, applicationSetInfo.Name, applicationSetInfo.Labels) {
+		log.WithField("applicationset", req.NamespacedName).Debug("ignoring ApplicationSet, as it belongs to the shard of another replica")
 		return ctrl.Result{}, nil
 	}
 
+	// Do not attempt to further reconcile the ApplicationSet if it is being deleted, other than to apply its
+	// deletion policy.
+	if applicationSetInfo.ObjectMeta.DeletionTimestamp != nil {
+		return r.finalizeApplicationSet(ctx, &applicationSetInfo)
+	}
+
+	if err := r.reconcileFinalizer(ctx, &applicationSetInfo); err != nil {
+		log.WithError(err).Warn("unable to update the ApplicationSet finalizer")
+		return ctrl.Result{}, err
+	}
+
 	// Log a warning if there are unrecognized generators
 	utils.CheckInvalidGenerators(&applicationSetInfo)
 	// desiredApplications is the main list of all expected Applications from all generators in this appset.
-	desiredApplications, applicationSetReason, err := r.generateApplications(applicationSetInfo)
+	desiredApplications, applicationSetReason, err := r.generateApplications(ctx, applicationSetInfo)
 	if err != nil {
-		_ = r.setApplicationSetStatusCondition(ctx,
+		_ = r.setApplicationSetStatusCondition(statusCtx,
 			&applicationSetInfo,
 			argoprojiov1alpha1.ApplicationSetCondition{
 				Type:    argoprojiov1alpha1.ApplicationSetConditionErrorOccurred,
@@ -109,7 +161,7 @@ func (r *ApplicationSetReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 
 	parametersGenerated = true
 
-	validateErrors, err := r.validateGeneratedApplications(ctx, desiredApplications, applicationSetInfo, req.Namespace)
+	validateErrors, err := r.validateGeneratedApplications(ctx, desiredApplications, applicationSetInfo, r.argoCDNamespace(applicationSetInfo))
 	if err != nil {
 		// While some generators may return an error that requires user intervention,
 		// other generators reference external resources that may change to cause
@@ -120,7 +172,7 @@ func (r *ApplicationSetReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 		// the RequeueAfter time.
 		log.Errorf("error occurred during application validation: %s", err.Error())
 
-		_ = r.setApplicationSetStatusCondition(ctx,
+		_ = r.setApplicationSetStatusCondition(statusCtx,
 			&applicationSetInfo,
 			argoprojiov1alpha1.ApplicationSetCondition{
 				Type:    argoprojiov1alpha1.ApplicationSetConditionErrorOccurred,
@@ -141,20 +193,28 @@ func (r *ApplicationSetReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 
 	if len(validateErrors) > 0 {
 		var message string
+		reason := argoprojiov1alpha1.ApplicationSetReasonApplicationValidationError
 		for _, v := range validateErrors {
-			message = v.Error()
-			log.Errorf("validation error found during application validation: %s", message)
+			var projectErr *projectNotAllowedError
+			if errors.As(v, &projectErr) {
+				// Project violations take precedence in the appset status, as they are security relevant
+				reason = argoprojiov1alpha1.ApplicationSetReasonProjectNotAllowed
+				message = v.Error()
+			} else if reason != argoprojiov1alpha1.ApplicationSetReasonProjectNotAllowed {
+				message = v.Error()
+			}
+			log.Errorf("validation error found during application validation: %s", v.Error())
 		}
 		if len(validateErrors) > 1 {
 			// Only the last message gets added to the appset status, to keep the size reasonable.
 			message = fmt.Sprintf("%s (and %d more)", message, len(validateErrors)-1)
 		}
-		_ = r.setApplicationSetStatusCondition(ctx,
+		_ = r.setApplicationSetStatusCondition(statusCtx,
 			&applicationSetInfo,
 			argoprojiov1alpha1.ApplicationSetCondition{
 				Type:    argoprojiov1alpha1.ApplicationSetConditionErrorOccurred,
 				Message: message,
-				Reason:  argoprojiov1alpha1.ApplicationSetReasonApplicationValidationError,
+				Reason:  reason,
 				Status:  argoprojiov1alpha1.ApplicationSetConditionStatusTrue,
 			}, parametersGenerated,
 		)
@@ -163,7 +223,7 @@ func (r *ApplicationSetReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 	if r.Policy.Update() {
 		err = r.createOrUpdateInCluster(ctx, applicationSetInfo, validApps)
 		if err != nil {
-			_ = r.setApplicationSetStatusCondition(ctx,
+			_ = r.setApplicationSetStatusCondition(statusCtx,
 				&applicationSetInfo,
 				argoprojiov1alpha1.ApplicationSetCondition{
 					Type:    argoprojiov1alpha1.ApplicationSetConditionErrorOccurred,
@@ -177,7 +237,7 @@ func (r *ApplicationSetReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 	} else {
 		err = r.createInCluster(ctx, applicationSetInfo, validApps)
 		if err != nil {
-			_ = r.setApplicationSetStatusCondition(ctx,
+			_ = r.setApplicationSetStatusCondition(statusCtx,
 				&applicationSetInfo,
 				argoprojiov1alpha1.ApplicationSetCondition{
 					Type:    argoprojiov1alpha1.ApplicationSetConditionErrorOccurred,
@@ -190,10 +250,27 @@ func (r *ApplicationSetReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 		}
 	}
 
+	deletionBlocked := false
 	if r.Policy.Delete() {
 		err = r.deleteInCluster(ctx, applicationSetInfo, desiredApplications)
-		if err != nil {
-			_ = r.setApplicationSetStatusCondition(ctx,
+		var blockedErr *deletionBlockedError
+		if errors.As(err, &blockedErr) {
+			// The remaining Applications are kept up to date until the deletion is confirmed, so this is not
+			// treated as a reconciliation failure
+			deletionBlocked = true
+			log.WithField("appSet", applicationSetInfo.Name).Warn(err.Error())
+			r.Recorder.Event(&applicationSetInfo, corev1.EventTypeWarning, "DeletionBlocked", err.Error())
+			_ = r.setApplicationSetStatusCondition(statusCtx,
+				&applicationSetInfo,
+				argoprojiov1alpha1.ApplicationSetCondition{
+					Type:    argoprojiov1alpha1.ApplicationSetConditionErrorOccurred,
+					Message: err.Error(),
+					Reason:  argoprojiov1alpha1.ApplicationSetReasonDeleteApplicationBlocked,
+					Status:  argoprojiov1alpha1.ApplicationSetConditionStatusTrue,
+				}, parametersGenerated,
+			)
+		} else if err != nil {
+			_ = r.setApplicationSetStatusCondition(statusCtx,
 				&applicationSetInfo,
 				argoprojiov1alpha1.ApplicationSetCondition{
 					Type:    argoprojiov1alpha1.ApplicationSetConditionResourcesUpToDate,
@@ -206,12 +283,16 @@ func (r *ApplicationSetReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 		}
 	}
 
-	if applicationSetInfo.RefreshRequired() {
+	_, deletionConfirmation := applicationSetInfo.Annotations[common.AnnotationApplicationSetConfirmDeletion]
+	if applicationSetInfo.RefreshRequired() || deletionConfirmation {
 		delete(applicationSetInfo.Annotations, common.AnnotationApplicationSetRefresh)
+		// A confirmation which does not match the pending deletion is removed as well, so that the deletion is
+		// reported again and can be confirmed with the right value
+		delete(applicationSetInfo.Annotations, common.AnnotationApplicationSetConfirmDeletion)
 		err := r.Client.Update(ctx, &applicationSetInfo)
 		if err != nil {
 			log.Warnf("error occurred while updating ApplicationSet: %v", err)
-			_ = r.setApplicationSetStatusCondition(ctx,
+			_ = r.setApplicationSetStatusCondition(statusCtx,
 				&applicationSetInfo,
 				argoprojiov1alpha1.ApplicationSetCondition{
 					Type:    argoprojiov1alpha1.ApplicationSetConditionErrorOccurred,
@@ -227,8 +308,8 @@ func (r *ApplicationSetReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 	requeueAfter := r.getMinRequeueAfter(&applicationSetInfo)
 	log.WithField("requeueAfter", requeueAfter).Info("end reconcile")
 
-	if len(validateErrors) == 0 {
-		if err := r.setApplicationSetStatusCondition(ctx,
+	if len(validateErrors) == 0 && !deletionBlocked {
+		if err := r.setApplicationSetStatusCondition(statusCtx,
 			&applicationSetInfo,
 			argoprojiov1alpha1.ApplicationSetCondition{
 				Type:    argoprojiov1alpha1.ApplicationSetConditionResourcesUpToDate,
@@ -353,6 +434,12 @@ func (r *ApplicationSetReconciler) setApplicationSetStatusCondition(ctx context.
 func (r *ApplicationSetReconciler) validateGeneratedApplications(ctx context.Context, desiredApplications []argov1alpha1.Application, applicationSetInfo argoprojiov1alpha1.ApplicationSet, namespace string) (map[int]error, error) {
 	errorsByIndex := map[int]error{}
 	namesSet := map[string]bool{}
+
+	namespaceAllowedProjects, namespaceRestricted, err := r.namespaceAllowedProjects(ctx, applicationSetInfo, namespace)
+	if err != nil {
+		return nil, err
+	}
+
 	for i, app := range desiredApplications {
 
 		if !namesSet[app.Name] {
@@ -362,6 +449,16 @@ func (r *ApplicationSetReconciler) validateGeneratedApplications(ctx context.Con
 			continue
 		}
 
+		if !utils.IsProjectAllowed(app.Spec.GetProject(), applicationSetInfo.Spec.AllowedProjects) {
+			errorsByIndex[i] = &projectNotAllowedError{project: app.Spec.GetProject(), allowedBy: "the allowedProjects of the ApplicationSet"}
+			continue
+		}
+		// Unlike the allowedProjects of the ApplicationSet, the projects of a restricted namespace are denied if none are allowed
+		if namespaceRestricted && (len(namespaceAllowedProjects) == 0 || !utils.IsProjectAllowed(app.Spec.GetProject(), namespaceAllowedProjects)) {
+			errorsByIndex[i] = &projectNotAllowedError{project: app.Spec.GetProject(), allowedBy: fmt.Sprintf("the %s annotation of namespace %s", common.AnnotationApplicationSetAllowedProjects, applicationSetInfo.Namespace)}
+			continue
+		}
+
 		proj, err := r.ArgoAppClientset.ArgoprojV1alpha1().AppProjects(namespace).Get(ctx, app.Spec.GetProject(), metav1.GetOptions{})
 		if err != nil {
 			if apierr.IsNotFound(err) {
@@ -390,6 +487,32 @@ func (r *ApplicationSetReconciler) validateGeneratedApplications(ctx context.Con
 	return errorsByIndex, nil
 }
 
+// projectNotAllowedError is the validation error of a generated Application which references a project that the
+// ApplicationSet may not use.
+type projectNotAllowedError struct {
+	project   string
+	allowedBy string
+}
+
+func (e *projectNotAllowedError) Error() string {
+	return fmt.Sprintf("application references project %s which is not allowed by %s", e.project, e.allowedBy)
+}
+
+// namespaceAllowedProjects returns the projects allowed by the AnnotationApplicationSetAllowedProjects annotation of
+// the namespace of an ApplicationSet, and whether the projects of the namespace are restricted: the ApplicationSets
+// of the Argo CD namespace may use any project, while the ApplicationSets of other namespaces may only use the
+// projects allowed by the annotation, and none without it.
+func (r *ApplicationSetReconciler) namespaceAllowedProjects(ctx context.Context, applicationSet argoprojiov1alpha1.ApplicationSet, argoCDNamespace string) ([]string, bool, error) {
+	if applicationSet.Namespace == "" || applicationSet.Namespace == argoCDNamespace {
+		return nil, false, nil
+	}
+	namespace, err := r.KubeClientset.CoreV1().Namespaces().Get(ctx, applicationSet.Namespace, metav1.GetOptions{})
+	if err != nil {
+		return nil, true, fmt.Errorf("error getting namespace %s: %w", applicationSet.Namespace, err)
+	}
+	return utils.ParseAllowedProjects(namespace.Annotations[common.AnnotationApplicationSetAllowedProjects]), true, nil
+}
+
 func (r *ApplicationSetReconciler) getMinRequeueAfter(applicationSetInfo *argoprojiov1alpha1.ApplicationSet) time.Duration {
 	var res time.Duration
 	for _, requestedGenerator := range applicationSetInfo.Spec.Generators {
@@ -422,20 +545,23 @@ func getTempApplication(applicationSetTemplate argoprojiov1alpha1.ApplicationSet
 	return &tmplApplication
 }
 
-func (r *ApplicationSetReconciler) generateApplications(applicationSetInfo argoprojiov1alpha1.ApplicationSet) ([]argov1alpha1.Application, argoprojiov1alpha1.ApplicationSetReasonType, error) {
+func (r *ApplicationSetReconciler) generateApplications(ctx context.Context, applicationSetInfo argoprojiov1alpha1.ApplicationSet) ([]argov1alpha1.Application, argoprojiov1alpha1.ApplicationSetReasonType, error) {
 	var res []argov1alpha1.Application
 
 	var firstError error
 	var applicationSetReason argoprojiov1alpha1.ApplicationSetReasonType
 
 	for _, requestedGenerator := range applicationSetInfo.Spec.Generators {
-		t, err := generators.Transform(requestedGenerator, r.Generators, applicationSetInfo.Spec.Template, &applicationSetInfo)
+		t, err := generators.Transform(ctx, requestedGenerator, r.Generators, applicationSetInfo.Spec.Template, &applicationSetInfo)
 		if err != nil {
 			log.WithError(err).WithField("generator", requestedGenerator).
 				Error("error generating application from params")
 			if firstError == nil {
 				firstError = err
 				applicationSetReason = argoprojiov1alpha1.ApplicationSetReasonApplicationParamsGenerationError
+				if errors.Is(err, generators.ErrSecretNotAllowed) {
+					applicationSetReason = argoprojiov1alpha1.ApplicationSetReasonSecretNotAllowed
+				}
 			}
 			continue
 		}
@@ -468,40 +594,71 @@ func (r *ApplicationSetReconciler) generateApplications(applicationSetInfo argop
 
 func (r *ApplicationSetReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	if err := mgr.GetFieldIndexer().IndexField(context.TODO(), &argov1alpha1.Application{}, ".metadata.controller", func(rawObj client.Object) []string {
-		// grab the job object, extract the owner...
-		app := rawObj.(*argov1alpha1.Application)
-		owner := metav1.GetControllerOf(app)
-		if owner == nil {
-			return nil
-		}
-		// ...make sure it's a application set...
-		if owner.APIVersion != argoprojiov1alpha1.GroupVersion.String() || owner.Kind != "ApplicationSet" {
+		// The Applications are indexed by the namespace and name of their ApplicationSet, as ApplicationSets in
+		// different namespaces may have the same name
+		owner := applicationOwner(rawObj.(*argov1alpha1.Application))
+		if owner == "" {
 			return nil
 		}
-
-		// ...and if so, return it
-		return []string{owner.Name}
+		return []string{owner}
 	}); err != nil {
 		return err
 	}
 
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&argoprojiov1alpha1.ApplicationSet{}).
-		Owns(&argov1alpha1.Application{}).
+	controllerBuilder := ctrl.NewControllerManagedBy(mgr).
+		For(&argoprojiov1alpha1.ApplicationSet{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(object client.Object) bool {
+			if r.Shards != nil && !r.Shards.IsOwned(object.GetNamespace(), object.GetName(), object.GetLabels()) {
+				return false
+			}
+			return r.ArgoCDNamespace == "" || utils.IsNamespaceAllowed(object.GetNamespace(), r.ArgoCDNamespace, r.ApplicationSetNamespaces)
+		}))).
+		// Owned Applications are watched, so that any drift from the template is reverted right away
+		Owns(&argov1alpha1.Application{}, builder.WithPredicates(ownedApplicationPredicate(r.Client))).
+		// The Applications of ApplicationSets in other namespaces than Argo CD can't refer to them with owner
+		// references, so they are mapped to their ApplicationSet with the owner annotation
+		Watches(
+			&source.Kind{Type: &argov1alpha1.Application{}},
+			handler.EnqueueRequestsFromMapFunc(applicationOwnerRequests),
+			builder.WithPredicates(ownedApplicationPredicate(r.Client))).
 		Watches(
 			&source.Kind{Type: &corev1.Secret{}},
 			&clusterSecretEventHandler{
-				Client: mgr.GetClient(),
-				Log:    log.WithField("type", "createSecretEventHandler"),
+				Client:    mgr.GetClient(),
+				Log:       log.WithField("type", "createSecretEventHandler"),
+				Namespace: r.ArgoCDNamespace,
 			}).
-		// TODO: also watch Applications and respond on changes if we own them.
-		Complete(r)
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles})
+
+	if r.Shards != nil {
+		// All ApplicationSets are requeued when the shards change, so that the ApplicationSets which were reassigned
+		// to this replica are reconciled right away
+		shardChanges := make(chan event.GenericEvent)
+		r.Shards.OnChange(func() {
+			go r.requeueApplicationSets(mgr.GetClient(), shardChanges)
+		})
+		controllerBuilder = controllerBuilder.Watches(&source.Channel{Source: shardChanges}, &handler.EnqueueRequestForObject{})
+	}
+
+	return controllerBuilder.Complete(r)
+}
+
+// requeueApplicationSets sends an event for each ApplicationSet to the channel.
+func (r *ApplicationSetReconciler) requeueApplicationSets(c client.Client, events chan<- event.GenericEvent) {
+	var appSets argoprojiov1alpha1.ApplicationSetList
+	if err := c.List(context.Background(), &appSets); err != nil {
+		log.WithError(err).Warn("unable to list ApplicationSets to requeue")
+		return
+	}
+	for i := range appSets.Items {
+		events <- event.GenericEvent{Object: &appSets.Items[i]}
+	}
 }
 
 // createOrUpdateInCluster will create / update application resources in the cluster.
 // - For new applications, it will call create
 // - For existing application, it will call update
 // The function also adds owner reference to all applications, and uses it to delete them.
+// The Applications are created in the Argo CD namespace, which is the only namespace Argo CD reconciles Applications in.
 func (r *ApplicationSetReconciler) createOrUpdateInCluster(ctx context.Context, applicationSet argoprojiov1alpha1.ApplicationSet, desiredApplications []argov1alpha1.Application) error {
 
 	var firstError error
@@ -509,7 +666,7 @@ func (r *ApplicationSetReconciler) createOrUpdateInCluster(ctx context.Context,
 	for _, generatedApp := range desiredApplications {
 
 		appLog := log.WithFields(log.Fields{"app": generatedApp.Name, "appSet": applicationSet.Name})
-		generatedApp.Namespace = applicationSet.Namespace
+		generatedApp.Namespace = r.argoCDNamespace(applicationSet)
 
 		found := &argov1alpha1.Application{
 			ObjectMeta: metav1.ObjectMeta{
@@ -522,23 +679,50 @@ func (r *ApplicationSetReconciler) createOrUpdateInCluster(ctx context.Context,
 			},
 		}
 
-		action, err := utils.CreateOrUpdate(ctx, r.Client, found, func() error {
-			// Copy only the Application/ObjectMeta fields that are significant, from the generatedApp
-			found.Spec = generatedApp.Spec
+		// drift contains the fields of an existing Application which differed from the template
+		var drift []string
+		var action controllerutil.OperationResult
+		var err error
+		if r.EnableServerSideApply {
+			action, drift, err = r.applyApplication(ctx, applicationSet, generatedApp, found)
+			if apierr.IsConflict(err) {
+				r.Recorder.Eventf(&applicationSet, corev1.EventTypeWarning, "ApplyConflict", "failed to apply Application %q: %v", generatedApp.Name, err)
+			}
+		} else {
+			action, err = utils.CreateOrUpdate(ctx, r.Client, found, func() error {
+				live := found.DeepCopy()
+
+				// Copy only the Application/ObjectMeta fields that are significant, from the generatedApp
+				found.Spec = generatedApp.Spec
+
+				// Preserve annotations set by other controllers, such as the argo cd notifications state
+				for _, key := range preservedAnnotationKeys {
+					if value, exists := found.ObjectMeta.Annotations[key]; exists {
+						if generatedApp.Annotations == nil {
+							generatedApp.Annotations = map[string]string{}
+						}
+						generatedApp.Annotations[key] = value
+					}
+				}
+				found.ObjectMeta.Annotations = generatedApp.Annotations
 
-			// Preserve argo cd notifications state (https://github.com/argoproj/applicationset/issues/180)
-			if state, exists := found.ObjectMeta.Annotations[NotifiedAnnotationKey]; exists {
-				if generatedApp.Annotations == nil {
-					generatedApp.Annotations = map[string]string{}
+				found.ObjectMeta.Finalizers = generatedApp.Finalizers
+				found.ObjectMeta.Labels = generatedApp.Labels
+
+				if err := r.setApplicationOwner(applicationSet, live, found); err != nil {
+					return err
 				}
-				generatedApp.Annotations[NotifiedAnnotationKey] = state
-			}
-			found.ObjectMeta.Annotations = generatedApp.Annotations
 
-			found.ObjectMeta.Finalizers = generatedApp.Finalizers
-			found.ObjectMeta.Labels = generatedApp.Labels
-			return controllerutil.SetControllerReference(&applicationSet, found, r.Scheme)
-		})
+				if live.ResourceVersion != "" {
+					// Keep the fields which the ApplicationSet is configured to ignore as they are in the live Application
+					if err := utils.ApplyIgnoreDifferences(applicationSet.Spec.IgnoreApplicationDifferences, live, found); err != nil {
+						return fmt.Errorf("failed to apply ignoreApplicationDifferences: %w", err)
+					}
+					drift = applicationDrift(live, found)
+				}
+				return nil
+			})
+		}
 
 		if err != nil {
 			appLog.WithError(err).WithField("action", action).Errorf("failed to %s Application", action)
@@ -548,8 +732,13 @@ func (r *ApplicationSetReconciler) createOrUpdateInCluster(ctx context.Context,
 			continue
 		}
 
-		r.Recorder.Eventf(&applicationSet, corev1.EventTypeNormal, fmt.Sprint(action), "%s Application %q", action, generatedApp.Name)
-		appLog.Logf(log.InfoLevel, "%s Application", action)
+		if action == controllerutil.OperationResultUpdated && len(drift) > 0 {
+			r.Recorder.Eventf(&applicationSet, corev1.EventTypeNormal, fmt.Sprint(action), "%s Application %q: %s changed to match the template", action, generatedApp.Name, strings.Join(drift, ", "))
+			appLog.WithField("drift", drift).Logf(log.InfoLevel, "%s Application", action)
+		} else {
+			r.Recorder.Eventf(&applicationSet, corev1.EventTypeNormal, fmt.Sprint(action), "%s Application %q", action, generatedApp.Name)
+			appLog.Logf(log.InfoLevel, "%s Application", action)
+		}
 	}
 	return firstError
 }
@@ -582,10 +771,100 @@ func (r *ApplicationSetReconciler) createInCluster(ctx context.Context, applicat
 	return r.createOrUpdateInCluster(ctx, applicationSet, createApps)
 }
 
-func (r *ApplicationSetReconciler) getCurrentApplications(_ context.Context, applicationSet argoprojiov1alpha1.ApplicationSet) ([]argov1alpha1.Application, error) {
-	// TODO: Should this use the context param?
+// argoCDNamespace returns the namespace of Argo CD, for the ApplicationSet
+func (r *ApplicationSetReconciler) argoCDNamespace(applicationSet argoprojiov1alpha1.ApplicationSet) string {
+	if r.ArgoCDNamespace != "" {
+		return r.ArgoCDNamespace
+	}
+	return applicationSet.Namespace
+}
+
+// ownsApplicationsByReference returns true if the Applications of the ApplicationSet are in the same namespace, and
+// can thus be owned with an owner reference, which also lets the Kubernetes garbage collector delete them.
+// Otherwise, they are owned with the owner annotation, and deleted by the ApplicationSet finalizer.
+func (r *ApplicationSetReconciler) ownsApplicationsByReference(applicationSet argoprojiov1alpha1.ApplicationSet) bool {
+	return applicationSet.Namespace == r.argoCDNamespace(applicationSet)
+}
+
+// setApplicationOwner makes the ApplicationSet the owner of the Application: with a controller reference if they are
+// in the same namespace, and with the owner annotation otherwise, as owner references can't refer to an owner in
+// another namespace. Returns an error if the live Application, if it exists, may not be owned by the ApplicationSet.
+func (r *ApplicationSetReconciler) setApplicationOwner(applicationSet argoprojiov1alpha1.ApplicationSet, live *argov1alpha1.Application, app *argov1alpha1.Application) error {
+	if live.ResourceVersion != "" {
+		if err := r.checkApplicationOwner(applicationSet, live); err != nil {
+			return err
+		}
+	}
+	if r.ownsApplicationsByReference(applicationSet) {
+		return controllerutil.SetControllerReference(&applicationSet, app, r.Scheme)
+	}
+	if app.Annotations == nil {
+		app.Annotations = map[string]string{}
+	}
+	app.Annotations[common.AnnotationApplicationSetOwner] = applicationSetKey(applicationSet)
+	return nil
+}
+
+// checkApplicationOwner returns an error if the existing Application is owned by another ApplicationSet or controller.
+// An ApplicationSet outside the Argo CD namespace may only update the Applications it already owns, so that it can't
+// take over (and later delete) the unowned Applications of the Argo CD namespace.
+func (r *ApplicationSetReconciler) checkApplicationOwner(applicationSet argoprojiov1alpha1.ApplicationSet, live *argov1alpha1.Application) error {
+	owner := applicationOwner(live)
+	if owner == applicationSetKey(applicationSet) {
+		return nil
+	}
+	if owner != "" {
+		return fmt.Errorf("application %s is already owned by %s", live.Name, describeApplicationOwner(owner))
+	}
+	if !r.ownsApplicationsByReference(applicationSet) {
+		return fmt.Errorf("application %s already exists and is not owned by ApplicationSet %s", live.Name, applicationSetKey(applicationSet))
+	}
+	return nil
+}
+
+// applicationSetKey returns the namespace and name of the ApplicationSet, as 'namespace/name'
+func applicationSetKey(applicationSet argoprojiov1alpha1.ApplicationSet) string {
+	return types.NamespacedName{Namespace: applicationSet.Namespace, Name: applicationSet.Name}.String()
+}
+
+// foreignOwnerPrefix prefixes the owner of an Application controlled by something else than an ApplicationSet, which
+// thus can't be mistaken for the 'namespace/name' of an ApplicationSet
+const foreignOwnerPrefix = "controller:"
+
+// applicationOwner returns the namespace and name of the ApplicationSet which owns the Application, as
+// 'namespace/name', either with a controller reference or with the owner annotation. If the Application is controlled
+// by something else than an ApplicationSet, its kind and name are returned, prefixed by foreignOwnerPrefix. Empty if
+// it has no owner.
+func applicationOwner(app *argov1alpha1.Application) string {
+	if owner := metav1.GetControllerOf(app); owner != nil {
+		if owner.APIVersion != argoprojiov1alpha1.GroupVersion.String() || owner.Kind != "ApplicationSet" {
+			return fmt.Sprintf("%s%s %s", foreignOwnerPrefix, owner.Kind, owner.Name)
+		}
+		return types.NamespacedName{Namespace: app.Namespace, Name: owner.Name}.String()
+	}
+	return app.Annotations[common.AnnotationApplicationSetOwner]
+}
+
+// describeApplicationOwner returns a description of an owner returned by applicationOwner, for error messages
+func describeApplicationOwner(owner string) string {
+	if strings.HasPrefix(owner, foreignOwnerPrefix) {
+		return strings.TrimPrefix(owner, foreignOwnerPrefix)
+	}
+	return "ApplicationSet " + owner
+}
+
+// applicationOwnerRequests maps an Application with the owner annotation to the reconcile request of its ApplicationSet
+func applicationOwnerRequests(object client.Object) []reconcile.Request {
+	owner := strings.SplitN(object.GetAnnotations()[common.AnnotationApplicationSetOwner], "/", 2)
+	if len(owner) != 2 {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: owner[0], Name: owner[1]}}}
+}
+
+func (r *ApplicationSetReconciler) getCurrentApplications(ctx context.Context, applicationSet argoprojiov1alpha1.ApplicationSet) ([]argov1alpha1.Application, error) {
 	var current argov1alpha1.ApplicationList
-	err := r.Client.List(context.Background(), &current, client.MatchingFields{".metadata.controller": applicationSet.Name})
+	err := r.Client.List(ctx, &current, client.MatchingFields{".metadata.controller": applicationSetKey(applicationSet)}, client.InNamespace(r.argoCDNamespace(applicationSet)))
 
 	if err != nil {
 		return nil, err
@@ -598,7 +877,7 @@ func (r *ApplicationSetReconciler) getCurrentApplications(_ context.Context, app
 // The function must be called after all generators had been called and generated applications
 func (r *ApplicationSetReconciler) deleteInCluster(ctx context.Context, applicationSet argoprojiov1alpha1.ApplicationSet, desiredApplications []argov1alpha1.Application) error {
 
-	clusterList, err := utils.ListClusters(ctx, r.KubeClientset, applicationSet.Namespace)
+	clusterList, err := utils.ListClusters(ctx, r.KubeClientset, r.argoCDNamespace(applicationSet))
 	if err != nil {
 		return err
 	}
@@ -615,6 +894,20 @@ func (r *ApplicationSetReconciler) deleteInCluster(ctx context.Context, applicat
 		m[app.Name] = true
 	}
 
+	var deletedApplications []string
+	for _, app := range current {
+		if !m[app.Name] {
+			deletedApplications = append(deletedApplications, app.Name)
+		}
+	}
+	// Orphaning an Application keeps it (and its resources), so it is not limited by maxApplicationDeletions
+	orphan := applicationSet.Spec.SyncPolicy.OrphanRemovedApplications()
+	if !orphan {
+		if err := checkMaxApplicationDeletions(applicationSet, deletedApplications, len(current)); err != nil {
+			return err
+		}
+	}
+
 	// Delete apps that are not in m[string]bool
 	var firstError error
 	for _, app := range current {
@@ -623,6 +916,19 @@ func (r *ApplicationSetReconciler) deleteInCluster(ctx context.Context, applicat
 
 		if !exists {
 
+			if orphan {
+				if err := r.orphanApplication(ctx, applicationSet, &app); err != nil {
+					appLog.WithError(err).Error("failed to orphan Application")
+					if firstError == nil {
+						firstError = err
+					}
+					continue
+				}
+				r.Recorder.Eventf(&applicationSet, corev1.EventTypeNormal, "Orphaned", "Orphaned Application %q", app.Name)
+				appLog.Log(log.InfoLevel, "Orphaned application")
+				continue
+			}
+
 			// Removes the Argo CD resources finalizer if the application contains an invalid target (eg missing cluster)
 			err := r.removeFinalizerOnInvalidDestination(ctx, applicationSet, &app, clusterList, appLog)
 			if err != nil {
@@ -648,6 +954,88 @@ func (r *ApplicationSetReconciler) deleteInCluster(ctx context.Context, applicat
 	return firstError
 }
 
+// orphanApplication removes the owner reference of the Application to the ApplicationSet, so that it is no longer
+// managed (nor deleted) by the ApplicationSet, and labels it as orphaned. If the Application is generated by the
+// ApplicationSet again, it is adopted, and the label removed, as the labels are reset to the template.
+func (r *ApplicationSetReconciler) orphanApplication(ctx context.Context, applicationSet argoprojiov1alpha1.ApplicationSet, app *argov1alpha1.Application) error {
+	original := app.DeepCopy()
+
+	var ownerReferences []metav1.OwnerReference
+	for _, ownerRef := range app.OwnerReferences {
+		if ownerRef.Kind != "ApplicationSet" || ownerRef.Name != applicationSet.Name {
+			ownerReferences = append(ownerReferences, ownerRef)
+		}
+	}
+	app.OwnerReferences = ownerReferences
+	delete(app.Annotations, common.AnnotationApplicationSetOwner)
+
+	if app.Labels == nil {
+		app.Labels = map[string]string{}
+	}
+	app.Labels[common.LabelApplicationSetOrphaned] = "true"
+	if app.Annotations == nil {
+		app.Annotations = map[string]string{}
+	}
+	app.Annotations[common.AnnotationApplicationSetOrphanedFrom] = applicationSet.Name
+
+	return r.Client.Patch(ctx, app, client.MergeFrom(original))
+}
+
+// deletionBlockedError is returned by deleteInCluster when more Applications would be deleted than allowed by the
+// maxApplicationDeletions of the ApplicationSet, and the deletion has not been confirmed.
+type deletionBlockedError struct {
+	deletedApplications []string
+	maxDeletions        int
+	currentApplications int
+	confirmation        string
+}
+
+func (e *deletionBlockedError) Error() string {
+	names := e.deletedApplications
+	if len(names) > 5 {
+		// Only list some of the Applications, to keep the size of the appset status reasonable
+		names = append(names[:5:5], fmt.Sprintf("and %d more", len(e.deletedApplications)-5))
+	}
+	return fmt.Sprintf("deletion of %d of %d Applications (%s) exceeds the maxApplicationDeletions of %d: annotate the ApplicationSet with '%s=%s' to confirm it",
+		len(e.deletedApplications), e.currentApplications, strings.Join(names, ", "), e.maxDeletions, common.AnnotationApplicationSetConfirmDeletion, e.confirmation)
+}
+
+// deletionConfirmation returns the value of the confirm-deletion annotation which confirms the deletion of the given
+// Applications: their number, and a hash of their names, so that it does not confirm the deletion of other Applications.
+func deletionConfirmation(deletedApplications []string) string {
+	names := append([]string{}, deletedApplications...)
+	sort.Strings(names)
+	hash := sha256.Sum256([]byte(strings.Join(names, "\n")))
+	return fmt.Sprintf("%d-%s", len(names), hex.EncodeToString(hash[:])[:10])
+}
+
+// checkMaxApplicationDeletions returns a deletionBlockedError if the deletion of the given Applications, out of the
+// current Applications of the ApplicationSet, exceeds its maxApplicationDeletions and has not been confirmed.
+func checkMaxApplicationDeletions(applicationSet argoprojiov1alpha1.ApplicationSet, deletedApplications []string, currentApplications int) error {
+	if applicationSet.Spec.SyncPolicy == nil || applicationSet.Spec.SyncPolicy.MaxApplicationDeletions == nil {
+		return nil
+	}
+	confirmation := deletionConfirmation(deletedApplications)
+	if applicationSet.DeletionConfirmed(confirmation) {
+		return nil
+	}
+
+	// Percentages are rounded down, so that the limit is never exceeded
+	maxDeletions, err := intstr.GetScaledValueFromIntOrPercent(applicationSet.Spec.SyncPolicy.MaxApplicationDeletions, currentApplications, false)
+	if err != nil {
+		return fmt.Errorf("invalid maxApplicationDeletions: %w", err)
+	}
+	if len(deletedApplications) > maxDeletions {
+		return &deletionBlockedError{
+			deletedApplications: deletedApplications,
+			maxDeletions:        maxDeletions,
+			currentApplications: currentApplications,
+			confirmation:        confirmation,
+		}
+	}
+	return nil
+}
+
 // removeFinalizerOnInvalidDestination removes the Argo CD resources finalizer if the application contains an invalid target (eg missing cluster)
 func (r *ApplicationSetReconciler) removeFinalizerOnInvalidDestination(ctx context.Context, applicationSet argoprojiov1alpha1.ApplicationSet, app *argov1alpha1.Application, clusterList *argov1alpha1.ClusterList, appLog *log.Entry) error {
 
@@ -659,7 +1047,7 @@ func (r *ApplicationSetReconciler) removeFinalizerOnInvalidDestination(ctx conte
 	var validDestination bool
 
 	// Detect if the destination is invalid (name doesn't correspond to a matching cluster)
-	if err := utils.ValidateDestination(ctx, &app.Spec.Destination, r.KubeClientset, applicationSet.Namespace); err != nil {
+	if err := utils.ValidateDestination(ctx, &app.Spec.Destination, r.KubeClientset, r.argoCDNamespace(applicationSet)); err != nil {
 		appLog.Warnf("The destination cluster for %s couldn't be found: %v", app.Name, err)
 		validDestination = false
 	} else {
@@ -3,12 +3,14 @@ package controllers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/argoproj/applicationset/common"
 	"github.com/argoproj/applicationset/pkg/generators"
 	"github.com/argoproj/applicationset/pkg/utils"
 	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
@@ -19,11 +21,13 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	crtclient "sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"github.com/argoproj/applicationset/api/v1alpha1"
 	argoprojiov1alpha1 "github.com/argoproj/applicationset/api/v1alpha1"
@@ -43,7 +47,7 @@ func (g *generatorMock) GetTemplate(appSetGenerator *argoprojiov1alpha1.Applicat
 	return args.Get(0).(*argoprojiov1alpha1.ApplicationSetTemplate)
 }
 
-func (g *generatorMock) GenerateParams(appSetGenerator *argoprojiov1alpha1.ApplicationSetGenerator, _ *argoprojiov1alpha1.ApplicationSet) ([]map[string]string, error) {
+func (g *generatorMock) GenerateParams(_ context.Context, appSetGenerator *argoprojiov1alpha1.ApplicationSetGenerator, _ *argoprojiov1alpha1.ApplicationSet) ([]map[string]string, error) {
 	args := g.Called(appSetGenerator)
 
 	return args.Get(0).([]map[string]string), args.Error(1)
@@ -180,7 +184,7 @@ func TestExtractApplications(t *testing.T) {
 				KubeClientset: kubefake.NewSimpleClientset(),
 			}
 
-			got, reason, err := r.generateApplications(argoprojiov1alpha1.ApplicationSet{
+			got, reason, err := r.generateApplications(context.Background(), argoprojiov1alpha1.ApplicationSet{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "name",
 					Namespace: "namespace",
@@ -293,7 +297,7 @@ func TestMergeTemplateApplications(t *testing.T) {
 				KubeClientset: kubefake.NewSimpleClientset(),
 			}
 
-			got, _, _ := r.generateApplications(argoprojiov1alpha1.ApplicationSet{
+			got, _, _ := r.generateApplications(context.Background(), argoprojiov1alpha1.ApplicationSet{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "name",
 					Namespace: "namespace",
@@ -817,6 +821,86 @@ func TestCreateOrUpdateInCluster(t *testing.T) {
 					},
 				},
 			},
+		}, {
+			name: "Ignored application differences are preserved from the existing app",
+			appSet: argoprojiov1alpha1.ApplicationSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "name",
+					Namespace: "namespace",
+				},
+				Spec: argoprojiov1alpha1.ApplicationSetSpec{
+					IgnoreApplicationDifferences: []argoprojiov1alpha1.ApplicationSetResourceIgnoreDifferences{
+						{
+							JSONPointers: []string{"/spec/source/targetRevision"},
+						},
+						{
+							Name:              "app1",
+							JQPathExpressions: []string{".metadata.labels.\"label-key\""},
+						},
+						{
+							Name:         "other-app",
+							JSONPointers: []string{"/spec/project"},
+						},
+					},
+				},
+			},
+			existingApps: []argov1alpha1.Application{
+				{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       "Application",
+						APIVersion: "argoproj.io/v1alpha1",
+					},
+					ObjectMeta: metav1.ObjectMeta{
+						Name:            "app1",
+						Namespace:       "namespace",
+						ResourceVersion: "2",
+						Labels:          map[string]string{"label-key": "edited"},
+					},
+					Spec: argov1alpha1.ApplicationSpec{
+						Project: "test",
+						Source: argov1alpha1.ApplicationSource{
+							RepoURL:        "https://github.com/argoproj/argocd-example-apps",
+							TargetRevision: "edited",
+						},
+					},
+				},
+			},
+			desiredApps: []argov1alpha1.Application{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:   "app1",
+						Labels: map[string]string{"label-key": "label-value", "other-key": "other-value"},
+					},
+					Spec: argov1alpha1.ApplicationSpec{
+						Project: "project",
+						Source: argov1alpha1.ApplicationSource{
+							RepoURL:        "https://github.com/argoproj/argocd-example-apps",
+							TargetRevision: "HEAD",
+						},
+					},
+				},
+			},
+			expected: []argov1alpha1.Application{
+				{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       "Application",
+						APIVersion: "argoproj.io/v1alpha1",
+					},
+					ObjectMeta: metav1.ObjectMeta{
+						Name:            "app1",
+						Namespace:       "namespace",
+						ResourceVersion: "3",
+						Labels:          map[string]string{"label-key": "edited", "other-key": "other-value"},
+					},
+					Spec: argov1alpha1.ApplicationSpec{
+						Project: "project",
+						Source: argov1alpha1.ApplicationSource{
+							RepoURL:        "https://github.com/argoproj/argocd-example-apps",
+							TargetRevision: "edited",
+						},
+					},
+				},
+			},
 		},
 	} {
 
@@ -1483,6 +1567,271 @@ func TestDeleteInCluster(t *testing.T) {
 	}
 }
 
+func TestCheckMaxApplicationDeletions(t *testing.T) {
+	for _, c := range []struct {
+		name                string
+		maxDeletions        *intstr.IntOrString
+		confirmation        string
+		deletedApplications int
+		currentApplications int
+		expectedError       string
+	}{
+		{
+			name:                "unlimited",
+			deletedApplications: 10,
+			currentApplications: 10,
+		},
+		{
+			name:                "number within the limit",
+			maxDeletions:        &intstr.IntOrString{Type: intstr.Int, IntVal: 2},
+			deletedApplications: 2,
+			currentApplications: 10,
+		},
+		{
+			name:                "number exceeding the limit",
+			maxDeletions:        &intstr.IntOrString{Type: intstr.Int, IntVal: 2},
+			deletedApplications: 3,
+			currentApplications: 10,
+			expectedError:       "deletion of 3 of 10 Applications (app0, app1, app2) exceeds the maxApplicationDeletions of 2: annotate the ApplicationSet with 'argocd.argoproj.io/application-set-confirm-deletion=" + deletionConfirmation([]string{"app0", "app1", "app2"}) + "' to confirm it",
+		},
+		{
+			name:                "percentage within the limit",
+			maxDeletions:        &intstr.IntOrString{Type: intstr.String, StrVal: "50%"},
+			deletedApplications: 2,
+			currentApplications: 4,
+		},
+		{
+			name:                "percentage exceeding the limit is rounded down",
+			maxDeletions:        &intstr.IntOrString{Type: intstr.String, StrVal: "30%"},
+			deletedApplications: 2,
+			currentApplications: 5,
+			expectedError:       "deletion of 2 of 5 Applications (app0, app1) exceeds the maxApplicationDeletions of 1",
+		},
+		{
+			name:                "only some of the Applications are listed",
+			maxDeletions:        &intstr.IntOrString{Type: intstr.Int, IntVal: 0},
+			deletedApplications: 7,
+			currentApplications: 7,
+			expectedError:       "deletion of 7 of 7 Applications (app0, app1, app2, app3, app4, and 2 more)",
+		},
+		{
+			name:                "confirmed deletion exceeding the limit",
+			maxDeletions:        &intstr.IntOrString{Type: intstr.Int, IntVal: 0},
+			confirmation:        deletionConfirmation([]string{"app2", "app1", "app0"}),
+			deletedApplications: 3,
+			currentApplications: 3,
+		},
+		{
+			name:                "confirmation of another deletion",
+			maxDeletions:        &intstr.IntOrString{Type: intstr.Int, IntVal: 0},
+			confirmation:        deletionConfirmation([]string{"app0", "app1"}),
+			deletedApplications: 3,
+			currentApplications: 3,
+			expectedError:       "deletion of 3 of 3 Applications",
+		},
+		{
+			name:                "legacy confirmation",
+			maxDeletions:        &intstr.IntOrString{Type: intstr.Int, IntVal: 0},
+			confirmation:        "true",
+			deletedApplications: 3,
+			currentApplications: 3,
+			expectedError:       "deletion of 3 of 3 Applications",
+		},
+		{
+			name:                "invalid percentage",
+			maxDeletions:        &intstr.IntOrString{Type: intstr.String, StrVal: "ten"},
+			deletedApplications: 1,
+			currentApplications: 1,
+			expectedError:       "invalid maxApplicationDeletions",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			appSet := argoprojiov1alpha1.ApplicationSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "name",
+					Namespace: "namespace",
+				},
+				Spec: argoprojiov1alpha1.ApplicationSetSpec{
+					SyncPolicy: &argoprojiov1alpha1.ApplicationSetSyncPolicy{
+						MaxApplicationDeletions: c.maxDeletions,
+					},
+				},
+			}
+			if c.confirmation != "" {
+				appSet.Annotations = map[string]string{common.AnnotationApplicationSetConfirmDeletion: c.confirmation}
+			}
+			var deletedApplications []string
+			for i := 0; i < c.deletedApplications; i++ {
+				deletedApplications = append(deletedApplications, fmt.Sprintf("app%d", i))
+			}
+
+			err := checkMaxApplicationDeletions(appSet, deletedApplications, c.currentApplications)
+			if c.expectedError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), c.expectedError)
+			}
+		})
+	}
+}
+
+func TestDeleteInClusterBlockedByMaxApplicationDeletions(t *testing.T) {
+	scheme := runtime.NewScheme()
+	err := argoprojiov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+	err = argov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+
+	appSet := argoprojiov1alpha1.ApplicationSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "name",
+			Namespace: "namespace",
+		},
+		Spec: argoprojiov1alpha1.ApplicationSetSpec{
+			SyncPolicy: &argoprojiov1alpha1.ApplicationSetSyncPolicy{
+				MaxApplicationDeletions: &intstr.IntOrString{Type: intstr.Int, IntVal: 1},
+			},
+		},
+	}
+	initObjs := []crtclient.Object{&appSet}
+	for _, name := range []string{"keep", "delete1", "delete2"} {
+		app := &argov1alpha1.Application{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "namespace",
+			},
+		}
+		err = controllerutil.SetControllerReference(&appSet, app, scheme)
+		assert.Nil(t, err)
+		initObjs = append(initObjs, app)
+	}
+
+	client := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjs...).Build()
+	r := ApplicationSetReconciler{
+		Client:        client,
+		Scheme:        scheme,
+		Recorder:      record.NewFakeRecorder(2),
+		KubeClientset: kubefake.NewSimpleClientset(),
+	}
+	desiredApps := []argov1alpha1.Application{{ObjectMeta: metav1.ObjectMeta{Name: "keep"}}}
+
+	err = r.deleteInCluster(context.TODO(), appSet, desiredApps)
+	var blockedErr *deletionBlockedError
+	assert.True(t, errors.As(err, &blockedErr))
+
+	// None of the Applications are deleted
+	list := &argov1alpha1.ApplicationList{}
+	err = client.List(context.Background(), list)
+	assert.Nil(t, err)
+	assert.Len(t, list.Items, 3)
+
+	// A confirmation of another deletion does not delete them
+	appSet.Annotations = map[string]string{common.AnnotationApplicationSetConfirmDeletion: deletionConfirmation([]string{"delete1"})}
+	err = r.deleteInCluster(context.TODO(), appSet, desiredApps)
+	assert.True(t, errors.As(err, &blockedErr))
+	err = client.List(context.Background(), list)
+	assert.Nil(t, err)
+	assert.Len(t, list.Items, 3)
+
+	// Once confirmed, the Applications are deleted
+	appSet.Annotations = map[string]string{common.AnnotationApplicationSetConfirmDeletion: blockedErr.confirmation}
+	err = r.deleteInCluster(context.TODO(), appSet, desiredApps)
+	assert.Nil(t, err)
+
+	err = client.List(context.Background(), list)
+	assert.Nil(t, err)
+	assert.Len(t, list.Items, 1)
+	assert.Equal(t, "keep", list.Items[0].Name)
+}
+
+func TestDeleteInClusterOrphansApplications(t *testing.T) {
+	scheme := runtime.NewScheme()
+	err := argoprojiov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+	err = argov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+
+	appSet := argoprojiov1alpha1.ApplicationSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "name",
+			Namespace: "namespace",
+		},
+		Spec: argoprojiov1alpha1.ApplicationSetSpec{
+			SyncPolicy: &argoprojiov1alpha1.ApplicationSetSyncPolicy{
+				ApplicationRemovalPolicy: argoprojiov1alpha1.ApplicationRemovalPolicyOrphan,
+				// Orphaning is not limited by maxApplicationDeletions
+				MaxApplicationDeletions: &intstr.IntOrString{Type: intstr.Int, IntVal: 0},
+			},
+		},
+	}
+	initObjs := []crtclient.Object{&appSet}
+	for _, name := range []string{"keep", "orphan"} {
+		app := &argov1alpha1.Application{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       name,
+				Namespace:  "namespace",
+				Labels:     map[string]string{"label-key": "label-value"},
+				Finalizers: []string{"resources-finalizer.argocd.argoproj.io"},
+			},
+			Spec: argov1alpha1.ApplicationSpec{
+				Project: "project",
+			},
+		}
+		err = controllerutil.SetControllerReference(&appSet, app, scheme)
+		assert.Nil(t, err)
+		initObjs = append(initObjs, app)
+	}
+
+	client := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjs...).Build()
+	recorder := record.NewFakeRecorder(2)
+	r := ApplicationSetReconciler{
+		Client:        client,
+		Scheme:        scheme,
+		Recorder:      recorder,
+		KubeClientset: kubefake.NewSimpleClientset(),
+	}
+
+	err = r.deleteInCluster(context.TODO(), appSet, []argov1alpha1.Application{{ObjectMeta: metav1.ObjectMeta{Name: "keep"}}})
+	assert.Nil(t, err)
+	assert.Equal(t, `Normal Orphaned Orphaned Application "orphan"`, <-recorder.Events)
+
+	got := &argov1alpha1.Application{}
+	err = client.Get(context.Background(), crtclient.ObjectKey{Namespace: "namespace", Name: "orphan"}, got)
+	assert.Nil(t, err)
+	assert.Empty(t, got.OwnerReferences)
+	assert.Equal(t, map[string]string{"label-key": "label-value", common.LabelApplicationSetOrphaned: "true"}, got.Labels)
+	assert.Equal(t, map[string]string{common.AnnotationApplicationSetOrphanedFrom: "name"}, got.Annotations)
+	// The Application is otherwise left as it is
+	assert.Equal(t, []string{"resources-finalizer.argocd.argoproj.io"}, got.Finalizers)
+	assert.Equal(t, "project", got.Spec.Project)
+
+	err = client.Get(context.Background(), crtclient.ObjectKey{Namespace: "namespace", Name: "keep"}, got)
+	assert.Nil(t, err)
+	assert.True(t, metav1.IsControlledBy(got, &appSet))
+
+	// The orphaned Application is adopted again when it is generated again
+	err = r.createOrUpdateInCluster(context.TODO(), appSet, []argov1alpha1.Application{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       "orphan",
+				Labels:     map[string]string{"label-key": "label-value"},
+				Finalizers: []string{"resources-finalizer.argocd.argoproj.io"},
+			},
+			Spec: argov1alpha1.ApplicationSpec{
+				Project: "project",
+			},
+		},
+	})
+	assert.Nil(t, err)
+
+	err = client.Get(context.Background(), crtclient.ObjectKey{Namespace: "namespace", Name: "orphan"}, got)
+	assert.Nil(t, err)
+	assert.True(t, metav1.IsControlledBy(got, &appSet))
+	assert.Equal(t, map[string]string{"label-key": "label-value"}, got.Labels)
+	assert.Empty(t, got.Annotations)
+}
+
 func TestGetMinRequeueAfter(t *testing.T) {
 	scheme := runtime.NewScheme()
 	err := argoprojiov1alpha1.AddToScheme(scheme)
@@ -1768,6 +2117,141 @@ func TestValidateGeneratedApplications(t *testing.T) {
 	}
 }
 
+func TestValidateGeneratedApplicationsAllowedProjects(t *testing.T) {
+	scheme := runtime.NewScheme()
+	err := argoprojiov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+	err = argov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+
+	myCluster := argov1alpha1.Cluster{
+		Server: "https://kubernetes.default.svc",
+		Name:   "my-cluster",
+	}
+	argoObjs := []runtime.Object{}
+	for _, name := range []string{"default", "team-a", "team-a-prod", "team-b"} {
+		argoObjs = append(argoObjs, &argov1alpha1.AppProject{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "argocd"},
+			Spec: argov1alpha1.AppProjectSpec{
+				SourceRepos:  []string{"*"},
+				Destinations: []argov1alpha1.ApplicationDestination{{Namespace: "*", Server: "*"}},
+			},
+		})
+	}
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "team-a",
+			Annotations: map[string]string{common.AnnotationApplicationSetAllowedProjects: "team-a, team-a-*"},
+		},
+	}
+	unannotatedNamespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "team-b"},
+	}
+
+	for _, c := range []struct {
+		name            string
+		namespace       string
+		allowedProjects []string
+		// expectedErrors are the projects of the generated Applications which are expected not to be allowed
+		expectedErrors map[string]string
+	}{
+		{
+			name:      "any project is allowed in the Argo CD namespace",
+			namespace: "argocd",
+		},
+		{
+			name:            "projects allowed by the ApplicationSet",
+			namespace:       "argocd",
+			allowedProjects: []string{"team-a*"},
+			expectedErrors: map[string]string{
+				"default": "application references project default which is not allowed by the allowedProjects of the ApplicationSet",
+				"team-b":  "application references project team-b which is not allowed by the allowedProjects of the ApplicationSet",
+			},
+		},
+		{
+			name:      "projects allowed by the namespace",
+			namespace: "team-a",
+			expectedErrors: map[string]string{
+				"default": "application references project default which is not allowed by the argocd.argoproj.io/application-set-allowed-projects annotation of namespace team-a",
+				"team-b":  "application references project team-b which is not allowed by the argocd.argoproj.io/application-set-allowed-projects annotation of namespace team-a",
+			},
+		},
+		{
+			name:      "no project is allowed by a namespace without the annotation",
+			namespace: "team-b",
+			expectedErrors: map[string]string{
+				"default":     "application references project default which is not allowed by the argocd.argoproj.io/application-set-allowed-projects annotation of namespace team-b",
+				"team-a":      "application references project team-a which is not allowed by the argocd.argoproj.io/application-set-allowed-projects annotation of namespace team-b",
+				"team-a-prod": "application references project team-a-prod which is not allowed by the argocd.argoproj.io/application-set-allowed-projects annotation of namespace team-b",
+				"team-b":      "application references project team-b which is not allowed by the argocd.argoproj.io/application-set-allowed-projects annotation of namespace team-b",
+			},
+		},
+		{
+			name:            "projects allowed by both the ApplicationSet and the namespace",
+			namespace:       "team-a",
+			allowedProjects: []string{"team-a-prod", "team-b"},
+			expectedErrors: map[string]string{
+				"default": "application references project default which is not allowed by the allowedProjects of the ApplicationSet",
+				"team-a":  "application references project team-a which is not allowed by the allowedProjects of the ApplicationSet",
+				"team-b":  "application references project team-b which is not allowed by the argocd.argoproj.io/application-set-allowed-projects annotation of namespace team-a",
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var apps []argov1alpha1.Application
+			for _, project := range []string{"", "team-a", "team-a-prod", "team-b"} {
+				apps = append(apps, argov1alpha1.Application{
+					ObjectMeta: metav1.ObjectMeta{Name: "app-" + project},
+					Spec: argov1alpha1.ApplicationSpec{
+						Project:     project,
+						Source:      argov1alpha1.ApplicationSource{RepoURL: "https://url", Path: "/", TargetRevision: "HEAD"},
+						Destination: argov1alpha1.ApplicationDestination{Namespace: "namespace", Name: "my-cluster"},
+					},
+				})
+			}
+
+			argoDBMock := dbmocks.ArgoDB{}
+			argoDBMock.On("GetCluster", mock.Anything, "https://kubernetes.default.svc").Return(&myCluster, nil)
+			r := ApplicationSetReconciler{
+				Client:           fake.NewClientBuilder().WithScheme(scheme).Build(),
+				Scheme:           scheme,
+				Recorder:         record.NewFakeRecorder(1),
+				ArgoDB:           &argoDBMock,
+				ArgoAppClientset: appclientset.NewSimpleClientset(argoObjs...),
+				KubeClientset: kubefake.NewSimpleClientset(namespace, unannotatedNamespace, &corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "my-secret",
+						Namespace: "argocd",
+						Labels:    map[string]string{generators.ArgoCDSecretTypeLabel: generators.ArgoCDSecretTypeCluster},
+					},
+					Data: map[string][]byte{
+						"name":   []byte("my-cluster"),
+						"server": []byte("https://kubernetes.default.svc"),
+					},
+				}),
+			}
+			appSet := argoprojiov1alpha1.ApplicationSet{
+				ObjectMeta: metav1.ObjectMeta{Name: "name", Namespace: c.namespace},
+				Spec:       argoprojiov1alpha1.ApplicationSetSpec{AllowedProjects: c.allowedProjects},
+			}
+
+			validationErrors, err := r.validateGeneratedApplications(context.TODO(), apps, appSet, "argocd")
+			assert.Nil(t, err)
+
+			errorsByProject := map[string]string{}
+			for i, validationErr := range validationErrors {
+				var projectErr *projectNotAllowedError
+				assert.True(t, errors.As(validationErr, &projectErr), "unexpected validation error: %v", validationErr)
+				errorsByProject[apps[i].Spec.GetProject()] = validationErr.Error()
+			}
+			if c.expectedErrors == nil {
+				c.expectedErrors = map[string]string{}
+			}
+			assert.Equal(t, c.expectedErrors, errorsByProject)
+		})
+	}
+}
+
 func TestReconcilerValidationErrorBehaviour(t *testing.T) {
 
 	scheme := runtime.NewScheme()
@@ -1863,6 +2347,284 @@ func TestReconcilerValidationErrorBehaviour(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestReconcilerIgnoresDisallowedNamespace(t *testing.T) {
+	scheme := runtime.NewScheme()
+	err := argoprojiov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+	err = argov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+
+	appSet := argoprojiov1alpha1.ApplicationSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "name",
+			Namespace: "team-b",
+		},
+		Spec: argoprojiov1alpha1.ApplicationSetSpec{
+			Generators: []argoprojiov1alpha1.ApplicationSetGenerator{
+				{
+					List: &argoprojiov1alpha1.ListGenerator{
+						Elements: []apiextensionsv1.JSON{{
+							Raw: []byte(`{"cluster": "good-cluster","url": "https://good-cluster"}`),
+						}},
+					},
+				},
+			},
+			Template: argoprojiov1alpha1.ApplicationSetTemplate{
+				ApplicationSetTemplateMeta: argoprojiov1alpha1.ApplicationSetTemplateMeta{
+					Name: "{{cluster}}",
+				},
+				Spec: argov1alpha1.ApplicationSpec{
+					Source:      argov1alpha1.ApplicationSource{RepoURL: "https://github.com/argoproj/argocd-example-apps", Path: "guestbook"},
+					Project:     "default",
+					Destination: argov1alpha1.ApplicationDestination{Server: "{{url}}"},
+				},
+			},
+		},
+	}
+
+	client := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&appSet).Build()
+	r := ApplicationSetReconciler{
+		Log:      ctrl.Log.WithName("controllers").WithName("ApplicationSet"),
+		Client:   client,
+		Scheme:   scheme,
+		Renderer: &utils.Render{},
+		Recorder: record.NewFakeRecorder(1),
+		Generators: map[string]generators.Generator{
+			"List": generators.NewListGenerator(),
+		},
+		KubeClientset:            kubefake.NewSimpleClientset(),
+		Policy:                   &utils.SyncPolicy{},
+		ArgoCDNamespace:          "argocd",
+		ApplicationSetNamespaces: []string{"team-a"},
+	}
+
+	res, err := r.Reconcile(context.Background(), ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Namespace: "team-b",
+			Name:      "name",
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, ctrl.Result{}, res)
+
+	apps := &argov1alpha1.ApplicationList{}
+	err = client.List(context.Background(), apps)
+	assert.Nil(t, err)
+	assert.Empty(t, apps.Items)
+}
+
+// blockingGenerator is a List generator which generates no parameters until its context is cancelled
+type blockingGenerator struct {
+	generators.Generator
+}
+
+func (g *blockingGenerator) GenerateParams(ctx context.Context, _ *argoprojiov1alpha1.ApplicationSetGenerator, _ *argoprojiov1alpha1.ApplicationSet) ([]map[string]string, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestReconcilerTimeout(t *testing.T) {
+	scheme := runtime.NewScheme()
+	err := argoprojiov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+	err = argov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+
+	appSet := argoprojiov1alpha1.ApplicationSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "name",
+			Namespace: "argocd",
+		},
+		Spec: argoprojiov1alpha1.ApplicationSetSpec{
+			Generators: []argoprojiov1alpha1.ApplicationSetGenerator{
+				{
+					List: &argoprojiov1alpha1.ListGenerator{},
+				},
+			},
+		},
+	}
+
+	client := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&appSet).Build()
+	r := ApplicationSetReconciler{
+		Log:      ctrl.Log.WithName("controllers").WithName("ApplicationSet"),
+		Client:   client,
+		Scheme:   scheme,
+		Renderer: &utils.Render{},
+		Recorder: record.NewFakeRecorder(1),
+		Generators: map[string]generators.Generator{
+			"List": &blockingGenerator{Generator: generators.NewListGenerator()},
+		},
+		KubeClientset:    kubefake.NewSimpleClientset(),
+		Policy:           &utils.SyncPolicy{},
+		ReconcileTimeout: 10 * time.Millisecond,
+	}
+
+	_, err = r.Reconcile(context.Background(), ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Namespace: "argocd",
+			Name:      "name",
+		},
+	})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// The timeout is reported in the status of the ApplicationSet
+	err = client.Get(context.Background(), crtclient.ObjectKey{Namespace: "argocd", Name: "name"}, &appSet)
+	assert.Nil(t, err)
+	if assert.Len(t, appSet.Status.Conditions, 3) {
+		assert.Equal(t, argoprojiov1alpha1.ApplicationSetConditionErrorOccurred, appSet.Status.Conditions[0].Type)
+		assert.Contains(t, appSet.Status.Conditions[0].Message, context.DeadlineExceeded.Error())
+	}
+}
+
+func TestCreateOrUpdateInClusterInOtherNamespace(t *testing.T) {
+	scheme := runtime.NewScheme()
+	err := argoprojiov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+	err = argov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+
+	appSet := argoprojiov1alpha1.ApplicationSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "name",
+			Namespace: "team-a",
+		},
+	}
+	ownedByOther := &argov1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "other",
+			Namespace:   "argocd",
+			Annotations: map[string]string{common.AnnotationApplicationSetOwner: "team-b/name"},
+		},
+	}
+
+	client := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&appSet, ownedByOther).Build()
+	r := ApplicationSetReconciler{
+		Client:          client,
+		Scheme:          scheme,
+		Recorder:        record.NewFakeRecorder(10),
+		ArgoCDNamespace: "argocd",
+	}
+
+	err = r.createOrUpdateInCluster(context.TODO(), appSet, []argov1alpha1.Application{
+		{ObjectMeta: metav1.ObjectMeta{Name: "app"}},
+	})
+	assert.Nil(t, err)
+
+	// The Application is created in the Argo CD namespace, and owned with the owner annotation
+	app := &argov1alpha1.Application{}
+	err = client.Get(context.TODO(), crtclient.ObjectKey{Namespace: "argocd", Name: "app"}, app)
+	assert.Nil(t, err)
+	assert.Empty(t, app.OwnerReferences)
+	assert.Equal(t, "team-a/name", app.Annotations[common.AnnotationApplicationSetOwner])
+	assert.Equal(t, "team-a/name", applicationOwner(app))
+
+	// An Application of another ApplicationSet, with the same name, is not taken over
+	err = r.createOrUpdateInCluster(context.TODO(), appSet, []argov1alpha1.Application{
+		{ObjectMeta: metav1.ObjectMeta{Name: "other"}},
+	})
+	assert.EqualError(t, err, "application other is already owned by ApplicationSet team-b/name")
+	err = client.Get(context.TODO(), crtclient.ObjectKey{Namespace: "argocd", Name: "other"}, app)
+	assert.Nil(t, err)
+	assert.Equal(t, "team-b/name", app.Annotations[common.AnnotationApplicationSetOwner])
+}
+
+func TestCreateOrUpdateInClusterDoesNotTakeOverApplications(t *testing.T) {
+	scheme := runtime.NewScheme()
+	err := argoprojiov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+	err = argov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+
+	tenantAppSet := argoprojiov1alpha1.ApplicationSet{ObjectMeta: metav1.ObjectMeta{Name: "name", Namespace: "team-a"}}
+	argoCDAppSet := argoprojiov1alpha1.ApplicationSet{ObjectMeta: metav1.ObjectMeta{Name: "name", Namespace: "argocd"}}
+	controller := true
+
+	for _, c := range []struct {
+		desc        string
+		appSet      argoprojiov1alpha1.ApplicationSet
+		existing    argov1alpha1.Application
+		expectedErr string
+	}{
+		{
+			desc:        "tenant ApplicationSet and unowned Application",
+			appSet:      tenantAppSet,
+			existing:    argov1alpha1.Application{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "argocd"}},
+			expectedErr: "application app already exists and is not owned by ApplicationSet team-a/name",
+		},
+		{
+			desc:   "Argo CD namespace ApplicationSet and Application controlled by something else",
+			appSet: argoCDAppSet,
+			existing: argov1alpha1.Application{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "argocd",
+				OwnerReferences: []metav1.OwnerReference{{APIVersion: "example.com/v1", Kind: "Other", Name: "other", Controller: &controller}}}},
+			expectedErr: "application app is already owned by Other other",
+		},
+	} {
+		for _, serverSideApply := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s, server-side apply %v", c.desc, serverSideApply), func(t *testing.T) {
+				existing := c.existing.DeepCopy()
+				existing.Spec.Project = "admin"
+				client := &applyClient{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(&c.appSet, existing).Build()}
+				r := ApplicationSetReconciler{
+					Client:                client,
+					Scheme:                scheme,
+					Recorder:              record.NewFakeRecorder(10),
+					ArgoCDNamespace:       "argocd",
+					EnableServerSideApply: serverSideApply,
+				}
+
+				err := r.createOrUpdateInCluster(context.TODO(), c.appSet, []argov1alpha1.Application{
+					{ObjectMeta: metav1.ObjectMeta{Name: "app"}, Spec: argov1alpha1.ApplicationSpec{Project: "tenant"}},
+				})
+				assert.EqualError(t, err, c.expectedErr)
+				assert.Empty(t, client.fieldManagers, "the Application is not applied")
+
+				// The Application is left untouched
+				app := &argov1alpha1.Application{}
+				err = client.Get(context.TODO(), crtclient.ObjectKey{Namespace: "argocd", Name: "app"}, app)
+				assert.Nil(t, err)
+				assert.Equal(t, "admin", app.Spec.Project)
+				assert.Empty(t, app.Annotations)
+				assert.Equal(t, c.existing.OwnerReferences, app.OwnerReferences)
+			})
+		}
+	}
+}
+
+func TestApplicationOwner(t *testing.T) {
+	scheme := runtime.NewScheme()
+	err := argoprojiov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+
+	appSet := &argoprojiov1alpha1.ApplicationSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "name",
+			Namespace: "argocd",
+		},
+	}
+	owned := &argov1alpha1.Application{ObjectMeta: metav1.ObjectMeta{Name: "owned", Namespace: "argocd"}}
+	err = controllerutil.SetControllerReference(appSet, owned, scheme)
+	assert.Nil(t, err)
+
+	annotated := &argov1alpha1.Application{ObjectMeta: metav1.ObjectMeta{
+		Name:        "annotated",
+		Namespace:   "argocd",
+		Annotations: map[string]string{common.AnnotationApplicationSetOwner: "team-a/name"},
+	}}
+
+	assert.Equal(t, "argocd/name", applicationOwner(owned))
+	assert.Equal(t, "team-a/name", applicationOwner(annotated))
+	assert.Equal(t, "", applicationOwner(&argov1alpha1.Application{}))
+	controller := true
+	foreign := &argov1alpha1.Application{ObjectMeta: metav1.ObjectMeta{Name: "foreign", Namespace: "argocd",
+		OwnerReferences: []metav1.OwnerReference{{APIVersion: "example.com/v1", Kind: "Other", Name: "other", Controller: &controller}}}}
+	assert.Equal(t, "controller:Other other", applicationOwner(foreign), "Applications controlled by something else are owned")
+	assert.Equal(t, "Other other", describeApplicationOwner(applicationOwner(foreign)))
+	assert.Equal(t, "ApplicationSet team-a/name", describeApplicationOwner(applicationOwner(annotated)))
+
+	assert.Empty(t, applicationOwnerRequests(owned))
+	assert.Equal(t, []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: "team-a", Name: "name"}}}, applicationOwnerRequests(annotated))
+}
+
 func TestSetApplicationSetStatusCondition(t *testing.T) {
 	scheme := runtime.NewScheme()
 	err := argoprojiov1alpha1.AddToScheme(scheme)
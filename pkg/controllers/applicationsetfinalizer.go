@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	argoprojiov1alpha1 "github.com/argoproj/applicationset/api/v1alpha1"
+	"github.com/argoproj/applicationset/common"
+	"github.com/argoproj/applicationset/pkg/utils"
+)
+
+// applicationSetDeletionRequeueAfter is how often the deletion of an ApplicationSet is rechecked, while its
+// Applications are waiting for Argo CD to delete their resources
+const applicationSetDeletionRequeueAfter = 10 * time.Second
+
+// reconcileFinalizer adds the ApplicationSet finalizer to an ApplicationSet with a deletion policy, and removes it
+// from an ApplicationSet without one. ApplicationSets in another namespace than Argo CD always have the finalizer, as
+// their Applications are not deleted by the Kubernetes garbage collector.
+func (r *ApplicationSetReconciler) reconcileFinalizer(ctx context.Context, applicationSet *argoprojiov1alpha1.ApplicationSet) error {
+	needsFinalizer := applicationSet.Spec.SyncPolicy != nil && applicationSet.Spec.SyncPolicy.DeletionPolicy != "" ||
+		!r.ownsApplicationsByReference(*applicationSet)
+	if needsFinalizer == controllerutil.ContainsFinalizer(applicationSet, common.ApplicationSetFinalizer) {
+		return nil
+	}
+
+	if needsFinalizer {
+		controllerutil.AddFinalizer(applicationSet, common.ApplicationSetFinalizer)
+	} else {
+		controllerutil.RemoveFinalizer(applicationSet, common.ApplicationSetFinalizer)
+	}
+	return r.Client.Update(ctx, applicationSet)
+}
+
+// finalizeApplicationSet applies the deletion policy of an ApplicationSet which is being deleted to its
+// Applications, and reports the progress in the ApplicationSet status. The ApplicationSet finalizer is removed once
+// all of its Applications have been deleted or orphaned.
+func (r *ApplicationSetReconciler) finalizeApplicationSet(ctx context.Context, applicationSet *argoprojiov1alpha1.ApplicationSet) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(applicationSet, common.ApplicationSetFinalizer) {
+		// The Applications are deleted by the Kubernetes garbage collector
+		return ctrl.Result{}, nil
+	}
+
+	var policy argoprojiov1alpha1.ApplicationSetDeletionPolicy
+	if applicationSet.Spec.SyncPolicy != nil {
+		policy = applicationSet.Spec.SyncPolicy.DeletionPolicy
+	}
+
+	current, err := r.getCurrentApplications(ctx, *applicationSet)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	var clusterList *argov1alpha1.ClusterList
+	if policy == argoprojiov1alpha1.ApplicationSetDeletionPolicyDeleteApplicationsAndResources && len(current) > 0 {
+		if clusterList, err = utils.ListClusters(ctx, r.KubeClientset, r.argoCDNamespace(*applicationSet)); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	remaining := 0
+	var firstError error
+	for i := range current {
+		app := &current[i]
+		appLog := log.WithFields(log.Fields{"app": app.Name, "appSet": applicationSet.Name})
+
+		gone, err := r.finalizeApplication(ctx, *applicationSet, policy, app, clusterList, appLog)
+		if err != nil {
+			appLog.WithError(err).Errorf("failed to finalize Application with deletion policy %s", policy)
+			if firstError == nil {
+				firstError = err
+			}
+		}
+		if !gone {
+			remaining++
+		}
+	}
+
+	if remaining == 0 && firstError == nil {
+		log.WithField("appSet", applicationSet.Name).Infof("all Applications have been finalized with deletion policy %s", policy)
+		controllerutil.RemoveFinalizer(applicationSet, common.ApplicationSetFinalizer)
+		return ctrl.Result{}, client.IgnoreNotFound(r.Client.Update(ctx, applicationSet))
+	}
+
+	message := fmt.Sprintf("waiting for %d Applications to be deleted", remaining)
+	if firstError != nil {
+		message = firstError.Error()
+	}
+	if err := r.setApplicationSetDeletionStatus(ctx, applicationSet, policy, remaining, message); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: applicationSetDeletionRequeueAfter}, firstError
+}
+
+// finalizeApplication applies the deletion policy to an Application of the ApplicationSet. Returns true if the
+// Application is no longer owned by the ApplicationSet, and false if it still has to be waited for.
+func (r *ApplicationSetReconciler) finalizeApplication(ctx context.Context, applicationSet argoprojiov1alpha1.ApplicationSet, policy argoprojiov1alpha1.ApplicationSetDeletionPolicy, app *argov1alpha1.Application, clusterList *argov1alpha1.ClusterList, appLog *log.Entry) (bool, error) {
+	switch policy {
+	case argoprojiov1alpha1.ApplicationSetDeletionPolicyOrphanApplications:
+		if err := r.orphanApplication(ctx, applicationSet, app); err != nil {
+			return false, err
+		}
+		r.Recorder.Eventf(&applicationSet, corev1.EventTypeNormal, "Orphaned", "Orphaned Application %q", app.Name)
+		appLog.Log(log.InfoLevel, "Orphaned application")
+		return true, nil
+
+	case argoprojiov1alpha1.ApplicationSetDeletionPolicyDeleteApplications:
+		// The resources finalizer is removed even from an Application which is already being deleted, so that
+		// its resources are kept
+		if controllerutil.ContainsFinalizer(app, argov1alpha1.ResourcesFinalizerName) {
+			original := app.DeepCopy()
+			controllerutil.RemoveFinalizer(app, argov1alpha1.ResourcesFinalizerName)
+			if err := r.Client.Patch(ctx, app, client.MergeFrom(original)); err != nil {
+				return false, client.IgnoreNotFound(err)
+			}
+		}
+
+	case argoprojiov1alpha1.ApplicationSetDeletionPolicyDeleteApplicationsAndResources:
+		if app.DeletionTimestamp == nil && !controllerutil.ContainsFinalizer(app, argov1alpha1.ResourcesFinalizerName) {
+			original := app.DeepCopy()
+			controllerutil.AddFinalizer(app, argov1alpha1.ResourcesFinalizerName)
+			if err := r.Client.Patch(ctx, app, client.MergeFrom(original)); err != nil {
+				return false, client.IgnoreNotFound(err)
+			}
+		}
+		// Argo CD can't delete the resources of an Application whose cluster no longer exists
+		if err := r.removeFinalizerOnInvalidDestination(ctx, applicationSet, app, clusterList, appLog); err != nil {
+			return false, client.IgnoreNotFound(err)
+		}
+
+	default:
+		if r.ownsApplicationsByReference(applicationSet) {
+			// The deletion policy was removed after the ApplicationSet started being deleted: the Applications are
+			// left to the Kubernetes garbage collector, once the ApplicationSet finalizer has been removed
+			return true, nil
+		}
+		// The Applications of an ApplicationSet in another namespace are deleted as the garbage collector would
+	}
+
+	if app.DeletionTimestamp == nil {
+		if err := r.Client.Delete(ctx, app); err != nil {
+			if apierr.IsNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		}
+		r.Recorder.Eventf(&applicationSet, corev1.EventTypeNormal, "Deleted", "Deleted Application %q", app.Name)
+		appLog.Log(log.InfoLevel, "Deleted application")
+	}
+	// The Application is gone right away, unless Argo CD has to delete its resources first
+	return len(app.Finalizers) == 0, nil
+}
+
+// setApplicationSetDeletionStatus updates the deletion status of the ApplicationSet, if it changed.
+func (r *ApplicationSetReconciler) setApplicationSetDeletionStatus(ctx context.Context, applicationSet *argoprojiov1alpha1.ApplicationSet, policy argoprojiov1alpha1.ApplicationSetDeletionPolicy, remaining int, message string) error {
+	current := applicationSet.Status.Deletion
+	if current != nil && current.Policy == policy && current.RemainingApplications == remaining && current.Message == message {
+		return nil
+	}
+
+	now := metav1.Now()
+	applicationSet.Status.Deletion = &argoprojiov1alpha1.ApplicationSetDeletionStatus{
+		Policy:                policy,
+		RemainingApplications: remaining,
+		Message:               message,
+		LastUpdateTime:        &now,
+	}
+	if err := r.Client.Status().Update(ctx, applicationSet); err != nil && !apierr.IsNotFound(err) {
+		return fmt.Errorf("unable to set application set deletion status: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	crtclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	argoprojiov1alpha1 "github.com/argoproj/applicationset/api/v1alpha1"
+	"github.com/argoproj/applicationset/common"
+)
+
+func TestReconcileFinalizer(t *testing.T) {
+	scheme := runtime.NewScheme()
+	err := argoprojiov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+
+	for _, c := range []struct {
+		name              string
+		deletionPolicy    argoprojiov1alpha1.ApplicationSetDeletionPolicy
+		argoCDNamespace   string
+		finalizers        []string
+		expectedFinalizer bool
+	}{
+		{
+			name:              "finalizer is added with a deletion policy",
+			deletionPolicy:    argoprojiov1alpha1.ApplicationSetDeletionPolicyOrphanApplications,
+			expectedFinalizer: true,
+		},
+		{
+			name:              "finalizer is kept with a deletion policy",
+			deletionPolicy:    argoprojiov1alpha1.ApplicationSetDeletionPolicyDeleteApplications,
+			finalizers:        []string{common.ApplicationSetFinalizer},
+			expectedFinalizer: true,
+		},
+		{
+			name:              "finalizer is removed without a deletion policy",
+			finalizers:        []string{common.ApplicationSetFinalizer},
+			expectedFinalizer: false,
+		},
+		{
+			name:              "no finalizer without a deletion policy",
+			expectedFinalizer: false,
+		},
+		{
+			name:              "finalizer is added without a deletion policy in another namespace than Argo CD",
+			argoCDNamespace:   "argocd",
+			expectedFinalizer: true,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			appSet := argoprojiov1alpha1.ApplicationSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "name",
+					Namespace:  "namespace",
+					Finalizers: c.finalizers,
+				},
+				Spec: argoprojiov1alpha1.ApplicationSetSpec{
+					SyncPolicy: &argoprojiov1alpha1.ApplicationSetSyncPolicy{
+						DeletionPolicy: c.deletionPolicy,
+					},
+				},
+			}
+			client := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&appSet).Build()
+			r := ApplicationSetReconciler{
+				Client:          client,
+				Scheme:          scheme,
+				ArgoCDNamespace: c.argoCDNamespace,
+			}
+
+			err := r.reconcileFinalizer(context.TODO(), &appSet)
+			assert.Nil(t, err)
+
+			got := &argoprojiov1alpha1.ApplicationSet{}
+			err = client.Get(context.Background(), crtclient.ObjectKey{Namespace: "namespace", Name: "name"}, got)
+			assert.Nil(t, err)
+			assert.Equal(t, c.expectedFinalizer, controllerutil.ContainsFinalizer(got, common.ApplicationSetFinalizer))
+		})
+	}
+}
+
+func TestFinalizeApplicationSet(t *testing.T) {
+	scheme := runtime.NewScheme()
+	err := argoprojiov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+	err = argov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+
+	for _, c := range []struct {
+		name           string
+		deletionPolicy argoprojiov1alpha1.ApplicationSetDeletionPolicy
+		// expectedApps are the Applications that remain after the ApplicationSet has been finalized, with whether
+		// they are being deleted
+		expectedApps map[string]bool
+		// expectedRemaining is the number of Applications that are waited for, if the ApplicationSet is not
+		// finalized yet
+		expectedRemaining int
+	}{
+		{
+			name:           "orphan Applications",
+			deletionPolicy: argoprojiov1alpha1.ApplicationSetDeletionPolicyOrphanApplications,
+			expectedApps:   map[string]bool{"with-finalizer": false, "without-finalizer": false},
+		},
+		{
+			name:           "delete Applications, but not their resources",
+			deletionPolicy: argoprojiov1alpha1.ApplicationSetDeletionPolicyDeleteApplications,
+			expectedApps:   map[string]bool{},
+		},
+		{
+			name:              "delete Applications and their resources",
+			deletionPolicy:    argoprojiov1alpha1.ApplicationSetDeletionPolicyDeleteApplicationsAndResources,
+			expectedApps:      map[string]bool{"with-finalizer": true, "without-finalizer": true},
+			expectedRemaining: 2,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			now := metav1.Now()
+			appSet := argoprojiov1alpha1.ApplicationSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "name",
+					Namespace:         "namespace",
+					Finalizers:        []string{common.ApplicationSetFinalizer},
+					DeletionTimestamp: &now,
+				},
+				Spec: argoprojiov1alpha1.ApplicationSetSpec{
+					SyncPolicy: &argoprojiov1alpha1.ApplicationSetSyncPolicy{
+						DeletionPolicy: c.deletionPolicy,
+					},
+				},
+			}
+			initObjs := []crtclient.Object{&appSet}
+			for name, finalizers := range map[string][]string{
+				"with-finalizer":    {argov1alpha1.ResourcesFinalizerName},
+				"without-finalizer": nil,
+			} {
+				app := &argov1alpha1.Application{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:       name,
+						Namespace:  "namespace",
+						Finalizers: finalizers,
+					},
+					Spec: argov1alpha1.ApplicationSpec{
+						Destination: argov1alpha1.ApplicationDestination{
+							Server:    argov1alpha1.KubernetesInternalAPIServerAddr,
+							Namespace: "default",
+						},
+					},
+				}
+				err = controllerutil.SetControllerReference(&appSet, app, scheme)
+				assert.Nil(t, err)
+				initObjs = append(initObjs, app)
+			}
+
+			client := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjs...).Build()
+			r := ApplicationSetReconciler{
+				Client:        client,
+				Scheme:        scheme,
+				Recorder:      record.NewFakeRecorder(10),
+				KubeClientset: kubefake.NewSimpleClientset(),
+			}
+
+			res, err := r.finalizeApplicationSet(context.TODO(), &appSet)
+			assert.Nil(t, err)
+
+			list := &argov1alpha1.ApplicationList{}
+			err = client.List(context.Background(), list)
+			assert.Nil(t, err)
+			apps := map[string]bool{}
+			for _, app := range list.Items {
+				apps[app.Name] = app.DeletionTimestamp != nil
+				if c.deletionPolicy == argoprojiov1alpha1.ApplicationSetDeletionPolicyOrphanApplications {
+					assert.Empty(t, app.OwnerReferences)
+				}
+				if c.deletionPolicy == argoprojiov1alpha1.ApplicationSetDeletionPolicyDeleteApplicationsAndResources {
+					assert.Equal(t, []string{argov1alpha1.ResourcesFinalizerName}, app.Finalizers)
+				}
+			}
+			assert.Equal(t, c.expectedApps, apps)
+
+			got := &argoprojiov1alpha1.ApplicationSet{}
+			err = client.Get(context.Background(), crtclient.ObjectKey{Namespace: "namespace", Name: "name"}, got)
+			if c.expectedRemaining == 0 {
+				// The finalizer is removed, so that the ApplicationSet is deleted
+				assert.True(t, apierr.IsNotFound(err))
+				assert.Equal(t, ctrl.Result{}, res)
+				return
+			}
+			assert.Nil(t, err)
+			assert.True(t, controllerutil.ContainsFinalizer(got, common.ApplicationSetFinalizer))
+			assert.Equal(t, ctrl.Result{RequeueAfter: applicationSetDeletionRequeueAfter}, res)
+			if assert.NotNil(t, got.Status.Deletion) {
+				assert.Equal(t, c.deletionPolicy, got.Status.Deletion.Policy)
+				assert.Equal(t, c.expectedRemaining, got.Status.Deletion.RemainingApplications)
+				assert.Equal(t, "waiting for 2 Applications to be deleted", got.Status.Deletion.Message)
+			}
+		})
+	}
+}
+
+func TestFinalizeApplicationSetInOtherNamespace(t *testing.T) {
+	scheme := runtime.NewScheme()
+	err := argoprojiov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+	err = argov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+
+	now := metav1.Now()
+	appSet := argoprojiov1alpha1.ApplicationSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "name",
+			Namespace:         "team",
+			Finalizers:        []string{common.ApplicationSetFinalizer},
+			DeletionTimestamp: &now,
+		},
+	}
+	initObjs := []crtclient.Object{&appSet}
+	for name, finalizers := range map[string][]string{
+		"with-finalizer":    {argov1alpha1.ResourcesFinalizerName},
+		"without-finalizer": nil,
+	} {
+		initObjs = append(initObjs, &argov1alpha1.Application{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "argocd",
+				Finalizers:  finalizers,
+				Annotations: map[string]string{common.AnnotationApplicationSetOwner: "team/name"},
+			},
+		})
+	}
+
+	client := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjs...).Build()
+	r := ApplicationSetReconciler{
+		Client:          client,
+		Scheme:          scheme,
+		Recorder:        record.NewFakeRecorder(10),
+		KubeClientset:   kubefake.NewSimpleClientset(),
+		ArgoCDNamespace: "argocd",
+	}
+
+	// Without a deletion policy, the Applications are deleted as the garbage collector would, with their resources
+	// finalizer left as it is
+	res, err := r.finalizeApplicationSet(context.TODO(), &appSet)
+	assert.Nil(t, err)
+	assert.Equal(t, ctrl.Result{RequeueAfter: applicationSetDeletionRequeueAfter}, res)
+
+	list := &argov1alpha1.ApplicationList{}
+	err = client.List(context.Background(), list)
+	assert.Nil(t, err)
+	if assert.Len(t, list.Items, 1) {
+		assert.Equal(t, "with-finalizer", list.Items[0].Name)
+		assert.NotNil(t, list.Items[0].DeletionTimestamp)
+		assert.Equal(t, []string{argov1alpha1.ResourcesFinalizerName}, list.Items[0].Finalizers)
+	}
+
+	got := &argoprojiov1alpha1.ApplicationSet{}
+	err = client.Get(context.Background(), crtclient.ObjectKey{Namespace: "team", Name: "name"}, got)
+	assert.Nil(t, err)
+	assert.True(t, controllerutil.ContainsFinalizer(got, common.ApplicationSetFinalizer))
+}
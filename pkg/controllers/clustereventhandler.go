@@ -5,6 +5,8 @@ import (
 
 	log "github.com/sirupsen/logrus"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -21,6 +23,8 @@ type clusterSecretEventHandler struct {
 	//handler.EnqueueRequestForOwner
 	Log    log.FieldLogger
 	Client client.Client
+	// Namespace is the Argo CD namespace: secrets in other namespaces are not cluster secrets. Any namespace if empty.
+	Namespace string
 }
 
 func (h *clusterSecretEventHandler) Create(e event.CreateEvent, q workqueue.RateLimitingInterface) {
@@ -28,7 +32,9 @@ func (h *clusterSecretEventHandler) Create(e event.CreateEvent, q workqueue.Rate
 }
 
 func (h *clusterSecretEventHandler) Update(e event.UpdateEvent, q workqueue.RateLimitingInterface) {
-	h.queueRelatedAppGenerators(q, e.ObjectNew)
+	// Both the old and new labels are matched: ApplicationSets which no longer select the cluster need to be
+	// requeued as well, to remove its Applications.
+	h.queueRelatedAppGenerators(q, e.ObjectOld, e.ObjectNew)
 }
 
 func (h *clusterSecretEventHandler) Delete(e event.DeleteEvent, q workqueue.RateLimitingInterface) {
@@ -45,12 +51,22 @@ type addRateLimitingInterface interface {
 	Add(item interface{})
 }
 
-func (h *clusterSecretEventHandler) queueRelatedAppGenerators(q addRateLimitingInterface, object client.Object) {
+// queueRelatedAppGenerators queues the ApplicationSets with a cluster generator (including those nested within
+// Matrix and Merge generators) whose selector matches the labels of any of the given versions of the secret.
+func (h *clusterSecretEventHandler) queueRelatedAppGenerators(q addRateLimitingInterface, objects ...client.Object) {
 
 	// Check for label, lookup all ApplicationSets that might match the cluster, queue them all
-	if object.GetLabels()[generators.ArgoCDSecretTypeLabel] != generators.ArgoCDSecretTypeCluster {
+	secretLabels := []labels.Set{}
+	for _, object := range objects {
+		if object != nil && (h.Namespace == "" || object.GetNamespace() == h.Namespace) &&
+			object.GetLabels()[generators.ArgoCDSecretTypeLabel] == generators.ArgoCDSecretTypeCluster {
+			secretLabels = append(secretLabels, labels.Set(object.GetLabels()))
+		}
+	}
+	if len(secretLabels) == 0 {
 		return
 	}
+	object := objects[len(objects)-1]
 
 	h.Log.WithFields(log.Fields{
 		"namespace": object.GetNamespace(),
@@ -65,20 +81,88 @@ func (h *clusterSecretEventHandler) queueRelatedAppGenerators(q addRateLimitingI
 	}
 
 	h.Log.WithField("count", len(appSetList.Items)).Info("listed ApplicationSets")
+	queued := 0
 	for _, appSet := range appSetList.Items {
 
-		foundClusterGenerator := false
-		for _, generator := range appSet.Spec.Generators {
-			if generator.Clusters != nil {
-				foundClusterGenerator = true
-				break
+		if h.clusterGeneratorsMatch(&appSet, secretLabels) {
+			req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: appSet.Namespace, Name: appSet.Name}}
+			q.Add(req)
+			queued++
+		}
+	}
+	h.Log.WithField("count", queued).Debug("queued ApplicationSets matching cluster secret")
+}
+
+// clusterGeneratorsMatch returns true if any cluster generator of the ApplicationSet selects a secret with any of
+// the given labels. If the generators can't be evaluated, true is returned, so that the ApplicationSet is not
+// missed.
+func (h *clusterSecretEventHandler) clusterGeneratorsMatch(appSet *argoprojiov1alpha1.ApplicationSet, secretLabels []labels.Set) bool {
+	clusterGenerators, err := getClusterGenerators(appSet)
+	if err != nil {
+		h.Log.WithError(err).WithField("applicationset", appSet.Name).Warn("unable to evaluate nested generators, queueing ApplicationSet")
+		return true
+	}
+
+	for _, clusterGenerator := range clusterGenerators {
+		// Match the selector in the same way as the cluster generator does
+		selector, err := metav1.LabelSelectorAsSelector(metav1.AddLabelToSelector(&clusterGenerator.Selector, generators.ArgoCDSecretTypeLabel, generators.ArgoCDSecretTypeCluster))
+		if err != nil {
+			h.Log.WithError(err).WithField("applicationset", appSet.Name).Warn("invalid cluster generator selector, queueing ApplicationSet")
+			return true
+		}
+		for _, set := range secretLabels {
+			if selector.Matches(set) {
+				return true
 			}
 		}
-		if foundClusterGenerator {
+	}
+	return false
+}
 
-			// TODO: only queue the AppGenerator if the labels match this cluster
-			req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: appSet.Namespace, Name: appSet.Name}}
-			q.Add(req)
+// getClusterGenerators returns the cluster generators of the ApplicationSet, including those nested within Matrix
+// and Merge generators.
+func getClusterGenerators(appSet *argoprojiov1alpha1.ApplicationSet) ([]*argoprojiov1alpha1.ClusterGenerator, error) {
+	res := []*argoprojiov1alpha1.ClusterGenerator{}
+	for _, generator := range appSet.Spec.Generators {
+		if generator.Clusters != nil {
+			res = append(res, generator.Clusters)
+		}
+
+		var nestedGenerators []argoprojiov1alpha1.ApplicationSetNestedGenerator
+		if generator.Matrix != nil {
+			nestedGenerators = append(nestedGenerators, generator.Matrix.Generators...)
+		}
+		if generator.Merge != nil {
+			nestedGenerators = append(nestedGenerators, generator.Merge.Generators...)
+		}
+
+		for _, nestedGenerator := range nestedGenerators {
+			if nestedGenerator.Clusters != nil {
+				res = append(res, nestedGenerator.Clusters)
+			}
+
+			var terminalGenerators []argoprojiov1alpha1.ApplicationSetTerminalGenerator
+			if nestedGenerator.Matrix != nil {
+				nestedMatrix, err := argoprojiov1alpha1.ToNestedMatrixGenerator(nestedGenerator.Matrix)
+				if err != nil {
+					return nil, err
+				}
+				terminalGenerators = append(terminalGenerators, nestedMatrix.Generators...)
+			}
+			if nestedGenerator.Merge != nil {
+				nestedMerge, err := argoprojiov1alpha1.ToNestedMergeGenerator(nestedGenerator.Merge)
+				if err != nil {
+					return nil, err
+				}
+				terminalGenerators = append(terminalGenerators, nestedMerge.Generators...)
+			}
+
+			for _, terminalGenerator := range terminalGenerators {
+				if terminalGenerator.Clusters != nil {
+					res = append(res, terminalGenerator.Clusters)
+				}
+			}
 		}
 	}
+	return res, nil
 }
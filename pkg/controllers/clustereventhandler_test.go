@@ -9,6 +9,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
@@ -27,9 +28,11 @@ func TestClusterEventHandler(t *testing.T) {
 	assert.Nil(t, err)
 
 	tests := []struct {
-		name             string
-		items            []argoprojiov1alpha1.ApplicationSet
-		secret           corev1.Secret
+		name   string
+		items  []argoprojiov1alpha1.ApplicationSet
+		secret corev1.Secret
+		// oldSecret is the previous version of secret, for update events
+		oldSecret        *corev1.Secret
 		expectedRequests []ctrl.Request
 	}{
 		{
@@ -189,6 +192,89 @@ func TestClusterEventHandler(t *testing.T) {
 			},
 			expectedRequests: []reconcile.Request{},
 		},
+		{
+			name: "cluster generator selector should match secret labels",
+			items: []argoprojiov1alpha1.ApplicationSet{
+				clusterEventHandlerTestAppSet("production-set", argoprojiov1alpha1.ApplicationSetGenerator{
+					Clusters: &argoprojiov1alpha1.ClusterGenerator{
+						Selector: v1.LabelSelector{MatchLabels: map[string]string{"environment": "production"}},
+					},
+				}),
+				clusterEventHandlerTestAppSet("staging-set", argoprojiov1alpha1.ApplicationSetGenerator{
+					Clusters: &argoprojiov1alpha1.ClusterGenerator{
+						Selector: v1.LabelSelector{MatchLabels: map[string]string{"environment": "staging"}},
+					},
+				}),
+			},
+			secret: clusterEventHandlerTestSecret(map[string]string{"environment": "production"}),
+			expectedRequests: []reconcile.Request{
+				{NamespacedName: types.NamespacedName{Namespace: "argocd", Name: "production-set"}},
+			},
+		},
+		{
+			name: "cluster generator selector should match old secret labels",
+			items: []argoprojiov1alpha1.ApplicationSet{
+				clusterEventHandlerTestAppSet("production-set", argoprojiov1alpha1.ApplicationSetGenerator{
+					Clusters: &argoprojiov1alpha1.ClusterGenerator{
+						Selector: v1.LabelSelector{MatchLabels: map[string]string{"environment": "production"}},
+					},
+				}),
+				clusterEventHandlerTestAppSet("staging-set", argoprojiov1alpha1.ApplicationSetGenerator{
+					Clusters: &argoprojiov1alpha1.ClusterGenerator{
+						Selector: v1.LabelSelector{MatchLabels: map[string]string{"environment": "staging"}},
+					},
+				}),
+				clusterEventHandlerTestAppSet("test-set", argoprojiov1alpha1.ApplicationSetGenerator{
+					Clusters: &argoprojiov1alpha1.ClusterGenerator{
+						Selector: v1.LabelSelector{MatchLabels: map[string]string{"environment": "test"}},
+					},
+				}),
+			},
+			oldSecret: clusterEventHandlerTestSecretPtr(map[string]string{"environment": "staging"}),
+			secret:    clusterEventHandlerTestSecret(map[string]string{"environment": "production"}),
+			expectedRequests: []reconcile.Request{
+				{NamespacedName: types.NamespacedName{Namespace: "argocd", Name: "production-set"}},
+				{NamespacedName: types.NamespacedName{Namespace: "argocd", Name: "staging-set"}},
+			},
+		},
+		{
+			name: "cluster generators nested in matrix and merge generators should match",
+			items: []argoprojiov1alpha1.ApplicationSet{
+				clusterEventHandlerTestAppSet("matrix-set", argoprojiov1alpha1.ApplicationSetGenerator{
+					Matrix: &argoprojiov1alpha1.MatrixGenerator{
+						Generators: []argoprojiov1alpha1.ApplicationSetNestedGenerator{
+							{List: &argoprojiov1alpha1.ListGenerator{}},
+							{Clusters: &argoprojiov1alpha1.ClusterGenerator{
+								Selector: v1.LabelSelector{MatchLabels: map[string]string{"environment": "staging"}},
+							}},
+						},
+					},
+				}),
+				clusterEventHandlerTestAppSet("nested-matrix-set", argoprojiov1alpha1.ApplicationSetGenerator{
+					Merge: &argoprojiov1alpha1.MergeGenerator{
+						Generators: []argoprojiov1alpha1.ApplicationSetNestedGenerator{
+							{List: &argoprojiov1alpha1.ListGenerator{}},
+							{Matrix: &apiextensionsv1.JSON{Raw: []byte(`{"generators": [{"list": {"elements": []}}, {"clusters": {"selector": {"matchLabels": {"environment": "staging"}}}}]}`)}},
+						},
+					},
+				}),
+				clusterEventHandlerTestAppSet("non-matching-set", argoprojiov1alpha1.ApplicationSetGenerator{
+					Matrix: &argoprojiov1alpha1.MatrixGenerator{
+						Generators: []argoprojiov1alpha1.ApplicationSetNestedGenerator{
+							{List: &argoprojiov1alpha1.ListGenerator{}},
+							{Clusters: &argoprojiov1alpha1.ClusterGenerator{
+								Selector: v1.LabelSelector{MatchLabels: map[string]string{"environment": "production"}},
+							}},
+						},
+					},
+				}),
+			},
+			secret: clusterEventHandlerTestSecret(map[string]string{"environment": "staging"}),
+			expectedRequests: []reconcile.Request{
+				{NamespacedName: types.NamespacedName{Namespace: "argocd", Name: "matrix-set"}},
+				{NamespacedName: types.NamespacedName{Namespace: "argocd", Name: "nested-matrix-set"}},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -208,7 +294,11 @@ func TestClusterEventHandler(t *testing.T) {
 
 			mockAddRateLimitingInterface := mockAddRateLimitingInterface{}
 
-			handler.queueRelatedAppGenerators(&mockAddRateLimitingInterface, &test.secret)
+			if test.oldSecret != nil {
+				handler.queueRelatedAppGenerators(&mockAddRateLimitingInterface, test.oldSecret, &test.secret)
+			} else {
+				handler.queueRelatedAppGenerators(&mockAddRateLimitingInterface, &test.secret)
+			}
 
 			assert.False(t, mockAddRateLimitingInterface.errorOccurred)
 			assert.ElementsMatch(t, mockAddRateLimitingInterface.addedItems, test.expectedRequests)
@@ -218,6 +308,34 @@ func TestClusterEventHandler(t *testing.T) {
 
 }
 
+func clusterEventHandlerTestAppSet(name string, generator argoprojiov1alpha1.ApplicationSetGenerator) argoprojiov1alpha1.ApplicationSet {
+	return argoprojiov1alpha1.ApplicationSet{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      name,
+			Namespace: "argocd",
+		},
+		Spec: argoprojiov1alpha1.ApplicationSetSpec{
+			Generators: []argoprojiov1alpha1.ApplicationSetGenerator{generator},
+		},
+	}
+}
+
+func clusterEventHandlerTestSecret(secretLabels map[string]string) corev1.Secret {
+	secretLabels[generators.ArgoCDSecretTypeLabel] = generators.ArgoCDSecretTypeCluster
+	return corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Namespace: "argocd",
+			Name:      "my-secret",
+			Labels:    secretLabels,
+		},
+	}
+}
+
+func clusterEventHandlerTestSecretPtr(secretLabels map[string]string) *corev1.Secret {
+	secret := clusterEventHandlerTestSecret(secretLabels)
+	return &secret
+}
+
 // Add checks the type, and adds it to the internal list of received additions
 func (obj *mockAddRateLimitingInterface) Add(item interface{}) {
 	if req, ok := item.(ctrl.Request); ok {
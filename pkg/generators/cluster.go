@@ -2,16 +2,23 @@ package generators
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
+	argoappv1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
 	"github.com/argoproj/argo-cd/v2/util/settings"
+	gocache "github.com/patrickmn/go-cache"
 	log "github.com/sirupsen/logrus"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/kubernetes"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
@@ -24,28 +31,54 @@ const (
 	ArgoCDSecretTypeCluster = "cluster"
 )
 
+const (
+	// clusterInfoTimeout bounds the requests for the Kubernetes version of a cluster
+	clusterInfoTimeout = 10 * time.Second
+	// clusterInfoCacheTTL is how long the Kubernetes version of a cluster is cached. Failures are cached for
+	// clusterInfoFailureCacheTTL, so that an unreachable cluster neither stalls every reconciliation, nor is
+	// reported as failed for long after it is back.
+	clusterInfoCacheTTL        = 5 * time.Minute
+	clusterInfoFailureCacheTTL = time.Minute
+)
+
 var _ Generator = (*ClusterGenerator)(nil)
 
 // ClusterGenerator generates Applications for some or all clusters registered with ArgoCD.
 type ClusterGenerator struct {
 	client.Client
-	ctx       context.Context
 	clientset kubernetes.Interface
 	// namespace is the Argo CD namespace
 	namespace       string
 	settingsManager *settings.SettingsManager
+	// localClusterLabels and localClusterAnnotations are the labels and annotations of the local cluster, when it
+	// is not defined by a cluster secret
+	localClusterLabels      map[string]string
+	localClusterAnnotations map[string]string
+	// getServerVersion returns the Kubernetes version of a cluster
+	getServerVersion func(ctx context.Context, cluster *argoappv1.Cluster) (string, error)
+	// serverVersions caches the serverVersionResult of the clusters, by server URL
+	serverVersions *gocache.Cache
+}
+
+// serverVersionResult is the Kubernetes version of a cluster, or the error which occurred while requesting it
+type serverVersionResult struct {
+	version string
+	err     error
 }
 
-func NewClusterGenerator(c client.Client, ctx context.Context, clientset kubernetes.Interface, namespace string) Generator {
+func NewClusterGenerator(c client.Client, ctx context.Context, clientset kubernetes.Interface, namespace string, localClusterLabels map[string]string, localClusterAnnotations map[string]string) Generator {
 
 	settingsManager := settings.NewSettingsManager(ctx, clientset, namespace)
 
 	g := &ClusterGenerator{
-		Client:          c,
-		ctx:             ctx,
-		clientset:       clientset,
-		namespace:       namespace,
-		settingsManager: settingsManager,
+		Client:                  c,
+		clientset:               clientset,
+		namespace:               namespace,
+		settingsManager:         settingsManager,
+		localClusterLabels:      localClusterLabels,
+		localClusterAnnotations: localClusterAnnotations,
+		getServerVersion:        getServerVersion,
+		serverVersions:          gocache.New(clusterInfoCacheTTL, 2*clusterInfoCacheTTL),
 	}
 	return g
 }
@@ -58,7 +91,7 @@ func (g *ClusterGenerator) GetTemplate(appSetGenerator *argoprojiov1alpha1.Appli
 	return &appSetGenerator.Clusters.Template
 }
 
-func (g *ClusterGenerator) GenerateParams(
+func (g *ClusterGenerator) GenerateParams(ctx context.Context,
 	appSetGenerator *argoprojiov1alpha1.ApplicationSetGenerator, _ *argoprojiov1alpha1.ApplicationSet) ([]map[string]string, error) {
 
 	if appSetGenerator == nil {
@@ -69,12 +102,19 @@ func (g *ClusterGenerator) GenerateParams(
 		return nil, EmptyAppSetGeneratorError
 	}
 
-	// Do not include the local cluster in the cluster parameters IF there is a non-empty selector
-	// - Since local clusters do not have secrets, they do not have labels to match against
-	ignoreLocalClusters := len(appSetGenerator.Clusters.Selector.MatchExpressions) > 0 || len(appSetGenerator.Clusters.Selector.MatchLabels) > 0
+	// Local clusters do not have secrets, so the selector is matched against the configured local cluster labels.
+	// Without any, the local cluster is only included if there is no selector.
+	includeLocalCluster := len(appSetGenerator.Clusters.Selector.MatchExpressions) == 0 && len(appSetGenerator.Clusters.Selector.MatchLabels) == 0
+	if !includeLocalCluster && len(g.localClusterLabels) > 0 {
+		localClusterSelector, err := metav1.LabelSelectorAsSelector(&appSetGenerator.Clusters.Selector)
+		if err != nil {
+			return nil, err
+		}
+		includeLocalCluster = localClusterSelector.Matches(labels.Set(g.localClusterLabels))
+	}
 
 	// ListCluster from Argo CD's util/db package will include the local cluster in the list of clusters
-	clustersFromArgoCD, err := utils.ListClusters(g.ctx, g.clientset, g.namespace)
+	clustersFromArgoCD, err := utils.ListClusters(ctx, g.clientset, g.namespace)
 	if err != nil {
 		return nil, err
 	}
@@ -83,7 +123,7 @@ func (g *ClusterGenerator) GenerateParams(
 		return nil, nil
 	}
 
-	clusterSecrets, err := g.getSecretsByClusterName(appSetGenerator)
+	clusterSecrets, err := g.getSecretsByClusterName(ctx, appSetGenerator)
 	if err != nil {
 		return nil, err
 	}
@@ -91,6 +131,7 @@ func (g *ClusterGenerator) GenerateParams(
 	res := []map[string]string{}
 
 	secretsFound := []corev1.Secret{}
+	clustersFound := []argoappv1.Cluster{}
 
 	for _, cluster := range clustersFromArgoCD.Items {
 
@@ -98,16 +139,24 @@ func (g *ClusterGenerator) GenerateParams(
 		// handled by the next step.
 		if secretForCluster, exists := clusterSecrets[cluster.Name]; exists {
 			secretsFound = append(secretsFound, secretForCluster)
+			clustersFound = append(clustersFound, cluster)
 
-		} else if !ignoreLocalClusters {
+		} else if isLocalCluster(&cluster) && includeLocalCluster {
 			// If there is no secret for the cluster, it's the local cluster, so handle it here.
 			params := map[string]string{}
 			params["name"] = cluster.Name
 			params["server"] = cluster.Server
 
+			for key, value := range g.localClusterAnnotations {
+				params[fmt.Sprintf("metadata.annotations.%s", key)] = value
+			}
+			for key, value := range g.localClusterLabels {
+				params[fmt.Sprintf("metadata.labels.%s", key)] = value
+			}
 			for key, value := range appSetGenerator.Clusters.Values {
 				params[fmt.Sprintf("values.%s", key)] = value
 			}
+			addAdditionalClusterParams(params, appSetGenerator.Clusters.AdditionalParams, &cluster, cluster.ServerVersion, cluster.ConnectionState.Status)
 
 			log.WithField("cluster", "local cluster").Info("matched local cluster")
 
@@ -116,7 +165,7 @@ func (g *ClusterGenerator) GenerateParams(
 	}
 
 	// For each matching cluster secret (non-local clusters only)
-	for _, cluster := range secretsFound {
+	for i, cluster := range secretsFound {
 		params := map[string]string{}
 		params["name"] = string(cluster.Data["name"])
 		params["nameNormalized"] = sanitizeName(string(cluster.Data["name"]))
@@ -130,6 +179,13 @@ func (g *ClusterGenerator) GenerateParams(
 		for key, value := range appSetGenerator.Clusters.Values {
 			params[fmt.Sprintf("values.%s", key)] = value
 		}
+
+		var serverVersion, connectionStatus string
+		if containsClusterParam(appSetGenerator.Clusters.AdditionalParams, argoprojiov1alpha1.ClusterGeneratorParamInfo) {
+			serverVersion, connectionStatus = g.getClusterInfo(ctx, &clustersFound[i])
+		}
+		addAdditionalClusterParams(params, appSetGenerator.Clusters.AdditionalParams, &clustersFound[i], serverVersion, connectionStatus)
+
 		log.WithField("cluster", cluster.Name).Info("matched cluster secret")
 
 		res = append(res, params)
@@ -138,7 +194,97 @@ func (g *ClusterGenerator) GenerateParams(
 	return res, nil
 }
 
-func (g *ClusterGenerator) getSecretsByClusterName(appSetGenerator *argoprojiov1alpha1.ApplicationSetGenerator) (map[string]corev1.Secret, error) {
+// isLocalCluster returns true if the cluster is the local cluster added by ListClusters, rather than a cluster
+// defined by a secret (which may not have matched the selector).
+func isLocalCluster(cluster *argoappv1.Cluster) bool {
+	return cluster.ID == "" && cluster.Server == argoappv1.KubernetesInternalAPIServerAddr
+}
+
+// getClusterInfo connects to the cluster to determine its Kubernetes version, returning the version and the
+// connection status. The results are cached, so that clusters are not queried by every reconciliation.
+func (g *ClusterGenerator) getClusterInfo(ctx context.Context, cluster *argoappv1.Cluster) (string, string) {
+	var result serverVersionResult
+	if cached, found := g.serverVersions.Get(cluster.Server); found {
+		result = cached.(serverVersionResult)
+	} else {
+		result.version, result.err = g.getServerVersion(ctx, cluster)
+		// A request cancelled with the reconciliation says nothing about the cluster
+		if ctx.Err() == nil {
+			ttl := clusterInfoCacheTTL
+			if result.err != nil {
+				ttl = clusterInfoFailureCacheTTL
+			}
+			g.serverVersions.Set(cluster.Server, result, ttl)
+		}
+	}
+
+	if result.err != nil {
+		log.WithError(result.err).WithField("cluster", cluster.Name).Warn("unable to determine cluster server version")
+		return "", argoappv1.ConnectionStatusFailed
+	}
+	return result.version, argoappv1.ConnectionStatusSuccessful
+}
+
+// addAdditionalClusterParams adds the requested optional parameters of the cluster to params.
+func addAdditionalClusterParams(params map[string]string, additionalParams []argoprojiov1alpha1.ClusterGeneratorParam, cluster *argoappv1.Cluster, serverVersion string, connectionStatus string) {
+	for _, param := range additionalParams {
+		switch param {
+		case argoprojiov1alpha1.ClusterGeneratorParamProject:
+			params["project"] = cluster.Project
+		case argoprojiov1alpha1.ClusterGeneratorParamNamespaces:
+			params["namespaces"] = strings.Join(cluster.Namespaces, ",")
+		case argoprojiov1alpha1.ClusterGeneratorParamClusterResources:
+			params["clusterResources"] = strconv.FormatBool(cluster.ClusterResources)
+		case argoprojiov1alpha1.ClusterGeneratorParamShard:
+			params["shard"] = ""
+			if cluster.Shard != nil {
+				params["shard"] = strconv.FormatInt(*cluster.Shard, 10)
+			}
+		case argoprojiov1alpha1.ClusterGeneratorParamInfo:
+			params["info.serverVersion"] = serverVersion
+			params["info.connectionState.status"] = connectionStatus
+		}
+	}
+}
+
+func containsClusterParam(params []argoprojiov1alpha1.ClusterGeneratorParam, param argoprojiov1alpha1.ClusterGeneratorParam) bool {
+	for _, p := range params {
+		if p == param {
+			return true
+		}
+	}
+	return false
+}
+
+// getServerVersion returns the Kubernetes version of the cluster, in the same 'major.minor' form as Argo CD. The
+// request is bounded by clusterInfoTimeout, and cancelled with ctx.
+func getServerVersion(ctx context.Context, cluster *argoappv1.Cluster) (serverVersion string, err error) {
+	// RESTConfig panics rather than returning an error
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("unable to create REST config for cluster '%s': %v", cluster.Name, r)
+		}
+	}()
+
+	config := cluster.RESTConfig()
+	config.Timeout = clusterInfoTimeout
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return "", err
+	}
+	// Same request as discoveryClient.ServerVersion(), which doesn't take a context
+	body, err := discoveryClient.RESTClient().Get().AbsPath("/version").Do(ctx).Raw()
+	if err != nil {
+		return "", err
+	}
+	var info version.Info
+	if err := json.Unmarshal(body, &info); err != nil {
+		return "", fmt.Errorf("unable to parse the server version of cluster '%s': %v", cluster.Name, err)
+	}
+	return fmt.Sprintf("%s.%s", info.Major, info.Minor), nil
+}
+
+func (g *ClusterGenerator) getSecretsByClusterName(ctx context.Context, appSetGenerator *argoprojiov1alpha1.ApplicationSetGenerator) (map[string]corev1.Secret, error) {
 	// List all Clusters:
 	clusterSecretList := &corev1.SecretList{}
 
@@ -148,7 +294,7 @@ func (g *ClusterGenerator) getSecretsByClusterName(appSetGenerator *argoprojiov1
 		return nil, err
 	}
 
-	if err := g.Client.List(context.Background(), clusterSecretList, client.MatchingLabelsSelector{Selector: secretSelector}); err != nil {
+	if err := g.Client.List(ctx, clusterSecretList, client.MatchingLabelsSelector{Selector: secretSelector}, client.InNamespace(g.namespace)); err != nil {
 		return nil, err
 	}
 	log.Debug("clusters matching labels", "count", len(clusterSecretList.Items))
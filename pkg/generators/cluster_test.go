@@ -3,6 +3,8 @@ package generators
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -11,8 +13,10 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 
 	"testing"
+	"time"
 
 	argoprojiov1alpha1 "github.com/argoproj/applicationset/api/v1alpha1"
+	argoappv1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
 	kubefake "k8s.io/client-go/kubernetes/fake"
 
 	"github.com/stretchr/testify/assert"
@@ -222,9 +226,9 @@ func TestGenerateParams(t *testing.T) {
 				testCase.clientError,
 			}
 
-			var clusterGenerator = NewClusterGenerator(cl, context.Background(), appClientset, "namespace")
+			var clusterGenerator = NewClusterGenerator(cl, context.Background(), appClientset, "namespace", nil, nil)
 
-			got, err := clusterGenerator.GenerateParams(&argoprojiov1alpha1.ApplicationSetGenerator{
+			got, err := clusterGenerator.GenerateParams(context.Background(), &argoprojiov1alpha1.ApplicationSetGenerator{
 				Clusters: &argoprojiov1alpha1.ClusterGenerator{
 					Selector: testCase.selector,
 					Values:   testCase.values,
@@ -242,6 +246,257 @@ func TestGenerateParams(t *testing.T) {
 	}
 }
 
+func TestGenerateParamsWithAdditionalParams(t *testing.T) {
+	clusters := []client.Object{
+		&corev1.Secret{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "Secret",
+				APIVersion: "v1",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "staging-01",
+				Namespace: "namespace",
+				Labels: map[string]string{
+					"argocd.argoproj.io/secret-type": "cluster",
+				},
+			},
+			Data: map[string][]byte{
+				"config":           []byte("{}"),
+				"name":             []byte("staging-01"),
+				"server":           []byte("https://staging-01.example.com"),
+				"project":          []byte("staging"),
+				"namespaces":       []byte("team-a,team-b"),
+				"clusterResources": []byte("true"),
+				"shard":            []byte("2"),
+			},
+			Type: corev1.SecretType("Opaque"),
+		},
+	}
+	testCases := []struct {
+		name             string
+		additionalParams []argoprojiov1alpha1.ClusterGeneratorParam
+		serverVersionErr error
+		expected         map[string]string
+	}{
+		{
+			name:             "no additional params",
+			additionalParams: nil,
+			expected:         map[string]string{},
+		},
+		{
+			name: "cluster secret params",
+			additionalParams: []argoprojiov1alpha1.ClusterGeneratorParam{
+				argoprojiov1alpha1.ClusterGeneratorParamProject,
+				argoprojiov1alpha1.ClusterGeneratorParamNamespaces,
+				argoprojiov1alpha1.ClusterGeneratorParamClusterResources,
+				argoprojiov1alpha1.ClusterGeneratorParamShard,
+			},
+			expected: map[string]string{
+				"project":          "staging",
+				"namespaces":       "team-a,team-b",
+				"clusterResources": "true",
+				"shard":            "2",
+			},
+		},
+		{
+			name:             "cluster info",
+			additionalParams: []argoprojiov1alpha1.ClusterGeneratorParam{argoprojiov1alpha1.ClusterGeneratorParamInfo},
+			expected: map[string]string{
+				"info.serverVersion":          "1.23",
+				"info.connectionState.status": "Successful",
+			},
+		},
+		{
+			name:             "cluster info of unreachable cluster",
+			additionalParams: []argoprojiov1alpha1.ClusterGeneratorParam{argoprojiov1alpha1.ClusterGeneratorParamInfo},
+			serverVersionErr: fmt.Errorf("connection refused"),
+			expected: map[string]string{
+				"info.serverVersion":          "",
+				"info.connectionState.status": "Failed",
+			},
+		},
+	}
+
+	// convert []client.Object to []runtime.Object, for use by kubefake package
+	runtimeClusters := []runtime.Object{}
+	for _, clientCluster := range clusters {
+		runtimeClusters = append(runtimeClusters, clientCluster)
+	}
+
+	for _, testCase := range testCases {
+
+		t.Run(testCase.name, func(t *testing.T) {
+
+			appClientset := kubefake.NewSimpleClientset(runtimeClusters...)
+			fakeClient := fake.NewClientBuilder().WithObjects(clusters...).Build()
+
+			clusterGenerator := NewClusterGenerator(fakeClient, context.Background(), appClientset, "namespace", nil, nil).(*ClusterGenerator)
+			clusterGenerator.getServerVersion = func(_ context.Context, cluster *argoappv1.Cluster) (string, error) {
+				assert.Equal(t, "https://staging-01.example.com", cluster.Server)
+				if testCase.serverVersionErr != nil {
+					return "", testCase.serverVersionErr
+				}
+				return "1.23", nil
+			}
+
+			got, err := clusterGenerator.GenerateParams(context.Background(), &argoprojiov1alpha1.ApplicationSetGenerator{
+				Clusters: &argoprojiov1alpha1.ClusterGenerator{
+					Selector: metav1.LabelSelector{
+						MatchLabels: map[string]string{
+							"argocd.argoproj.io/secret-type": "cluster",
+						},
+					},
+					AdditionalParams: testCase.additionalParams,
+				},
+			}, nil)
+
+			assert.NoError(t, err)
+			expected := map[string]string{
+				"name":           "staging-01",
+				"nameNormalized": "staging-01",
+				"server":         "https://staging-01.example.com",
+				"metadata.labels.argocd.argoproj.io/secret-type": "cluster",
+			}
+			for key, value := range testCase.expected {
+				expected[key] = value
+			}
+			assert.Equal(t, []map[string]string{expected}, got)
+		})
+	}
+}
+
+func TestGetClusterInfoIsCached(t *testing.T) {
+	clusterGenerator := NewClusterGenerator(fake.NewClientBuilder().Build(), context.Background(), kubefake.NewSimpleClientset(), "namespace", nil, nil).(*ClusterGenerator)
+	calls := map[string]int{}
+	clusterGenerator.getServerVersion = func(_ context.Context, cluster *argoappv1.Cluster) (string, error) {
+		calls[cluster.Server]++
+		if cluster.Server == "https://unreachable.example.com" {
+			return "", fmt.Errorf("connection refused")
+		}
+		return "1.23", nil
+	}
+
+	for i := 0; i < 2; i++ {
+		serverVersion, status := clusterGenerator.getClusterInfo(context.Background(), &argoappv1.Cluster{Server: "https://staging-01.example.com"})
+		assert.Equal(t, "1.23", serverVersion)
+		assert.Equal(t, argoappv1.ConnectionStatusSuccessful, status)
+		serverVersion, status = clusterGenerator.getClusterInfo(context.Background(), &argoappv1.Cluster{Server: "https://unreachable.example.com"})
+		assert.Equal(t, "", serverVersion)
+		assert.Equal(t, argoappv1.ConnectionStatusFailed, status)
+	}
+	assert.Equal(t, map[string]int{"https://staging-01.example.com": 1, "https://unreachable.example.com": 1}, calls)
+
+	// The results of cancelled requests are not cached
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	clusterGenerator.getServerVersion = func(ctx context.Context, cluster *argoappv1.Cluster) (string, error) {
+		calls[cluster.Server]++
+		return "", ctx.Err()
+	}
+	for i := 0; i < 2; i++ {
+		_, status := clusterGenerator.getClusterInfo(ctx, &argoappv1.Cluster{Server: "https://other.example.com"})
+		assert.Equal(t, argoappv1.ConnectionStatusFailed, status)
+	}
+	assert.Equal(t, 2, calls["https://other.example.com"])
+}
+
+func TestGetServerVersion(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/version" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"major": "1", "minor": "23", "gitVersion": "v1.23.1"}`))
+	}))
+	defer ts.Close()
+	cluster := &argoappv1.Cluster{Name: "test", Server: ts.URL, Config: argoappv1.ClusterConfig{TLSClientConfig: argoappv1.TLSClientConfig{Insecure: true}}}
+
+	serverVersion, err := getServerVersion(context.Background(), cluster)
+	assert.NoError(t, err)
+	assert.Equal(t, "1.23", serverVersion)
+
+	// Requests to an unresponsive cluster are cancelled with the context
+	hangingServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer hangingServer.Close()
+	hangingCluster := cluster.DeepCopy()
+	hangingCluster.Server = hangingServer.URL
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = getServerVersion(ctx, hangingCluster)
+	assert.Error(t, err)
+}
+
+func TestGenerateParamsWithLocalClusterLabels(t *testing.T) {
+	testCases := []struct {
+		name               string
+		selector           metav1.LabelSelector
+		localClusterLabels map[string]string
+		expected           []map[string]string
+	}{
+		{
+			name:     "no selector",
+			selector: metav1.LabelSelector{},
+			expected: []map[string]string{
+				{"name": "in-cluster", "server": "https://kubernetes.default.svc"},
+			},
+		},
+		{
+			name: "selector without local cluster labels",
+			selector: metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "environment", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"staging"}},
+				},
+			},
+			expected: []map[string]string{},
+		},
+		{
+			name: "selector matching local cluster labels",
+			selector: metav1.LabelSelector{
+				MatchLabels: map[string]string{"environment": "production"},
+			},
+			localClusterLabels: map[string]string{"environment": "production"},
+			expected: []map[string]string{
+				{"name": "in-cluster", "server": "https://kubernetes.default.svc", "metadata.labels.environment": "production",
+					"metadata.annotations.owner": "platform"},
+			},
+		},
+		{
+			name: "selector not matching local cluster labels",
+			selector: metav1.LabelSelector{
+				MatchLabels: map[string]string{"environment": "staging"},
+			},
+			localClusterLabels: map[string]string{"environment": "production"},
+			expected:           []map[string]string{},
+		},
+	}
+
+	for _, testCase := range testCases {
+
+		t.Run(testCase.name, func(t *testing.T) {
+
+			appClientset := kubefake.NewSimpleClientset()
+			fakeClient := fake.NewClientBuilder().Build()
+
+			var localClusterAnnotations map[string]string
+			if testCase.localClusterLabels != nil {
+				localClusterAnnotations = map[string]string{"owner": "platform"}
+			}
+			clusterGenerator := NewClusterGenerator(fakeClient, context.Background(), appClientset, "namespace", testCase.localClusterLabels, localClusterAnnotations)
+
+			got, err := clusterGenerator.GenerateParams(context.Background(), &argoprojiov1alpha1.ApplicationSetGenerator{
+				Clusters: &argoprojiov1alpha1.ClusterGenerator{
+					Selector: testCase.selector,
+				},
+			}, nil)
+
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, got)
+		})
+	}
+}
+
 func TestSanitizeClusterName(t *testing.T) {
 	t.Run("valid DNS-1123 subdomain name", func(t *testing.T) {
 		assert.Equal(t, "cluster-name", sanitizeName("cluster-name"))
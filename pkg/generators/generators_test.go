@@ -1,6 +1,7 @@
 package generators
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"testing"
@@ -50,7 +51,7 @@ func TestNoGeneratorNilReferenceError(t *testing.T) {
 		t.Run(fmt.Sprintf("%s does not throw a nil reference error when all generator fields are nil", generatorName), func(t *testing.T) {
 			t.Parallel()
 
-			params, err := generator.GenerateParams(&v1alpha1.ApplicationSetGenerator{}, &v1alpha1.ApplicationSet{})
+			params, err := generator.GenerateParams(context.Background(), &v1alpha1.ApplicationSetGenerator{}, &v1alpha1.ApplicationSet{})
 
 			assert.ErrorIs(t, err, EmptyAppSetGeneratorError)
 			assert.Nil(t, params)
@@ -44,7 +44,7 @@ func (g *GitGenerator) GetRequeueAfter(appSetGenerator *argoprojiov1alpha1.Appli
 	return DefaultRequeueAfterSeconds
 }
 
-func (g *GitGenerator) GenerateParams(appSetGenerator *argoprojiov1alpha1.ApplicationSetGenerator, _ *argoprojiov1alpha1.ApplicationSet) ([]map[string]string, error) {
+func (g *GitGenerator) GenerateParams(ctx context.Context, appSetGenerator *argoprojiov1alpha1.ApplicationSetGenerator, _ *argoprojiov1alpha1.ApplicationSet) ([]map[string]string, error) {
 
 	if appSetGenerator == nil {
 		return nil, EmptyAppSetGeneratorError
@@ -54,14 +54,27 @@ func (g *GitGenerator) GenerateParams(appSetGenerator *argoprojiov1alpha1.Applic
 		return nil, EmptyAppSetGeneratorError
 	}
 
+	if appSetGenerator.Git.Directories == nil && appSetGenerator.Git.Files == nil {
+		return nil, EmptyAppSetGeneratorError
+	}
+
+	// When the commit must be signed, read the verified commit rather than the revision, which may have moved
+	// since it was verified.
+	revision := appSetGenerator.Git.Revision
+	if appSetGenerator.Git.RequiresCommitSignature() {
+		commitSHA, err := g.repos.VerifyCommitSignature(ctx, appSetGenerator.Git.RepoURL, revision, appSetGenerator.Git.SignatureKeys)
+		if err != nil {
+			return nil, err
+		}
+		revision = commitSHA
+	}
+
 	var err error
 	var res []map[string]string
 	if appSetGenerator.Git.Directories != nil {
-		res, err = g.generateParamsForGitDirectories(appSetGenerator)
-	} else if appSetGenerator.Git.Files != nil {
-		res, err = g.generateParamsForGitFiles(appSetGenerator)
+		res, err = g.generateParamsForGitDirectories(ctx, appSetGenerator, revision)
 	} else {
-		return nil, EmptyAppSetGeneratorError
+		res, err = g.generateParamsForGitFiles(ctx, appSetGenerator, revision)
 	}
 	if err != nil {
 		return nil, err
@@ -70,10 +83,10 @@ func (g *GitGenerator) GenerateParams(appSetGenerator *argoprojiov1alpha1.Applic
 	return res, nil
 }
 
-func (g *GitGenerator) generateParamsForGitDirectories(appSetGenerator *argoprojiov1alpha1.ApplicationSetGenerator) ([]map[string]string, error) {
+func (g *GitGenerator) generateParamsForGitDirectories(ctx context.Context, appSetGenerator *argoprojiov1alpha1.ApplicationSetGenerator, revision string) ([]map[string]string, error) {
 
 	// Directories, not files
-	allPaths, err := g.repos.GetDirectories(context.TODO(), appSetGenerator.Git.RepoURL, appSetGenerator.Git.Revision)
+	allPaths, err := g.repos.GetDirectories(ctx, appSetGenerator.Git.RepoURL, revision)
 	if err != nil {
 		return nil, err
 	}
@@ -82,22 +95,57 @@ func (g *GitGenerator) generateParamsForGitDirectories(appSetGenerator *argoproj
 		"allPaths": allPaths,
 		"total":    len(allPaths),
 		"repoURL":  appSetGenerator.Git.RepoURL,
-		"revision": appSetGenerator.Git.Revision,
+		"revision": revision,
 	}).Info("applications result from the repo service")
 
 	requestedApps := g.filterApps(appSetGenerator.Git.Directories, allPaths)
 
-	res := g.generateParamsFromApps(requestedApps, appSetGenerator)
+	valuesFiles, err := g.getValuesFiles(ctx, appSetGenerator, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := g.generateParamsFromApps(requestedApps, appSetGenerator, valuesFiles)
+	if err != nil {
+		return nil, err
+	}
 
 	return res, nil
 }
 
-func (g *GitGenerator) generateParamsForGitFiles(appSetGenerator *argoprojiov1alpha1.ApplicationSetGenerator) ([]map[string]string, error) {
+// getValuesFiles retrieves the contents of the values files of all directory items that declare one, keyed by
+// the path of the file within the repo.
+func (g *GitGenerator) getValuesFiles(ctx context.Context, appSetGenerator *argoprojiov1alpha1.ApplicationSetGenerator, revision string) (map[string][]byte, error) {
+	res := map[string][]byte{}
+	requestedPatterns := map[string]bool{}
+	for _, requestedPath := range appSetGenerator.Git.Directories {
+		if requestedPath.Exclude || requestedPath.ValuesFile == "" {
+			continue
+		}
+
+		pattern := path.Join(requestedPath.Path, requestedPath.ValuesFile)
+		if requestedPatterns[pattern] {
+			continue
+		}
+		requestedPatterns[pattern] = true
+
+		files, err := g.repos.GetFiles(ctx, appSetGenerator.Git.RepoURL, revision, pattern)
+		if err != nil {
+			return nil, err
+		}
+		for filePath, content := range files {
+			res[filePath] = content
+		}
+	}
+	return res, nil
+}
+
+func (g *GitGenerator) generateParamsForGitFiles(ctx context.Context, appSetGenerator *argoprojiov1alpha1.ApplicationSetGenerator, revision string) ([]map[string]string, error) {
 
 	// Get all files that match the requested path string, removing duplicates
 	allFiles := make(map[string][]byte)
 	for _, requestedPath := range appSetGenerator.Git.Files {
-		files, err := g.repos.GetFiles(context.TODO(), appSetGenerator.Git.RepoURL, appSetGenerator.Git.Revision, requestedPath.Path)
+		files, err := g.repos.GetFiles(ctx, appSetGenerator.Git.RepoURL, revision, requestedPath.Path)
 		if err != nil {
 			return nil, err
 		}
@@ -151,14 +199,10 @@ func (g *GitGenerator) generateParamsFromGitFile(filePath string, fileContent []
 	// Flatten all objects found, and return them
 	for _, objectFound := range objectsFound {
 
-		flat, err := flatten.Flatten(objectFound, "", flatten.DotStyle)
+		params, err := flattenParams(objectFound)
 		if err != nil {
 			return nil, err
 		}
-		params := map[string]string{}
-		for k, v := range flat {
-			params[k] = fmt.Sprintf("%v", v)
-		}
 		params["path"] = path.Dir(filePath)
 		params["path.basename"] = path.Base(params["path"])
 		params["path.basenameNormalized"] = sanitizeName(path.Base(params["path"]))
@@ -174,6 +218,19 @@ func (g *GitGenerator) generateParamsFromGitFile(filePath string, fileContent []
 
 }
 
+// flattenParams flattens a parsed JSON/YAML object into a map of dot-separated keys to string values.
+func flattenParams(object map[string]interface{}) (map[string]string, error) {
+	flat, err := flatten.Flatten(object, "", flatten.DotStyle)
+	if err != nil {
+		return nil, err
+	}
+	params := map[string]string{}
+	for k, v := range flat {
+		params[k] = fmt.Sprintf("%v", v)
+	}
+	return params, nil
+}
+
 func (g *GitGenerator) filterApps(Directories []argoprojiov1alpha1.GitDirectoryGeneratorItem, allPaths []string) []string {
 	res := []string{}
 	for _, appPath := range allPaths {
@@ -202,13 +259,23 @@ func (g *GitGenerator) filterApps(Directories []argoprojiov1alpha1.GitDirectoryG
 	return res
 }
 
-func (g *GitGenerator) generateParamsFromApps(requestedApps []string, _ *argoprojiov1alpha1.ApplicationSetGenerator) []map[string]string {
-	// TODO: At some point, the appicationSetGenerator param should be used
+func (g *GitGenerator) generateParamsFromApps(requestedApps []string, appSetGenerator *argoprojiov1alpha1.ApplicationSetGenerator, valuesFiles map[string][]byte) ([]map[string]string, error) {
 
 	res := make([]map[string]string, len(requestedApps))
 	for i, a := range requestedApps {
 
 		params := make(map[string]string, 2)
+
+		// Values from the directory's values file are added first, so that they can't override the path params
+		valuesFilePath := getValuesFilePath(appSetGenerator.Git.Directories, a)
+		if content, exists := valuesFiles[valuesFilePath]; exists {
+			values, err := generateParamsFromValuesFile(content)
+			if err != nil {
+				return nil, fmt.Errorf("unable to process values file '%s': %v", valuesFilePath, err)
+			}
+			params = values
+		}
+
 		params["path"] = a
 		params["path.basename"] = path.Base(a)
 		params["path.basenameNormalized"] = sanitizeName(path.Base(a))
@@ -220,5 +287,29 @@ func (g *GitGenerator) generateParamsFromApps(requestedApps []string, _ *argopro
 		res[i] = params
 	}
 
-	return res
+	return res, nil
+}
+
+// getValuesFilePath returns the path of the values file of the first include pattern that matches appPath, or an
+// empty string if no matching pattern declares a values file.
+func getValuesFilePath(directories []argoprojiov1alpha1.GitDirectoryGeneratorItem, appPath string) string {
+	for _, requestedPath := range directories {
+		if requestedPath.Exclude || requestedPath.ValuesFile == "" {
+			continue
+		}
+		if match, err := path.Match(requestedPath.Path, appPath); err == nil && match {
+			return path.Join(appPath, requestedPath.ValuesFile)
+		}
+	}
+	return ""
+}
+
+// generateParamsFromValuesFile flattens the contents of a directory values file. Unlike the files generator, a
+// values file must contain a single object, since it is merged into the params of a single directory.
+func generateParamsFromValuesFile(fileContent []byte) (map[string]string, error) {
+	values := make(map[string]interface{})
+	if err := yaml.Unmarshal(fileContent, &values); err != nil {
+		return nil, fmt.Errorf("unable to parse file: %v", err)
+	}
+	return flattenParams(values)
 }
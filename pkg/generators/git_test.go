@@ -46,6 +46,11 @@ func (a argoCDServiceMock) GetDirectories(ctx context.Context, repoURL string, r
 	return args.Get(0).([]string), args.Error(1)
 }
 
+func (a argoCDServiceMock) VerifyCommitSignature(ctx context.Context, repoURL string, revision string, signatureKeys []string) (string, error) {
+	args := a.mock.Called(ctx, repoURL, revision, signatureKeys)
+	return args.String(0), args.Error(1)
+}
+
 func TestGitGenerateParamsFromDirectories(t *testing.T) {
 
 	cases := []struct {
@@ -169,7 +174,7 @@ func TestGitGenerateParamsFromDirectories(t *testing.T) {
 				},
 			}
 
-			got, err := gitGenerator.GenerateParams(&applicationSetInfo.Spec.Generators[0], nil)
+			got, err := gitGenerator.GenerateParams(context.Background(), &applicationSetInfo.Spec.Generators[0], nil)
 
 			if testCaseCopy.expectedError != nil {
 				assert.EqualError(t, err, testCaseCopy.expectedError.Error())
@@ -184,6 +189,123 @@ func TestGitGenerateParamsFromDirectories(t *testing.T) {
 
 }
 
+func TestGitGenerateParamsFromDirectoriesWithValuesFile(t *testing.T) {
+
+	cases := []struct {
+		name        string
+		directories []argoprojiov1alpha1.GitDirectoryGeneratorItem
+		repoApps    []string
+		// repoFileContents maps repo path to the literal contents of that path
+		repoFileContents map[string][]byte
+		expected         []map[string]string
+		expectedError    error
+	}{
+		{
+			name:        "merges values file contents into directory params",
+			directories: []argoprojiov1alpha1.GitDirectoryGeneratorItem{{Path: "apps/*", ValuesFile: "config.yaml"}},
+			repoApps: []string{
+				"apps/app1",
+				"apps/app2",
+			},
+			repoFileContents: map[string][]byte{
+				"apps/app1/config.yaml": []byte(`
+namespace: team-a
+helm:
+  replicas: 3
+`),
+			},
+			expected: []map[string]string{
+				{"path": "apps/app1", "path.basename": "app1", "path[0]": "apps", "path.basenameNormalized": "app1", "namespace": "team-a", "helm.replicas": "3"},
+				{"path": "apps/app2", "path.basename": "app2", "path[0]": "apps", "path.basenameNormalized": "app2"},
+			},
+			expectedError: nil,
+		},
+		{
+			name:        "path params take precedence over values file contents",
+			directories: []argoprojiov1alpha1.GitDirectoryGeneratorItem{{Path: "apps/*", ValuesFile: "config/values.json"}},
+			repoApps: []string{
+				"apps/app1",
+			},
+			repoFileContents: map[string][]byte{
+				"apps/app1/config/values.json": []byte(`{"path": "other", "key": "value"}`),
+			},
+			expected: []map[string]string{
+				{"path": "apps/app1", "path.basename": "app1", "path[0]": "apps", "path.basenameNormalized": "app1", "key": "value"},
+			},
+			expectedError: nil,
+		},
+		{
+			name:        "returns error on values file containing an array",
+			directories: []argoprojiov1alpha1.GitDirectoryGeneratorItem{{Path: "apps/*", ValuesFile: "config.yaml"}},
+			repoApps: []string{
+				"apps/app1",
+			},
+			repoFileContents: map[string][]byte{
+				"apps/app1/config.yaml": []byte(`- key: value`),
+			},
+			expected:      []map[string]string{},
+			expectedError: fmt.Errorf("unable to process values file 'apps/app1/config.yaml': unable to parse file: error unmarshaling JSON: while decoding JSON: json: cannot unmarshal array into Go value of type map[string]interface {}"),
+		},
+	}
+
+	for _, testCase := range cases {
+		testCaseCopy := testCase
+
+		t.Run(testCaseCopy.name, func(t *testing.T) {
+			t.Parallel()
+
+			argoCDServiceMock := argoCDServiceMock{mock: &mock.Mock{}}
+
+			argoCDServiceMock.mock.On("GetDirectories", mock.Anything, mock.Anything, mock.Anything).Return(testCaseCopy.repoApps, nil)
+			argoCDServiceMock.mock.On("GetFiles", mock.Anything, "RepoURL", "Revision", "apps/*/"+testCaseCopy.directories[0].ValuesFile).
+				Return(testCaseCopy.repoFileContents, nil)
+
+			var gitGenerator = NewGitGenerator(argoCDServiceMock)
+			applicationSetInfo := argoprojiov1alpha1.ApplicationSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "set",
+				},
+				Spec: argoprojiov1alpha1.ApplicationSetSpec{
+					Generators: []argoprojiov1alpha1.ApplicationSetGenerator{{
+						Git: &argoprojiov1alpha1.GitGenerator{
+							RepoURL:     "RepoURL",
+							Revision:    "Revision",
+							Directories: testCaseCopy.directories,
+						},
+					}},
+				},
+			}
+
+			got, err := gitGenerator.GenerateParams(context.Background(), &applicationSetInfo.Spec.Generators[0], nil)
+
+			if testCaseCopy.expectedError != nil {
+				assert.EqualError(t, err, testCaseCopy.expectedError.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, testCaseCopy.expected, got)
+			}
+
+			argoCDServiceMock.mock.AssertExpectations(t)
+		})
+	}
+
+}
+
+func TestGetValuesFilePath(t *testing.T) {
+	directories := []argoprojiov1alpha1.GitDirectoryGeneratorItem{
+		{Path: "apps/excluded", Exclude: true, ValuesFile: "excluded.yaml"},
+		{Path: "apps/*"},
+		{Path: "apps/app*", ValuesFile: "first.yaml"},
+		{Path: "apps/*", ValuesFile: "second.yaml"},
+	}
+
+	// Matching entries without a values file, and exclusions, are skipped
+	assert.Equal(t, "apps/app1/first.yaml", getValuesFilePath(directories, "apps/app1"))
+	assert.Equal(t, "apps/excluded/second.yaml", getValuesFilePath(directories, "apps/excluded"))
+	assert.Equal(t, "apps/other/second.yaml", getValuesFilePath(directories, "apps/other"))
+	assert.Equal(t, "", getValuesFilePath(directories, "other/app1"))
+}
+
 func TestGitGenerateParamsFromFiles(t *testing.T) {
 
 	cases := []struct {
@@ -434,7 +556,7 @@ cluster:
 				},
 			}
 
-			got, err := gitGenerator.GenerateParams(&applicationSetInfo.Spec.Generators[0], nil)
+			got, err := gitGenerator.GenerateParams(context.Background(), &applicationSetInfo.Spec.Generators[0], nil)
 			fmt.Println(got, err)
 
 			if testCaseCopy.expectedError != nil {
@@ -449,3 +571,82 @@ cluster:
 	}
 
 }
+
+func TestGitGenerateParamsWithCommitSignature(t *testing.T) {
+
+	cases := []struct {
+		name                  string
+		verifyCommitSignature bool
+		signatureKeys         []string
+		verifyError           error
+		expected              []map[string]string
+		expectedError         error
+	}{
+		{
+			name:                  "reads the verified commit",
+			verifyCommitSignature: true,
+			expected: []map[string]string{
+				{"path": "app1", "path.basename": "app1", "path.basenameNormalized": "app1"},
+			},
+		},
+		{
+			name:          "signature keys imply verification",
+			signatureKeys: []string{"4AEE18F83AFDEB23"},
+			expected: []map[string]string{
+				{"path": "app1", "path.basename": "app1", "path.basenameNormalized": "app1"},
+			},
+		},
+		{
+			name:                  "returns error when verification fails",
+			verifyCommitSignature: true,
+			verifyError:           fmt.Errorf("commit is not signed"),
+			expectedError:         fmt.Errorf("commit is not signed"),
+		},
+	}
+
+	for _, testCase := range cases {
+		testCaseCopy := testCase
+
+		t.Run(testCaseCopy.name, func(t *testing.T) {
+			t.Parallel()
+
+			argoCDServiceMock := argoCDServiceMock{mock: &mock.Mock{}}
+			argoCDServiceMock.mock.On("VerifyCommitSignature", mock.Anything, "RepoURL", "Revision", testCaseCopy.signatureKeys).
+				Return("0123456789abcdef0123456789abcdef01234567", testCaseCopy.verifyError)
+			if testCaseCopy.verifyError == nil {
+				argoCDServiceMock.mock.On("GetDirectories", mock.Anything, "RepoURL", "0123456789abcdef0123456789abcdef01234567").
+					Return([]string{"app1"}, nil)
+			}
+
+			var gitGenerator = NewGitGenerator(argoCDServiceMock)
+			applicationSetInfo := argoprojiov1alpha1.ApplicationSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "set",
+				},
+				Spec: argoprojiov1alpha1.ApplicationSetSpec{
+					Generators: []argoprojiov1alpha1.ApplicationSetGenerator{{
+						Git: &argoprojiov1alpha1.GitGenerator{
+							RepoURL:               "RepoURL",
+							Revision:              "Revision",
+							Directories:           []argoprojiov1alpha1.GitDirectoryGeneratorItem{{Path: "*"}},
+							VerifyCommitSignature: testCaseCopy.verifyCommitSignature,
+							SignatureKeys:         testCaseCopy.signatureKeys,
+						},
+					}},
+				},
+			}
+
+			got, err := gitGenerator.GenerateParams(context.Background(), &applicationSetInfo.Spec.Generators[0], nil)
+
+			if testCaseCopy.expectedError != nil {
+				assert.EqualError(t, err, testCaseCopy.expectedError.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, testCaseCopy.expected, got)
+			}
+
+			argoCDServiceMock.mock.AssertExpectations(t)
+		})
+	}
+
+}
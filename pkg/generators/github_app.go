@@ -0,0 +1,57 @@
+package generators
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/argoproj/applicationset/pkg/services/github_app_auth"
+)
+
+// getGithubAppAuth reads the credentials of a GitHub App from a Secret of the namespace of the ApplicationSet. As
+// with the other Secrets read by generators, it must be labelled with common.LabelApplicationSetSecret, unless it is
+// in the Argo CD namespace: the repository credential templates of Argo CD may thus be named as they are by the
+// ApplicationSets of the Argo CD namespace.
+func getGithubAppAuth(ctx context.Context, c client.Client, secretName, namespace, argoCDNamespace string) (github_app_auth.Authentication, error) {
+	secret := &corev1.Secret{}
+	err := c.Get(ctx, client.ObjectKey{Name: secretName, Namespace: namespace}, secret)
+	if err != nil {
+		return github_app_auth.Authentication{}, fmt.Errorf("error fetching secret %s/%s: %v", namespace, secretName, err)
+	}
+	if err := checkSecretAllowed(secret, argoCDNamespace); err != nil {
+		return github_app_auth.Authentication{}, err
+	}
+
+	parseID := func(key string) (int64, error) {
+		value, ok := secret.Data[key]
+		if !ok {
+			return 0, fmt.Errorf("key %q in secret %s/%s not found", key, namespace, secretName)
+		}
+		id, err := strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("key %q in secret %s/%s is not a valid ID: %v", key, namespace, secretName, err)
+		}
+		return id, nil
+	}
+	appID, err := parseID(github_app_auth.SecretKeyAppID)
+	if err != nil {
+		return github_app_auth.Authentication{}, err
+	}
+	installationID, err := parseID(github_app_auth.SecretKeyAppInstallationID)
+	if err != nil {
+		return github_app_auth.Authentication{}, err
+	}
+	privateKey, ok := secret.Data[github_app_auth.SecretKeyAppPrivateKey]
+	if !ok {
+		return github_app_auth.Authentication{}, fmt.Errorf("key %q in secret %s/%s not found", github_app_auth.SecretKeyAppPrivateKey, namespace, secretName)
+	}
+	return github_app_auth.Authentication{
+		ID:                appID,
+		InstallationID:    installationID,
+		PrivateKey:        string(privateKey),
+		EnterpriseBaseURL: string(secret.Data[github_app_auth.SecretKeyAppEnterpriseBaseURL]),
+	}, nil
+}
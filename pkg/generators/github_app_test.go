@@ -0,0 +1,83 @@
+package generators
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/argoproj/applicationset/common"
+	"github.com/argoproj/applicationset/pkg/services/github_app_auth"
+)
+
+func TestGetGithubAppAuth(t *testing.T) {
+	newSecret := func(name string, labelled bool, data map[string]string) *corev1.Secret {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test"},
+			Data:       map[string][]byte{},
+		}
+		if labelled {
+			secret.Labels = map[string]string{common.LabelApplicationSetSecret: "true"}
+		}
+		for k, v := range data {
+			secret.Data[k] = []byte(v)
+		}
+		return secret
+	}
+	c := fake.NewClientBuilder().WithObjects(
+		newSecret("app", true, map[string]string{
+			"githubAppID":                "1",
+			"githubAppInstallationID":    "2",
+			"githubAppPrivateKey":        "key",
+			"githubAppEnterpriseBaseUrl": "https://git.example.com/api/v3",
+		}),
+		newSecret("not-allowed", false, map[string]string{
+			"githubAppID":             "1",
+			"githubAppInstallationID": "2",
+			"githubAppPrivateKey":     "key",
+		}),
+		newSecret("invalid-id", true, map[string]string{
+			"githubAppID":             "app",
+			"githubAppInstallationID": "2",
+			"githubAppPrivateKey":     "key",
+		}),
+		newSecret("no-private-key", true, map[string]string{
+			"githubAppID":             "1",
+			"githubAppInstallationID": "2",
+		}),
+	).Build()
+	ctx := context.Background()
+
+	auth, err := getGithubAppAuth(ctx, c, "app", "test", "argocd")
+	assert.NoError(t, err)
+	assert.Equal(t, github_app_auth.Authentication{ID: 1, InstallationID: 2, PrivateKey: "key", EnterpriseBaseURL: "https://git.example.com/api/v3"}, auth)
+
+	_, err = getGithubAppAuth(ctx, c, "app", "other", "argocd")
+	assert.Error(t, err, "wrong namespace")
+	_, err = getGithubAppAuth(ctx, c, "not-allowed", "test", "argocd")
+	assert.True(t, errors.Is(err, ErrSecretNotAllowed))
+	// Secrets of the Argo CD namespace don't need the label
+	_, err = getGithubAppAuth(ctx, c, "not-allowed", "test", "test")
+	assert.NoError(t, err)
+	// Repository credentials of Argo CD are reused as they are by the ApplicationSets of the Argo CD namespace
+	repoCreds := newSecret("repo-creds", false, map[string]string{
+		"githubAppID":             "1",
+		"githubAppInstallationID": "2",
+		"githubAppPrivateKey":     "key",
+	})
+	repoCreds.Labels = map[string]string{"argocd.argoproj.io/secret-type": "repo-creds"}
+	assert.NoError(t, c.Create(ctx, repoCreds))
+	auth, err = getGithubAppAuth(ctx, c, "repo-creds", "test", "test")
+	assert.NoError(t, err)
+	assert.Equal(t, github_app_auth.Authentication{ID: 1, InstallationID: 2, PrivateKey: "key"}, auth)
+	_, err = getGithubAppAuth(ctx, c, "repo-creds", "test", "argocd")
+	assert.True(t, errors.Is(err, ErrSecretNotAllowed), "outside the Argo CD namespace, Secrets must be labelled")
+	_, err = getGithubAppAuth(ctx, c, "invalid-id", "test", "argocd")
+	assert.EqualError(t, err, `key "githubAppID" in secret test/invalid-id is not a valid ID: strconv.ParseInt: parsing "app": invalid syntax`)
+	_, err = getGithubAppAuth(ctx, c, "no-private-key", "test", "argocd")
+	assert.EqualError(t, err, `key "githubAppPrivateKey" in secret test/no-private-key not found`)
+}
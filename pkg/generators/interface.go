@@ -1,10 +1,14 @@
 package generators
 
 import (
+	"context"
 	"fmt"
 	"time"
 
+	corev1 "k8s.io/api/core/v1"
+
 	argoprojiov1alpha1 "github.com/argoproj/applicationset/api/v1alpha1"
+	"github.com/argoproj/applicationset/common"
 )
 
 // Generator defines the interface implemented by all ApplicationSet generators.
@@ -12,7 +16,9 @@ type Generator interface {
 	// GenerateParams interprets the ApplicationSet and generates all relevant parameters for the application template.
 	// The expected / desired list of parameters is returned, it then will be render and reconciled
 	// against the current state of the Applications in the cluster.
-	GenerateParams(appSetGenerator *argoprojiov1alpha1.ApplicationSetGenerator, applicationSetInfo *argoprojiov1alpha1.ApplicationSet) ([]map[string]string, error)
+	// The context is cancelled when the generation of the parameters should stop, for instance when the
+	// reconciliation times out.
+	GenerateParams(ctx context.Context, appSetGenerator *argoprojiov1alpha1.ApplicationSetGenerator, applicationSetInfo *argoprojiov1alpha1.ApplicationSet) ([]map[string]string, error)
 
 	// GetRequeueAfter is the the generator can controller the next reconciled loop
 	// In case there is more then one generator the time will be the minimum of the times.
@@ -24,6 +30,23 @@ type Generator interface {
 }
 
 var EmptyAppSetGeneratorError = fmt.Errorf("ApplicationSet is empty")
+
+// ErrSecretNotAllowed is returned when a generator references a Secret which is not labelled with
+// common.LabelApplicationSetSecret, and which thus may not be read by ApplicationSets.
+var ErrSecretNotAllowed = fmt.Errorf("secret may not be read by ApplicationSets")
+
+// checkSecretAllowed returns ErrSecretNotAllowed if the Secret may not be read by ApplicationSets. Only the Secrets
+// labelled with common.LabelApplicationSetSecret may be read in other namespaces than the Argo CD namespace, so that
+// the ApplicationSets of a namespace can't read all of its Secrets. The Secrets of the Argo CD namespace (where only
+// the Argo CD administrators manage ApplicationSets) may all be read, or, if argoCDNamespace is empty, none of them
+// without the label.
+func checkSecretAllowed(secret *corev1.Secret, argoCDNamespace string) error {
+	if secret.Labels[common.LabelApplicationSetSecret] == "true" || (argoCDNamespace != "" && secret.Namespace == argoCDNamespace) {
+		return nil
+	}
+	return fmt.Errorf("%w: secret %s/%s must be labelled with %s=true", ErrSecretNotAllowed, secret.Namespace, secret.Name, common.LabelApplicationSetSecret)
+}
+
 var NoRequeueAfter time.Duration
 
 // DefaultRequeueAfterSeconds is used when GetRequeueAfter is not specified, it is the default time to wait before the next reconcile loop
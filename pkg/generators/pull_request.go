@@ -3,6 +3,7 @@ package generators
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"strconv"
 	"time"
 
@@ -10,6 +11,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	argoprojiov1alpha1 "github.com/argoproj/applicationset/api/v1alpha1"
+	"github.com/argoproj/applicationset/pkg/services/github_app_auth"
 	pullrequest "github.com/argoproj/applicationset/pkg/services/pull_request"
 )
 
@@ -20,13 +22,19 @@ const (
 )
 
 type PullRequestGenerator struct {
-	client                    client.Client
+	client client.Client
+	// argoCDNamespace is the Argo CD namespace, whose Secrets may be read without the ApplicationSet secret label
+	argoCDNamespace string
+	// githubAppTransports reuse the installation tokens of GitHub Apps
+	githubAppTransports       *github_app_auth.Transports
 	selectServiceProviderFunc func(context.Context, *argoprojiov1alpha1.PullRequestGenerator, *argoprojiov1alpha1.ApplicationSet) (pullrequest.PullRequestService, error)
 }
 
-func NewPullRequestGenerator(client client.Client) Generator {
+func NewPullRequestGenerator(client client.Client, argoCDNamespace string) Generator {
 	g := &PullRequestGenerator{
-		client: client,
+		client:              client,
+		argoCDNamespace:     argoCDNamespace,
+		githubAppTransports: github_app_auth.NewTransports(http.DefaultTransport),
 	}
 	g.selectServiceProviderFunc = g.selectServiceProvider
 	return g
@@ -46,7 +54,7 @@ func (g *PullRequestGenerator) GetTemplate(appSetGenerator *argoprojiov1alpha1.A
 	return &appSetGenerator.PullRequest.Template
 }
 
-func (g *PullRequestGenerator) GenerateParams(appSetGenerator *argoprojiov1alpha1.ApplicationSetGenerator, applicationSetInfo *argoprojiov1alpha1.ApplicationSet) ([]map[string]string, error) {
+func (g *PullRequestGenerator) GenerateParams(ctx context.Context, appSetGenerator *argoprojiov1alpha1.ApplicationSetGenerator, applicationSetInfo *argoprojiov1alpha1.ApplicationSet) ([]map[string]string, error) {
 	if appSetGenerator == nil {
 		return nil, EmptyAppSetGeneratorError
 	}
@@ -55,7 +63,6 @@ func (g *PullRequestGenerator) GenerateParams(appSetGenerator *argoprojiov1alpha
 		return nil, EmptyAppSetGeneratorError
 	}
 
-	ctx := context.Background()
 	svc, err := g.selectServiceProviderFunc(ctx, appSetGenerator.PullRequest, applicationSetInfo)
 	if err != nil {
 		return nil, fmt.Errorf("failed to select pull request service provider: %v", err)
@@ -80,6 +87,16 @@ func (g *PullRequestGenerator) GenerateParams(appSetGenerator *argoprojiov1alpha
 func (g *PullRequestGenerator) selectServiceProvider(ctx context.Context, generatorConfig *argoprojiov1alpha1.PullRequestGenerator, applicationSetInfo *argoprojiov1alpha1.ApplicationSet) (pullrequest.PullRequestService, error) {
 	if generatorConfig.Github != nil {
 		providerConfig := generatorConfig.Github
+		if providerConfig.AppSecretName != "" {
+			if providerConfig.TokenRef != nil {
+				return nil, fmt.Errorf("Github tokenRef and appSecretName may not both be set")
+			}
+			auth, err := getGithubAppAuth(ctx, g.client, providerConfig.AppSecretName, applicationSetInfo.Namespace, g.argoCDNamespace)
+			if err != nil {
+				return nil, fmt.Errorf("error fetching Github App credentials: %v", err)
+			}
+			return pullrequest.NewGithubAppService(ctx, auth, g.githubAppTransports, providerConfig.API, providerConfig.Owner, providerConfig.Repo, providerConfig.Labels)
+		}
 		token, err := g.getSecretRef(ctx, providerConfig.TokenRef, applicationSetInfo.Namespace)
 		if err != nil {
 			return nil, fmt.Errorf("error fetching Secret token: %v", err)
@@ -106,6 +123,9 @@ func (g *PullRequestGenerator) getSecretRef(ctx context.Context, ref *argoprojio
 	if err != nil {
 		return "", fmt.Errorf("error fetching secret %s/%s: %v", namespace, ref.SecretName, err)
 	}
+	if err := checkSecretAllowed(secret, g.argoCDNamespace); err != nil {
+		return "", err
+	}
 	tokenBytes, ok := secret.Data[ref.Key]
 	if !ok {
 		return "", fmt.Errorf("key %q in secret %s/%s not found", ref.Key, namespace, ref.SecretName)
@@ -11,6 +11,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 
 	argoprojiov1alpha1 "github.com/argoproj/applicationset/api/v1alpha1"
+	"github.com/argoproj/applicationset/common"
 	pullrequest "github.com/argoproj/applicationset/pkg/services/pull_request"
 )
 
@@ -64,7 +65,7 @@ func TestPullRequestGithubGenerateParams(t *testing.T) {
 		generatorConfig := argoprojiov1alpha1.ApplicationSetGenerator{
 			PullRequest: &argoprojiov1alpha1.PullRequestGenerator{},
 		}
-		got, gotErr := gen.GenerateParams(&generatorConfig, nil)
+		got, gotErr := gen.GenerateParams(context.Background(), &generatorConfig, nil)
 		assert.Equal(t, c.expectedErr, gotErr)
 		assert.ElementsMatch(t, c.expected, got)
 	}
@@ -72,16 +73,27 @@ func TestPullRequestGithubGenerateParams(t *testing.T) {
 
 func TestPullRequestGetSecretRef(t *testing.T) {
 	secret := &corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{Name: "test-secret", Namespace: "test"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-secret",
+			Namespace: "test",
+			Labels:    map[string]string{common.LabelApplicationSetSecret: "true"},
+		},
+		Data: map[string][]byte{
+			"my-token": []byte("secret"),
+		},
+	}
+	notAllowedSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "not-allowed-secret", Namespace: "test"},
 		Data: map[string][]byte{
 			"my-token": []byte("secret"),
 		},
 	}
-	gen := &PullRequestGenerator{client: fake.NewClientBuilder().WithObjects(secret).Build()}
+	gen := &PullRequestGenerator{client: fake.NewClientBuilder().WithObjects(secret, notAllowedSecret).Build()}
 	ctx := context.Background()
 
 	cases := []struct {
 		name, namespace, token string
+		argoCDNamespace        string
 		ref                    *argoprojiov1alpha1.SecretRef
 		hasError               bool
 	}{
@@ -120,10 +132,27 @@ func TestPullRequestGetSecretRef(t *testing.T) {
 			token:     "",
 			hasError:  true,
 		},
+		{
+			name:            "secret without the opt-in label",
+			ref:             &argoprojiov1alpha1.SecretRef{SecretName: "not-allowed-secret", Key: "my-token"},
+			namespace:       "test",
+			argoCDNamespace: "argocd",
+			token:           "",
+			hasError:        true,
+		},
+		{
+			name:            "secret without the opt-in label in the Argo CD namespace",
+			ref:             &argoprojiov1alpha1.SecretRef{SecretName: "not-allowed-secret", Key: "my-token"},
+			namespace:       "test",
+			argoCDNamespace: "test",
+			token:           "secret",
+			hasError:        false,
+		},
 	}
 
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
+			gen.argoCDNamespace = c.argoCDNamespace
 			token, err := gen.getSecretRef(ctx, c.ref, c.namespace)
 			if c.hasError {
 				assert.NotNil(t, err)
@@ -3,6 +3,8 @@ package generators
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -10,6 +12,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	argoprojiov1alpha1 "github.com/argoproj/applicationset/api/v1alpha1"
+	"github.com/argoproj/applicationset/pkg/services/github_app_auth"
 	"github.com/argoproj/applicationset/pkg/services/scm_provider"
 )
 
@@ -21,12 +24,25 @@ const (
 
 type SCMProviderGenerator struct {
 	client client.Client
+	// argoCDNamespace is the Argo CD namespace, whose Secrets may be read without the ApplicationSet secret label
+	argoCDNamespace string
+	// cache is shared by the providers of all the ApplicationSets, to spare the rate limits of the SCM APIs
+	cache *scm_provider.Cache
+	// githubAppTransports reuse the installation tokens of GitHub Apps, and send their requests through cache
+	githubAppTransports *github_app_auth.Transports
 	// Testing hooks.
 	overrideProvider scm_provider.SCMProviderService
 }
 
-func NewSCMProviderGenerator(client client.Client) Generator {
-	return &SCMProviderGenerator{client: client}
+// NewSCMProviderGenerator returns an SCMProviderGenerator whose providers use cache, if not nil.
+func NewSCMProviderGenerator(client client.Client, cache *scm_provider.Cache, argoCDNamespace string) Generator {
+	return &SCMProviderGenerator{
+		client:          client,
+		cache:           cache,
+		argoCDNamespace: argoCDNamespace,
+		// The installation token is added on top of the caching transport, as with personal access tokens
+		githubAppTransports: github_app_auth.NewTransports(cache.Transport(http.DefaultTransport)),
+	}
 }
 
 func (g *SCMProviderGenerator) GetRequeueAfter(appSetGenerator *argoprojiov1alpha1.ApplicationSetGenerator) time.Duration {
@@ -43,7 +59,7 @@ func (g *SCMProviderGenerator) GetTemplate(appSetGenerator *argoprojiov1alpha1.A
 	return &appSetGenerator.SCMProvider.Template
 }
 
-func (g *SCMProviderGenerator) GenerateParams(appSetGenerator *argoprojiov1alpha1.ApplicationSetGenerator, applicationSetInfo *argoprojiov1alpha1.ApplicationSet) ([]map[string]string, error) {
+func (g *SCMProviderGenerator) GenerateParams(ctx context.Context, appSetGenerator *argoprojiov1alpha1.ApplicationSetGenerator, applicationSetInfo *argoprojiov1alpha1.ApplicationSet) ([]map[string]string, error) {
 	if appSetGenerator == nil {
 		return nil, EmptyAppSetGeneratorError
 	}
@@ -52,40 +68,60 @@ func (g *SCMProviderGenerator) GenerateParams(appSetGenerator *argoprojiov1alpha
 		return nil, EmptyAppSetGeneratorError
 	}
 
-	ctx := context.Background()
-
 	// Create the SCM provider helper.
 	providerConfig := appSetGenerator.SCMProvider
 	var provider scm_provider.SCMProviderService
 	if g.overrideProvider != nil {
 		provider = g.overrideProvider
+	} else if providerConfig.Github != nil && providerConfig.Github.AppSecretName != "" {
+		if providerConfig.Github.TokenRef != nil {
+			return nil, fmt.Errorf("Github tokenRef and appSecretName may not both be set")
+		}
+		auth, err := getGithubAppAuth(ctx, g.client, providerConfig.Github.AppSecretName, applicationSetInfo.Namespace, g.argoCDNamespace)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching Github App credentials: %v", err)
+		}
+		provider, err = scm_provider.NewGithubAppProvider(ctx, auth, g.githubAppTransports, providerConfig.Github.Organization, providerConfig.Github.API, providerConfig.Github.AllBranches)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing Github service: %v", err)
+		}
+		// Installation tokens expire, so the results are cached by installation instead
+		provider = g.cache.Wrap(provider, applicationSetInfo.RefreshRequired(), "github-app", providerConfig.Github.API,
+			providerConfig.Github.Organization, strconv.FormatBool(providerConfig.Github.AllBranches), auth.EnterpriseBaseURL,
+			strconv.FormatInt(auth.ID, 10), strconv.FormatInt(auth.InstallationID, 10), auth.PrivateKey)
 	} else if providerConfig.Github != nil {
 		token, err := g.getSecretRef(ctx, providerConfig.Github.TokenRef, applicationSetInfo.Namespace)
 		if err != nil {
 			return nil, fmt.Errorf("error fetching Github token: %v", err)
 		}
-		provider, err = scm_provider.NewGithubProvider(ctx, providerConfig.Github.Organization, token, providerConfig.Github.API, providerConfig.Github.AllBranches)
+		provider, err = scm_provider.NewGithubProvider(ctx, providerConfig.Github.Organization, token, providerConfig.Github.API, providerConfig.Github.AllBranches, g.cache)
 		if err != nil {
 			return nil, fmt.Errorf("error initializing Github service: %v", err)
 		}
+		provider = g.cache.Wrap(provider, applicationSetInfo.RefreshRequired(), "github", providerConfig.Github.API,
+			providerConfig.Github.Organization, strconv.FormatBool(providerConfig.Github.AllBranches), token)
 	} else if providerConfig.Gitlab != nil {
 		token, err := g.getSecretRef(ctx, providerConfig.Gitlab.TokenRef, applicationSetInfo.Namespace)
 		if err != nil {
 			return nil, fmt.Errorf("error fetching Gitlab token: %v", err)
 		}
-		provider, err = scm_provider.NewGitlabProvider(ctx, providerConfig.Gitlab.Group, token, providerConfig.Gitlab.API, providerConfig.Gitlab.AllBranches, providerConfig.Gitlab.IncludeSubgroups)
+		provider, err = scm_provider.NewGitlabProvider(ctx, providerConfig.Gitlab.Group, token, providerConfig.Gitlab.API, providerConfig.Gitlab.AllBranches, providerConfig.Gitlab.IncludeSubgroups, g.cache)
 		if err != nil {
 			return nil, fmt.Errorf("error initializing Gitlab service: %v", err)
 		}
+		provider = g.cache.Wrap(provider, applicationSetInfo.RefreshRequired(), "gitlab", providerConfig.Gitlab.API, providerConfig.Gitlab.Group,
+			strconv.FormatBool(providerConfig.Gitlab.AllBranches), strconv.FormatBool(providerConfig.Gitlab.IncludeSubgroups), token)
 	} else if providerConfig.Bitbucket != nil {
 		appPassword, err := g.getSecretRef(ctx, providerConfig.Bitbucket.AppPasswordRef, applicationSetInfo.Namespace)
 		if err != nil {
 			return nil, fmt.Errorf("error fetching Bitbucket cloud appPassword: %v", err)
 		}
-		provider, err = scm_provider.NewBitBucketCloudProvider(ctx, providerConfig.Bitbucket.Owner, providerConfig.Bitbucket.User, appPassword, providerConfig.Bitbucket.AllBranches)
+		provider, err = scm_provider.NewBitBucketCloudProvider(ctx, providerConfig.Bitbucket.Owner, providerConfig.Bitbucket.User, appPassword, providerConfig.Bitbucket.AllBranches, g.cache)
 		if err != nil {
 			return nil, fmt.Errorf("error initializing Bitbucket cloud service: %v", err)
 		}
+		provider = g.cache.Wrap(provider, applicationSetInfo.RefreshRequired(), "bitbucket", providerConfig.Bitbucket.Owner,
+			strconv.FormatBool(providerConfig.Bitbucket.AllBranches), providerConfig.Bitbucket.User, appPassword)
 	} else {
 		return nil, fmt.Errorf("no SCM provider implementation configured")
 	}
@@ -125,6 +161,9 @@ func (g *SCMProviderGenerator) getSecretRef(ctx context.Context, ref *argoprojio
 	if err != nil {
 		return "", fmt.Errorf("error fetching secret %s/%s: %v", namespace, ref.SecretName, err)
 	}
+	if err := checkSecretAllowed(secret, g.argoCDNamespace); err != nil {
+		return "", err
+	}
 	tokenBytes, ok := secret.Data[ref.Key]
 	if !ok {
 		return "", fmt.Errorf("key %q in secret %s/%s not found", ref.Key, namespace, ref.SecretName)
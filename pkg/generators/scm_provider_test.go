@@ -10,21 +10,33 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 
 	argoprojiov1alpha1 "github.com/argoproj/applicationset/api/v1alpha1"
+	"github.com/argoproj/applicationset/common"
 	"github.com/argoproj/applicationset/pkg/services/scm_provider"
 )
 
 func TestSCMProviderGetSecretRef(t *testing.T) {
 	secret := &corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{Name: "test-secret", Namespace: "test"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-secret",
+			Namespace: "test",
+			Labels:    map[string]string{common.LabelApplicationSetSecret: "true"},
+		},
+		Data: map[string][]byte{
+			"my-token": []byte("secret"),
+		},
+	}
+	notAllowedSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "not-allowed-secret", Namespace: "test"},
 		Data: map[string][]byte{
 			"my-token": []byte("secret"),
 		},
 	}
-	gen := &SCMProviderGenerator{client: fake.NewClientBuilder().WithObjects(secret).Build()}
+	gen := &SCMProviderGenerator{client: fake.NewClientBuilder().WithObjects(secret, notAllowedSecret).Build()}
 	ctx := context.Background()
 
 	cases := []struct {
 		name, namespace, token string
+		argoCDNamespace        string
 		ref                    *argoprojiov1alpha1.SecretRef
 		hasError               bool
 	}{
@@ -63,10 +75,27 @@ func TestSCMProviderGetSecretRef(t *testing.T) {
 			token:     "",
 			hasError:  true,
 		},
+		{
+			name:            "secret without the opt-in label",
+			ref:             &argoprojiov1alpha1.SecretRef{SecretName: "not-allowed-secret", Key: "my-token"},
+			namespace:       "test",
+			argoCDNamespace: "argocd",
+			token:           "",
+			hasError:        true,
+		},
+		{
+			name:            "secret without the opt-in label in the Argo CD namespace",
+			ref:             &argoprojiov1alpha1.SecretRef{SecretName: "not-allowed-secret", Key: "my-token"},
+			namespace:       "test",
+			argoCDNamespace: "test",
+			token:           "secret",
+			hasError:        false,
+		},
 	}
 
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
+			gen.argoCDNamespace = c.argoCDNamespace
 			token, err := gen.getSecretRef(ctx, c.ref, c.namespace)
 			if c.hasError {
 				assert.NotNil(t, err)
@@ -100,7 +129,7 @@ func TestSCMProviderGenerateParams(t *testing.T) {
 		},
 	}
 	gen := &SCMProviderGenerator{overrideProvider: mockProvider}
-	params, err := gen.GenerateParams(&argoprojiov1alpha1.ApplicationSetGenerator{
+	params, err := gen.GenerateParams(context.Background(), &argoprojiov1alpha1.ApplicationSetGenerator{
 		SCMProvider: &argoprojiov1alpha1.SCMProviderGenerator{},
 	}, nil)
 	assert.Nil(t, err)
@@ -0,0 +1,38 @@
+package generators
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	argoprojiov1alpha1 "github.com/argoproj/applicationset/api/v1alpha1"
+)
+
+var _ Generator = (*TimeoutGenerator)(nil)
+
+// TimeoutGenerator bounds the duration of the parameter generation of another generator: its context is cancelled
+// once the timeout is exceeded, so that long-running API calls and Git fetches are stopped.
+type TimeoutGenerator struct {
+	Generator
+	timeout time.Duration
+}
+
+// NewTimeoutGenerator returns a Generator which generates the parameters of generator, within the given timeout.
+func NewTimeoutGenerator(generator Generator, timeout time.Duration) Generator {
+	return &TimeoutGenerator{
+		Generator: generator,
+		timeout:   timeout,
+	}
+}
+
+func (g *TimeoutGenerator) GenerateParams(ctx context.Context, appSetGenerator *argoprojiov1alpha1.ApplicationSetGenerator, applicationSetInfo *argoprojiov1alpha1.ApplicationSet) ([]map[string]string, error) {
+	timeoutCtx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+
+	params, err := g.Generator.GenerateParams(timeoutCtx, appSetGenerator, applicationSetInfo)
+	// Only report the timeout of this generator, rather than the cancellation of the parent context
+	if err != nil && ctx.Err() == nil && timeoutCtx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("generator timed out after %s: %w", g.timeout, err)
+	}
+	return params, err
+}
@@ -0,0 +1,56 @@
+package generators
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	argoprojiov1alpha1 "github.com/argoproj/applicationset/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+)
+
+// blockingGenerator generates no parameters until its context is cancelled
+type blockingGenerator struct {
+	Generator
+}
+
+func (g *blockingGenerator) GenerateParams(ctx context.Context, _ *argoprojiov1alpha1.ApplicationSetGenerator, _ *argoprojiov1alpha1.ApplicationSet) ([]map[string]string, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestTimeoutGenerator(t *testing.T) {
+	appSetGenerator := &argoprojiov1alpha1.ApplicationSetGenerator{
+		List: &argoprojiov1alpha1.ListGenerator{
+			Elements: []apiextensionsv1.JSON{{Raw: []byte(`{"cluster": "cluster","url": "url"}`)}},
+		},
+	}
+
+	t.Run("generator within the timeout", func(t *testing.T) {
+		generator := NewTimeoutGenerator(NewListGenerator(), time.Minute)
+
+		got, err := generator.GenerateParams(context.Background(), appSetGenerator, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, []map[string]string{{"cluster": "cluster", "url": "url"}}, got)
+		assert.Equal(t, &appSetGenerator.List.Template, generator.GetTemplate(appSetGenerator))
+	})
+
+	t.Run("generator exceeding the timeout", func(t *testing.T) {
+		generator := NewTimeoutGenerator(&blockingGenerator{Generator: NewListGenerator()}, 10*time.Millisecond)
+
+		_, err := generator.GenerateParams(context.Background(), appSetGenerator, nil)
+		assert.EqualError(t, err, "generator timed out after 10ms: context deadline exceeded")
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+
+	t.Run("parent context cancelled", func(t *testing.T) {
+		generator := NewTimeoutGenerator(&blockingGenerator{Generator: NewListGenerator()}, time.Minute)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := generator.GenerateParams(ctx, appSetGenerator, nil)
+		assert.Equal(t, context.Canceled, err)
+	})
+}
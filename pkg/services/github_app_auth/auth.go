@@ -0,0 +1,122 @@
+package github_app_auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bradleyfalzon/ghinstallation/v2"
+)
+
+// Keys of the Secrets holding the credentials of a GitHub App. They are those of the repository credentials of
+// Argo CD, so that its Secrets may be reused by ApplicationSets.
+const (
+	SecretKeyAppID                = "githubAppID"
+	SecretKeyAppInstallationID    = "githubAppInstallationID"
+	SecretKeyAppPrivateKey        = "githubAppPrivateKey"
+	SecretKeyAppEnterpriseBaseURL = "githubAppEnterpriseBaseUrl"
+)
+
+// Authentication is the credentials of a GitHub App installation.
+type Authentication struct {
+	// ID is the ID of the GitHub App.
+	ID int64
+	// InstallationID is the ID of the installation of the GitHub App in an organization or user account.
+	InstallationID int64
+	// PrivateKey is the PEM encoded private key of the GitHub App.
+	PrivateKey string
+	// EnterpriseBaseURL is the API URL of GitHub Enterprise, if the GitHub App is installed there.
+	EnterpriseBaseURL string
+}
+
+// Transport returns a transport authenticating the requests sent with base as the installation of the GitHub App.
+// Installation tokens are minted from the API at url (or EnterpriseBaseURL, or github.com if both are empty), and
+// are refreshed before they expire.
+func (a *Authentication) Transport(base http.RoundTripper, url string) (http.RoundTripper, error) {
+	tr, err := ghinstallation.New(base, a.ID, a.InstallationID, []byte(a.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("error creating GitHub App transport: %v", err)
+	}
+	if url == "" {
+		url = a.EnterpriseBaseURL
+	}
+	if url != "" {
+		tr.BaseURL = enterpriseAPIURL(url)
+	}
+	return tr, nil
+}
+
+// transportIdleTimeout is the time after which a cached transport which is no longer used is evicted. It is longer
+// than the lifetime of installation tokens (one hour), which thus don't need to be minted again more often.
+const transportIdleTimeout = 2 * time.Hour
+
+// Transports caches the transports of GitHub App installations, so that their installation tokens are reused until
+// they expire, rather than minted again each time the ApplicationSets are reconciled. The cached transports send
+// their requests with the same base transport.
+type Transports struct {
+	base       http.RoundTripper
+	mu         sync.Mutex
+	transports map[string]*cachedTransport
+	// now returns the current time, and is overridden by tests
+	now func() time.Time
+}
+
+// cachedTransport is the transport of an installation, for the private key with the given hash
+type cachedTransport struct {
+	transport      http.RoundTripper
+	privateKeyHash string
+	lastUsed       time.Time
+}
+
+// NewTransports returns a Transports whose transports send their requests with base.
+func NewTransports(base http.RoundTripper) *Transports {
+	return &Transports{base: base, transports: map[string]*cachedTransport{}, now: time.Now}
+}
+
+// Transport returns the cached transport of the installation of auth for the API at url, creating it if needed. The
+// transport of an installation is replaced when its private key changes, and evicted once it has not been used for
+// transportIdleTimeout. A nil Transports creates a new transport, sending its requests with http.DefaultTransport.
+func (t *Transports) Transport(auth Authentication, url string) (http.RoundTripper, error) {
+	if t == nil {
+		return auth.Transport(http.DefaultTransport, url)
+	}
+	key := fmt.Sprintf("%d/%d/%s/%s", auth.ID, auth.InstallationID, url, auth.EnterpriseBaseURL)
+	hash := sha256.Sum256([]byte(auth.PrivateKey))
+	privateKeyHash := hex.EncodeToString(hash[:])
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	for k, cached := range t.transports {
+		if now.Sub(cached.lastUsed) >= transportIdleTimeout {
+			delete(t.transports, k)
+		}
+	}
+
+	// The transport of a rotated private key is replaced, so that the old key is used no more
+	if cached, ok := t.transports[key]; ok && cached.privateKeyHash == privateKeyHash {
+		cached.lastUsed = now
+		return cached.transport, nil
+	}
+	delete(t.transports, key)
+	tr, err := auth.Transport(t.base, url)
+	if err != nil {
+		return nil, err
+	}
+	t.transports[key] = &cachedTransport{transport: tr, privateKeyHash: privateKeyHash, lastUsed: now}
+	return tr, nil
+}
+
+// enterpriseAPIURL returns the REST API URL of a GitHub Enterprise server, which go-github accepts with or without
+// its api/v3 path.
+func enterpriseAPIURL(url string) string {
+	url = strings.TrimSuffix(url, "/")
+	if !strings.HasSuffix(url, "/api/v3") {
+		url += "/api/v3"
+	}
+	return url
+}
@@ -0,0 +1,119 @@
+package github_app_auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransport(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	tokenRequests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/app/installations/2/access_tokens":
+			tokenRequests++
+			assert.Equal(t, "POST", r.Method)
+			assert.Contains(t, r.Header.Get("Authorization"), "Bearer ", "requests of the app are authenticated with a JWT")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = fmt.Fprintf(w, `{"token": "installation-token", "expires_at": %q}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+		case "/api/v3/orgs/org/repos":
+			assert.Equal(t, "token installation-token", r.Header.Get("Authorization"))
+			_, _ = w.Write([]byte("[]"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	auth := &Authentication{ID: 1, InstallationID: 2, PrivateKey: string(privateKey)}
+	tr, err := auth.Transport(http.DefaultTransport, ts.URL+"/")
+	assert.NoError(t, err)
+	client := &http.Client{Transport: tr}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(ts.URL + "/api/v3/orgs/org/repos")
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	assert.Equal(t, 1, tokenRequests, "the installation token is reused until it expires")
+
+	_, err = (&Authentication{ID: 1, InstallationID: 2, PrivateKey: "invalid"}).Transport(http.DefaultTransport, "")
+	assert.Error(t, err)
+}
+
+func TestTransports(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	privateKey := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	otherPrivateKey := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(otherKey)}))
+
+	transports := NewTransports(http.DefaultTransport)
+	auth := Authentication{ID: 1, InstallationID: 2, PrivateKey: privateKey}
+	tr, err := transports.Transport(auth, "")
+	assert.NoError(t, err)
+	same, err := transports.Transport(auth, "")
+	assert.NoError(t, err)
+	assert.Same(t, tr, same, "the transport of an installation is reused")
+
+	for desc, other := range map[string]Authentication{
+		"app":          {ID: 3, InstallationID: 2, PrivateKey: privateKey},
+		"installation": {ID: 1, InstallationID: 3, PrivateKey: privateKey},
+		"private key":  {ID: 1, InstallationID: 2, PrivateKey: otherPrivateKey},
+		"base URL":     {ID: 1, InstallationID: 2, PrivateKey: privateKey, EnterpriseBaseURL: "https://git.example.com"},
+	} {
+		otherTr, err := transports.Transport(other, "")
+		assert.NoError(t, err)
+		assert.NotSame(t, tr, otherTr, desc)
+	}
+	otherTr, err := transports.Transport(auth, "https://git.example.com")
+	assert.NoError(t, err)
+	assert.NotSame(t, tr, otherTr, "url")
+
+	_, err = transports.Transport(Authentication{ID: 1, InstallationID: 2, PrivateKey: "invalid"}, "")
+	assert.Error(t, err)
+
+	// The transport of a rotated private key replaces the previous one
+	transports = NewTransports(http.DefaultTransport)
+	_, err = transports.Transport(auth, "")
+	assert.NoError(t, err)
+	rotated := auth
+	rotated.PrivateKey = otherPrivateKey
+	_, err = transports.Transport(rotated, "")
+	assert.NoError(t, err)
+	assert.Len(t, transports.transports, 1)
+
+	// Transports which are no longer used are evicted
+	transports.now = func() time.Time { return time.Now().Add(transportIdleTimeout) }
+	enterpriseTr, err := transports.Transport(auth, "https://git.example.com")
+	assert.NoError(t, err)
+	if assert.Len(t, transports.transports, 1, "the transport of the rotated key is evicted") {
+		for _, cached := range transports.transports {
+			assert.Same(t, enterpriseTr, cached.transport)
+		}
+	}
+
+	// A nil Transports creates a new transport
+	tr, err = (*Transports)(nil).Transport(auth, "")
+	assert.NoError(t, err)
+	assert.NotNil(t, tr)
+}
+
+func TestEnterpriseAPIURL(t *testing.T) {
+	assert.Equal(t, "https://git.example.com/api/v3", enterpriseAPIURL("https://git.example.com/"))
+	assert.Equal(t, "https://git.example.com/api/v3", enterpriseAPIURL("https://git.example.com"))
+	assert.Equal(t, "https://git.example.com/api/v3", enterpriseAPIURL("https://git.example.com/api/v3/"))
+}
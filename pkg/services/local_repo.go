@@ -0,0 +1,279 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	certutil "github.com/argoproj/argo-cd/v2/util/cert"
+	"github.com/argoproj/argo-cd/v2/util/git"
+	"github.com/argoproj/argo-cd/v2/util/proxy"
+	log "github.com/sirupsen/logrus"
+)
+
+// localRepo is a local copy of a remote repository, which is only ever shallow fetched and never checked out:
+// files and directories are read directly from the git objects of the fetched commit, so that no working tree
+// is written to disk.
+//
+// Since there is no working tree, Git LFS objects are not fetched, and the contents of submodules are not
+// available.
+type localRepo struct {
+	repo   *v1alpha1.Repository
+	root   string
+	client git.Client
+}
+
+var repoRootInvalidChars = regexp.MustCompile("(/|:)")
+
+// newLocalRepo returns a localRepo for the repository, rooted in a directory of workDir derived from the repo URL.
+func newLocalRepo(repo *v1alpha1.Repository, workDir string) (*localRepo, error) {
+	root := filepath.Join(workDir, repoRootInvalidChars.ReplaceAllString(git.NormalizeGitURL(repo.Repo), "_"))
+	if root == filepath.Clean(workDir) {
+		return nil, fmt.Errorf("Repository '%s' cannot be initialized, because its root would be the work directory %s", repo.Repo, workDir)
+	}
+
+	client, err := git.NewClientExt(repo.Repo, root, repo.GetGitCreds(), repo.IsInsecure(), repo.IsLFSEnabled(), repo.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	return &localRepo{
+		repo:   repo,
+		root:   root,
+		client: client,
+	}, nil
+}
+
+// fetch initializes the local repository, resolves the revision to a commit SHA and shallow fetches that commit,
+// returning the SHA.
+func (r *localRepo) fetch(ctx context.Context, revision string) (string, error) {
+	err := r.init(ctx)
+	if err != nil {
+		return "", fmt.Errorf("Error during initializing repo: %w", err)
+	}
+
+	commitSHA, err := r.lsRemote(ctx, revision)
+	if err != nil {
+		return "", fmt.Errorf("Error during fetching commitSHA: %w", err)
+	}
+
+	// The commit may already be present from a previous fetch
+	if _, err := r.runCmd(ctx, false, "cat-file", "-e", commitSHA+"^{commit}"); err == nil {
+		return commitSHA, nil
+	}
+
+	// Not every Git server allows fetching a commit by SHA, so fall back to fetching the revision by name.
+	if _, err := r.runCmd(ctx, true, "fetch", "--depth", "1", "--no-tags", "origin", commitSHA); err != nil {
+		log.WithError(err).WithField("repoURL", r.repo.Repo).Debug("unable to fetch commit by SHA, fetching by revision")
+
+		if _, err := r.runCmd(ctx, true, "fetch", "--depth", "1", "--no-tags", "--force", "origin", revision); err != nil {
+			return "", fmt.Errorf("Error during fetching repo: %w", err)
+		}
+		if _, err := r.runCmd(ctx, false, "cat-file", "-e", commitSHA+"^{commit}"); err != nil {
+			return "", fmt.Errorf("Error during fetching repo: commit %s not found after fetching revision %s", commitSHA, revision)
+		}
+	}
+
+	return commitSHA, nil
+}
+
+// init initializes the local repository, with the remote repository as origin, unless it is already initialized.
+// Unlike the git client, it runs the git commands with the context.
+func (r *localRepo) init(ctx context.Context) error {
+	if _, err := os.Stat(filepath.Join(r.root, ".git")); err == nil {
+		return nil
+	}
+	log.Infof("Initializing %s to %s", r.repo.Repo, r.root)
+	if err := os.RemoveAll(r.root); err != nil {
+		return fmt.Errorf("unable to clean repo at %s: %w", r.root, err)
+	}
+	if err := os.MkdirAll(r.root, 0755); err != nil {
+		return err
+	}
+	_, err := r.runCmd(ctx, false, "init")
+	if err == nil {
+		_, err = r.runCmd(ctx, false, "remote", "add", "origin", r.repo.Repo)
+	}
+	if err != nil {
+		// A partially initialized repository would otherwise be taken as initialized by the next fetch
+		_ = os.RemoveAll(r.root)
+	}
+	return err
+}
+
+// lsRemote resolves the revision to a commit SHA, with 'git ls-remote', so that the command is stopped once the
+// context is done.
+func (r *localRepo) lsRemote(ctx context.Context, revision string) (string, error) {
+	if git.IsCommitSHA(revision) {
+		return revision, nil
+	}
+	out, err := r.runCmd(ctx, true, "ls-remote", "origin")
+	if err != nil {
+		return "", err
+	}
+	return resolveRevision(string(out), revision)
+}
+
+// resolveRevision resolves the revision to a commit SHA from the output of 'git ls-remote', in the same way as the
+// git client of Argo CD: the revision may be HEAD (if empty), a branch or a tag, whose name may be short or full, or
+// a (truncated) commit SHA. An annotated tag is resolved to the SHA of its commit, rather than of the tag.
+func resolveRevision(lsRemoteOutput string, revision string) (string, error) {
+	if revision == "" {
+		revision = "HEAD"
+	}
+	refToHash := map[string]string{}
+	var refs []string
+	for _, line := range strings.Split(lsRemoteOutput, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if refToHash[fields[1]] == "" {
+			refs = append(refs, fields[1])
+		}
+		refToHash[fields[1]] = fields[0]
+	}
+	for _, ref := range refs {
+		if strings.HasSuffix(ref, "^{}") {
+			continue
+		}
+		short := strings.TrimPrefix(strings.TrimPrefix(ref, "refs/heads/"), "refs/tags/")
+		if ref != revision && short != revision {
+			continue
+		}
+		if peeled, ok := refToHash[ref+"^{}"]; ok {
+			return peeled, nil
+		}
+		return refToHash[ref], nil
+	}
+	// We support the ability to use a truncated commit-SHA (e.g. first 7 characters of a SHA)
+	if git.IsTruncatedCommitSHA(revision) {
+		return revision, nil
+	}
+	return "", fmt.Errorf("Unable to resolve '%s' to a commit SHA", revision)
+}
+
+// lsFiles returns the paths of the files of the commit that match the git pathspec pattern.
+func (r *localRepo) lsFiles(ctx context.Context, commitSHA string, pattern string) ([]string, error) {
+	// Read the commit's tree into a dedicated index, which ls-files can match against without a working tree. The
+	// index is unique to this call, so that concurrent calls for the same commit don't overwrite each other's index.
+	f, err := ioutil.TempFile(filepath.Join(r.root, ".git"), "applicationset-index-"+commitSHA+"-")
+	if err != nil {
+		return nil, err
+	}
+	indexFile := f.Name()
+	_ = f.Close()
+	// git refuses to read an empty index, so only the unique name is kept
+	_ = os.Remove(indexFile)
+	defer func() { _ = os.Remove(indexFile) }()
+	env := []string{"GIT_INDEX_FILE=" + indexFile}
+
+	if _, err := r.runCmdWithEnv(ctx, false, env, "read-tree", commitSHA); err != nil {
+		return nil, err
+	}
+	out, err := r.runCmdWithEnv(ctx, false, env, "ls-files", "--full-name", "-z", "--", pattern)
+	if err != nil {
+		return nil, err
+	}
+	return splitNullTerminated(out), nil
+}
+
+// lsDirectories returns the paths of all directories of the commit, excluding those which (or whose parents)
+// begin with '.'.
+func (r *localRepo) lsDirectories(ctx context.Context, commitSHA string) ([]string, error) {
+	out, err := r.runCmd(ctx, false, "ls-tree", "-r", "-d", "--name-only", "-z", commitSHA)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []string{}
+	for _, dir := range splitNullTerminated(out) {
+		hidden := false
+		for _, segment := range strings.Split(dir, "/") {
+			if strings.HasPrefix(segment, ".") {
+				hidden = true
+				break
+			}
+		}
+		if !hidden {
+			res = append(res, dir)
+		}
+	}
+	return res, nil
+}
+
+// readFile returns the contents of the file at the path of the commit.
+func (r *localRepo) readFile(ctx context.Context, commitSHA string, path string) ([]byte, error) {
+	return r.runCmd(ctx, false, "cat-file", "blob", commitSHA+":"+path)
+}
+
+func (r *localRepo) runCmd(ctx context.Context, credentialed bool, args ...string) ([]byte, error) {
+	return r.runCmdWithEnv(ctx, credentialed, nil, args...)
+}
+
+// runCmdWithEnv runs a git command in the repository root, with the same environment Argo CD uses for its own
+// git commands. If credentialed is true, the repository credentials are added to the environment.
+func (r *localRepo) runCmdWithEnv(ctx context.Context, credentialed bool, env []string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = r.root
+	// The environment of the process comes first: when a variable is set several times, the last value is used, so
+	// that the variables set below take precedence over those of the process
+	cmd.Env = append(cmd.Env, os.Environ()...)
+	cmd.Env = append(cmd.Env, env...)
+
+	if credentialed {
+		closer, environ, err := r.repo.GetGitCreds().Environ()
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = closer.Close() }()
+		cmd.Env = append(cmd.Env, environ...)
+	}
+
+	// Set $HOME to nowhere, so that git is not affected by any external configuration or keys
+	cmd.Env = append(cmd.Env, "HOME=/dev/null", "GIT_LFS_SKIP_SMUDGE=1")
+
+	if git.IsHTTPSURL(r.repo.Repo) {
+		if r.repo.IsInsecure() {
+			cmd.Env = append(cmd.Env, "GIT_SSL_NO_VERIFY=true")
+		} else if parsedURL, err := url.Parse(r.repo.Repo); err == nil {
+			caPath, err := certutil.GetCertBundlePathForRepository(parsedURL.Host)
+			if err == nil && caPath != "" {
+				cmd.Env = append(cmd.Env, fmt.Sprintf("GIT_SSL_CAINFO=%s", caPath))
+			}
+		}
+	}
+	cmd.Env = proxy.UpsertEnv(cmd, r.repo.Proxy)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	log.WithFields(log.Fields{"dir": cmd.Dir, "args": args}).Debug("running git command")
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			// The command was killed, as the context is done
+			return nil, fmt.Errorf("`git %s` failed: %w", args[0], ctx.Err())
+		}
+		return nil, fmt.Errorf("`git %s` failed: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+func splitNullTerminated(out []byte) []string {
+	res := []string{}
+	for _, s := range strings.Split(string(out), "\000") {
+		if s != "" {
+			res = append(res, s)
+		}
+	}
+	return res
+}
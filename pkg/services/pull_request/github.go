@@ -3,10 +3,13 @@ package pull_request
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
 
 	"github.com/google/go-github/v35/github"
 	"golang.org/x/oauth2"
+
+	"github.com/argoproj/applicationset/pkg/services/github_app_auth"
 )
 
 type GithubService struct {
@@ -30,6 +33,23 @@ func NewGithubService(ctx context.Context, token, url, owner, repo string, label
 		)
 	}
 	httpClient := oauth2.NewClient(ctx, ts)
+	return newGithubService(httpClient, url, owner, repo, labels)
+}
+
+// NewGithubAppService returns a service listing the pull requests of a GitHub repository, authenticated as the
+// installation of a GitHub App, with a transport of transports.
+func NewGithubAppService(ctx context.Context, auth github_app_auth.Authentication, transports *github_app_auth.Transports, url, owner, repo string, labels []string) (PullRequestService, error) {
+	tr, err := transports.Transport(auth, url)
+	if err != nil {
+		return nil, err
+	}
+	if url == "" {
+		url = auth.EnterpriseBaseURL
+	}
+	return newGithubService(&http.Client{Transport: tr}, url, owner, repo, labels)
+}
+
+func newGithubService(httpClient *http.Client, url, owner, repo string, labels []string) (PullRequestService, error) {
 	var client *github.Client
 	if url == "" {
 		client = github.NewClient(httpClient)
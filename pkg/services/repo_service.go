@@ -5,11 +5,15 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
 	"github.com/argoproj/argo-cd/v2/util/db"
-	"github.com/argoproj/argo-cd/v2/util/git"
+	"github.com/argoproj/argo-cd/v2/util/gpg"
+	log "github.com/sirupsen/logrus"
 )
 
 // RepositoryDB Is a lean facade for ArgoDB,
@@ -20,6 +24,21 @@ type RepositoryDB interface {
 
 type argoCDService struct {
 	repositoriesDB RepositoryDB
+	// workDir is the directory under which repositories are fetched. If empty, the system temp directory is used.
+	workDir string
+	// diskQuota is the maximum number of bytes that fetched repositories may use within workDir. 0 disables the quota.
+	diskQuota int64
+
+	// repoRootsLock protects repoRoots, repoLocks and repoRootsInUse
+	repoRootsLock sync.Mutex
+	// repoRoots contains the roots of the repositories fetched by this service, and when they were last used
+	repoRoots map[string]time.Time
+	// repoLocks serialize the fetches and reads of each repository root, which share the same git directory: a
+	// repository is locked by sending to its channel, and unlocked by receiving from it
+	repoLocks map[string]chan struct{}
+	// repoRootsInUse counts the fetches and reads of each repository root which are in progress or waiting: a
+	// repository is not removed to enforce the disk quota while it is in use
+	repoRootsInUse map[string]int
 }
 
 type Repos interface {
@@ -29,121 +48,256 @@ type Repos interface {
 
 	// GetDirectories returns a list of directories (not files) within the target repo
 	GetDirectories(ctx context.Context, repoURL string, revision string) ([]string, error)
+
+	// VerifyCommitSignature verifies that the commit the revision resolves to is signed by a key in the GnuPG
+	// keyring, and, if signatureKeys is not empty, that it is one of signatureKeys. Returns the SHA of the commit.
+	VerifyCommitSignature(ctx context.Context, repoURL string, revision string, signatureKeys []string) (string, error)
 }
 
-func NewArgoCDService(db db.ArgoDB, repoServerAddress string) Repos {
+// NewArgoCDService returns a Repos implementation which shallow fetches repositories into workDir, and reads
+// files and directories from the fetched commit without checking it out. When diskQuota is non-zero, the least
+// recently used repositories are removed from workDir once they use more than diskQuota bytes.
+//
+// repoServerAddress is currently unused: the Argo CD repo-server API this controller is built against has no
+// calls for listing the files and directories of a revision, or for reading file contents, so repositories are
+// always fetched by the ApplicationSet controller itself, using the repository credentials from the Argo CD DB.
+func NewArgoCDService(db db.ArgoDB, repoServerAddress string, workDir string, diskQuota int64) Repos {
 
 	return &argoCDService{
 		repositoriesDB: db.(RepositoryDB),
+		workDir:        workDir,
+		diskQuota:      diskQuota,
 	}
 }
 
 func (a *argoCDService) GetFiles(ctx context.Context, repoURL string, revision string, pattern string) (map[string][]byte, error) {
-	repo, err := a.repositoriesDB.GetRepository(ctx, repoURL)
-	if err != nil {
-		return nil, fmt.Errorf("Error in GetRepository: %w", err)
-	}
+	res := map[string][]byte{}
 
-	gitRepoClient, err := git.NewClient(repo.Repo, repo.GetGitCreds(), repo.IsInsecure(), repo.IsLFSEnabled(), repo.Proxy)
+	err := a.withRepo(ctx, repoURL, revision, func(repo *localRepo, commitSHA string) error {
+		paths, err := repo.lsFiles(ctx, commitSHA, pattern)
+		if err != nil {
+			return fmt.Errorf("Error during listing files of local repo: %w", err)
+		}
 
+		for _, filePath := range paths {
+			bytes, err := repo.readFile(ctx, commitSHA, filePath)
+			if err != nil {
+				return err
+			}
+			res[filePath] = bytes
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	err = checkoutRepo(gitRepoClient, revision)
+	return res, nil
+}
+
+func (a *argoCDService) GetDirectories(ctx context.Context, repoURL string, revision string) ([]string, error) {
+	var res []string
+
+	err := a.withRepo(ctx, repoURL, revision, func(repo *localRepo, commitSHA string) error {
+		var err error
+		res, err = repo.lsDirectories(ctx, commitSHA)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	paths, err := gitRepoClient.LsFiles(pattern)
-	if err != nil {
-		return nil, fmt.Errorf("Error during listing files of local repo: %w", err)
+	return res, nil
+}
+
+func (a *argoCDService) VerifyCommitSignature(ctx context.Context, repoURL string, revision string, signatureKeys []string) (string, error) {
+	if !gpg.IsGPGEnabled() {
+		return "", fmt.Errorf("unable to verify the signature of revision '%s': GnuPG is disabled", revision)
 	}
 
-	res := map[string][]byte{}
-	for _, filePath := range paths {
-		bytes, err := os.ReadFile(filepath.Join(gitRepoClient.Root(), filePath))
+	var res string
+	err := a.withRepo(ctx, repoURL, revision, func(repo *localRepo, commitSHA string) error {
+		out, err := repo.client.VerifyCommitSignature(commitSHA)
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("Error during verifying the signature of commit %s: %w", commitSHA, err)
+		}
+		if err := checkCommitVerification(out, signatureKeys); err != nil {
+			return fmt.Errorf("signature of commit %s of revision '%s' is not valid: %w", commitSHA, revision, err)
 		}
-		res[filePath] = bytes
+		res = commitSHA
+		return nil
+	})
+	if err != nil {
+		return "", err
 	}
 
 	return res, nil
 }
 
-func (a *argoCDService) GetDirectories(ctx context.Context, repoURL string, revision string) ([]string, error) {
+// checkCommitVerification checks the output of verifying a commit's signature: the signature must be good, and,
+// if signatureKeys is not empty, made by one of signatureKeys (given as key IDs or fingerprints).
+func checkCommitVerification(out string, signatureKeys []string) error {
+	if strings.TrimSpace(out) == "" {
+		return fmt.Errorf("commit is not signed")
+	}
 
-	repo, err := a.repositoriesDB.GetRepository(ctx, repoURL)
-	if err != nil {
-		return nil, fmt.Errorf("Error in GetRepository: %w", err)
+	result := gpg.ParseGitCommitVerification(out)
+	switch result.Result {
+	case gpg.VerifyResultGood:
+	case gpg.VerifyResultBad:
+		return fmt.Errorf("bad signature from key %s", result.KeyID)
+	default:
+		return fmt.Errorf("unable to verify signature: %s", result.Message)
 	}
 
-	gitRepoClient, err := git.NewClient(repo.Repo, repo.GetGitCreds(), repo.IsInsecure(), repo.IsLFSEnabled(), repo.Proxy)
-	if err != nil {
-		return nil, err
+	if len(signatureKeys) == 0 {
+		return nil
 	}
+	for _, key := range signatureKeys {
+		if strings.EqualFold(gpg.KeyID(key), result.KeyID) {
+			return nil
+		}
+	}
+	return fmt.Errorf("commit is signed with key %s, which is not one of the signature keys", result.KeyID)
+}
 
-	err = checkoutRepo(gitRepoClient, revision)
+// withRepo fetches the revision of the repository, and calls f with the local repository and the resolved commit
+// SHA. Afterwards, the disk quota is enforced.
+func (a *argoCDService) withRepo(ctx context.Context, repoURL string, revision string, f func(repo *localRepo, commitSHA string) error) error {
+	repo, err := a.repositoriesDB.GetRepository(ctx, repoURL)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("Error in GetRepository: %w", err)
 	}
 
-	filteredPaths := []string{}
-
-	repoRoot := gitRepoClient.Root()
+	workDir := a.workDir
+	if workDir == "" {
+		workDir = os.TempDir()
+	}
 
-	if err := filepath.Walk(repoRoot, func(path string, info os.FileInfo, fnErr error) error {
-		if fnErr != nil {
-			return fnErr
-		}
-		if !info.IsDir() { // Skip files: directories only
-			return nil
-		}
+	gitRepo, err := newLocalRepo(repo, workDir)
+	if err != nil {
+		return err
+	}
+	defer a.enforceDiskQuota(gitRepo.root)
+	unlock, err := a.lockRepo(ctx, gitRepo.root)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-		fname := info.Name()
-		if strings.HasPrefix(fname, ".") { // Skip all folders starts with "."
-			return filepath.SkipDir
-		}
+	commitSHA, err := gitRepo.fetch(ctx, revision)
+	if err != nil {
+		return err
+	}
 
-		relativePath, err := filepath.Rel(repoRoot, path)
-		if err != nil {
-			return err
-		}
+	return f(gitRepo, commitSHA)
+}
 
-		if relativePath == "." { // Exclude '.' from results
-			return nil
+// lockRepo waits until no other fetch or read of the repository at root is in progress, or until the context is
+// done, and marks the repository as in use, so that it is not removed to enforce the disk quota. Returns the function
+// which releases the repository.
+func (a *argoCDService) lockRepo(ctx context.Context, root string) (func(), error) {
+	a.repoRootsLock.Lock()
+	if a.repoLocks == nil {
+		a.repoLocks = map[string]chan struct{}{}
+		a.repoRootsInUse = map[string]int{}
+	}
+	lock, exists := a.repoLocks[root]
+	if !exists {
+		lock = make(chan struct{}, 1)
+		a.repoLocks[root] = lock
+	}
+	a.repoRootsInUse[root]++
+	a.repoRootsLock.Unlock()
+
+	release := func() {
+		a.repoRootsLock.Lock()
+		defer a.repoRootsLock.Unlock()
+		a.repoRootsInUse[root]--
+		if a.repoRootsInUse[root] == 0 {
+			delete(a.repoRootsInUse, root)
 		}
+	}
 
-		filteredPaths = append(filteredPaths, relativePath)
-
-		return nil
-	}); err != nil {
-		return nil, err
+	select {
+	case lock <- struct{}{}:
+	case <-ctx.Done():
+		release()
+		return nil, fmt.Errorf("Error waiting for another fetch of the repository: %w", ctx.Err())
 	}
+	return func() {
+		<-lock
+		release()
+	}, nil
+}
 
-	return filteredPaths, nil
+// enforceDiskQuota records the use of the repository at root, and then removes the least recently used
+// repositories (lastly root itself) until the repositories fetched by this service fit in the disk quota.
+// Repositories which are in use are not removed.
+func (a *argoCDService) enforceDiskQuota(root string) {
+	a.repoRootsLock.Lock()
+	defer a.repoRootsLock.Unlock()
 
-}
+	if a.repoRoots == nil {
+		a.repoRoots = map[string]time.Time{}
+	}
+	a.repoRoots[root] = time.Now()
 
-func checkoutRepo(gitRepoClient git.Client, revision string) error {
-	err := gitRepoClient.Init()
-	if err != nil {
-		return fmt.Errorf("Error during initializing repo: %w", err)
+	if a.diskQuota <= 0 {
+		return
 	}
 
-	err = gitRepoClient.Fetch(revision)
-	if err != nil {
-		return fmt.Errorf("Error during fetching repo: %w", err)
+	sizes := map[string]int64{}
+	var total int64
+	for repoRoot := range a.repoRoots {
+		size, err := dirSize(repoRoot)
+		if err != nil {
+			log.WithError(err).WithField("root", repoRoot).Warn("unable to determine size of repository")
+		}
+		sizes[repoRoot] = size
+		total += size
 	}
 
-	commitSHA, err := gitRepoClient.LsRemote(revision)
-	if err != nil {
-		return fmt.Errorf("Error during fetching commitSHA: %w", err)
+	repoRoots := make([]string, 0, len(a.repoRoots))
+	for repoRoot := range a.repoRoots {
+		repoRoots = append(repoRoots, repoRoot)
 	}
-	err = gitRepoClient.Checkout(commitSHA, true)
-	if err != nil {
-		return fmt.Errorf("Error during repo checkout: %w", err)
+	sort.Slice(repoRoots, func(i, j int) bool {
+		return a.repoRoots[repoRoots[i]].Before(a.repoRoots[repoRoots[j]])
+	})
+
+	for _, repoRoot := range repoRoots {
+		if total <= a.diskQuota {
+			break
+		}
+		if a.repoRootsInUse[repoRoot] > 0 {
+			continue
+		}
+		log.WithFields(log.Fields{"root": repoRoot, "size": sizes[repoRoot], "quota": a.diskQuota}).
+			Info("removing repository to stay within the disk quota")
+		if err := os.RemoveAll(repoRoot); err != nil {
+			log.WithError(err).WithField("root", repoRoot).Error("unable to remove repository")
+			continue
+		}
+		delete(a.repoRoots, repoRoot)
+		total -= sizes[repoRoot]
 	}
-	return nil
+}
+
+// dirSize returns the total size of the regular files within dir.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
 }
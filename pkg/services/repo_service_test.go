@@ -2,9 +2,15 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"sort"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
 	"github.com/stretchr/testify/assert"
@@ -162,7 +168,7 @@ func TestGetFiles(t *testing.T) {
 			revision:            "this-tag-does-not-exist",
 			pattern:             "*",
 			expectSubsetOfPaths: []string{},
-			expectedError:       fmt.Errorf("Error during fetching repo: `git fetch origin this-tag-does-not-exist --tags --force` failed exit status 128: fatal: couldn't find remote ref this-tag-does-not-exist"),
+			expectedError:       fmt.Errorf("Error during fetching commitSHA: Unable to resolve 'this-tag-does-not-exist' to a commit SHA"),
 		},
 		{
 			name: "pull a specific revision of example apps, and use a ** pattern",
@@ -230,3 +236,342 @@ func TestGetFiles(t *testing.T) {
 		})
 	}
 }
+
+// initTestRepo creates a local Git repository containing the files, returning its file:// URL and the SHA of its
+// single commit.
+func initTestRepo(t *testing.T, files map[string]string) (string, string) {
+	dir := t.TempDir()
+	for path, content := range files {
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, path), []byte(content), 0644))
+	}
+
+	runGit := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		out, err := cmd.CombinedOutput()
+		assert.NoError(t, err, string(out))
+		return string(out)
+	}
+	runGit("init", "--initial-branch=main")
+	runGit("add", "-A")
+	runGit("commit", "-m", "initial commit")
+	commitSHA := runGit("rev-parse", "HEAD")
+
+	return "file://" + dir, commitSHA[:40]
+}
+
+func TestLocalRepoWithoutWorkingTree(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+
+	repoURL, commitSHA := initTestRepo(t, map[string]string{
+		"apps/app1/config.yaml":      "name: app1\n",
+		"apps/app2/config.yaml":      "name: app2\n",
+		"apps/app2/manifests/a.yaml": "kind: ConfigMap\n",
+		".hidden/config.yaml":        "name: hidden\n",
+	})
+
+	for _, revision := range []string{"main", commitSHA} {
+		t.Run(revision, func(t *testing.T) {
+			argocdRepositoryMock := ArgocdRepositoryMock{mock: &mock.Mock{}}
+			argocdRepositoryMock.mock.On("GetRepository", mock.Anything, repoURL).Return(&v1alpha1.Repository{Repo: repoURL}, nil)
+
+			workDir := t.TempDir()
+			argocd := argoCDService{
+				repositoriesDB: argocdRepositoryMock,
+				workDir:        workDir,
+			}
+
+			dirs, err := argocd.GetDirectories(context.Background(), repoURL, revision)
+			assert.NoError(t, err)
+			sort.Strings(dirs)
+			assert.Equal(t, []string{"apps", "apps/app1", "apps/app2", "apps/app2/manifests"}, dirs)
+
+			files, err := argocd.GetFiles(context.Background(), repoURL, revision, "apps/*/config.yaml")
+			assert.NoError(t, err)
+			assert.Equal(t, map[string][]byte{
+				"apps/app1/config.yaml": []byte("name: app1\n"),
+				"apps/app2/config.yaml": []byte("name: app2\n"),
+			}, files)
+
+			// Nothing should have been checked out
+			entries, err := os.ReadDir(workDir)
+			assert.NoError(t, err)
+			if assert.Len(t, entries, 1) {
+				repoEntries, err := os.ReadDir(filepath.Join(workDir, entries[0].Name()))
+				assert.NoError(t, err)
+				assert.Len(t, repoEntries, 1)
+				assert.Equal(t, ".git", repoEntries[0].Name())
+			}
+		})
+	}
+}
+
+func TestLocalRepoConcurrentLsFiles(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+
+	repoURL, _ := initTestRepo(t, map[string]string{
+		"apps/app1/config.yaml": "name: app1\n",
+		"apps/app2/config.yaml": "name: app2\n",
+	})
+	repo, err := newLocalRepo(&v1alpha1.Repository{Repo: repoURL}, t.TempDir())
+	assert.NoError(t, err)
+	commitSHA, err := repo.fetch(context.Background(), "main")
+	assert.NoError(t, err)
+
+	// The variables set for the git commands take precedence over those of the process
+	t.Setenv("GIT_INDEX_FILE", filepath.Join(t.TempDir(), "missing", "index"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			files, err := repo.lsFiles(context.Background(), commitSHA, "apps/*/config.yaml")
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"apps/app1/config.yaml", "apps/app2/config.yaml"}, files)
+		}()
+	}
+	wg.Wait()
+
+	// The indexes are removed
+	indexes, err := filepath.Glob(filepath.Join(repo.root, ".git", "applicationset-index-*"))
+	assert.NoError(t, err)
+	assert.Empty(t, indexes)
+}
+
+func TestResolveRevision(t *testing.T) {
+	lsRemoteOutput := `1111111111111111111111111111111111111111	HEAD
+1111111111111111111111111111111111111111	refs/heads/main
+2222222222222222222222222222222222222222	refs/heads/release
+3333333333333333333333333333333333333333	refs/pull/1/head
+4444444444444444444444444444444444444444	refs/tags/release
+5555555555555555555555555555555555555555	refs/tags/v1.0.0
+6666666666666666666666666666666666666666	refs/tags/v1.0.0^{}
+`
+	for _, c := range []struct {
+		revision      string
+		expected      string
+		expectedError string
+	}{
+		{revision: "", expected: "1111111111111111111111111111111111111111"},
+		{revision: "HEAD", expected: "1111111111111111111111111111111111111111"},
+		{revision: "main", expected: "1111111111111111111111111111111111111111"},
+		{revision: "refs/heads/main", expected: "1111111111111111111111111111111111111111"},
+		// Branches take precedence over tags with the same name
+		{revision: "release", expected: "2222222222222222222222222222222222222222"},
+		{revision: "refs/tags/release", expected: "4444444444444444444444444444444444444444"},
+		{revision: "refs/pull/1/head", expected: "3333333333333333333333333333333333333333"},
+		// Annotated tags are resolved to their commit
+		{revision: "v1.0.0", expected: "6666666666666666666666666666666666666666"},
+		{revision: "abcdef1", expected: "abcdef1"},
+		{revision: "missing", expectedError: "Unable to resolve 'missing' to a commit SHA"},
+	} {
+		t.Run(c.revision, func(t *testing.T) {
+			commitSHA, err := resolveRevision(lsRemoteOutput, c.revision)
+			if c.expectedError != "" {
+				assert.EqualError(t, err, c.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, c.expected, commitSHA)
+		})
+	}
+}
+
+func TestLocalRepoFetchIsCancelled(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+
+	repoURL, _ := initTestRepo(t, map[string]string{"config.yaml": "name: app1\n"})
+	repo, err := newLocalRepo(&v1alpha1.Repository{Repo: repoURL}, t.TempDir())
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = repo.fetch(ctx, "main")
+	assert.True(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+
+	// The repository is initialized and fetched once the context allows it
+	_, err = repo.fetch(context.Background(), "main")
+	assert.NoError(t, err)
+}
+
+func TestEnforceDiskQuota(t *testing.T) {
+	workDir := t.TempDir()
+
+	roots := []string{}
+	for _, name := range []string{"repo1", "repo2", "repo3"} {
+		root := filepath.Join(workDir, name)
+		assert.NoError(t, os.MkdirAll(root, 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(root, "data"), make([]byte, 100), 0644))
+		roots = append(roots, root)
+	}
+
+	argocd := argoCDService{
+		workDir:   workDir,
+		diskQuota: 250,
+	}
+
+	argocd.enforceDiskQuota(roots[0])
+	argocd.enforceDiskQuota(roots[1])
+	assert.DirExists(t, roots[0])
+	assert.DirExists(t, roots[1])
+
+	// The least recently used repository is removed first
+	argocd.enforceDiskQuota(roots[2])
+	assert.NoDirExists(t, roots[0])
+	assert.DirExists(t, roots[1])
+	assert.DirExists(t, roots[2])
+
+	argocd.diskQuota = 50
+	argocd.enforceDiskQuota(roots[2])
+	assert.NoDirExists(t, roots[1])
+	assert.NoDirExists(t, roots[2])
+}
+
+func TestEnforceDiskQuotaSkipsRepositoriesInUse(t *testing.T) {
+	workDir := t.TempDir()
+
+	roots := []string{}
+	for _, name := range []string{"repo1", "repo2"} {
+		root := filepath.Join(workDir, name)
+		assert.NoError(t, os.MkdirAll(root, 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(root, "data"), make([]byte, 100), 0644))
+		roots = append(roots, root)
+	}
+
+	argocd := argoCDService{
+		workDir:   workDir,
+		diskQuota: 150,
+	}
+
+	unlock, err := argocd.lockRepo(context.Background(), roots[0])
+	assert.NoError(t, err)
+	argocd.enforceDiskQuota(roots[0])
+	argocd.enforceDiskQuota(roots[1])
+	// The least recently used repository is in use, so the other one is removed instead
+	assert.DirExists(t, roots[0])
+	assert.NoDirExists(t, roots[1])
+
+	unlock()
+	argocd.diskQuota = 50
+	argocd.enforceDiskQuota(roots[0])
+	assert.NoDirExists(t, roots[0])
+}
+
+func TestLockRepo(t *testing.T) {
+	argocd := argoCDService{}
+
+	unlock, err := argocd.lockRepo(context.Background(), "repo1")
+	assert.NoError(t, err)
+	// Another repository is not locked
+	unlock2, err := argocd.lockRepo(context.Background(), "repo2")
+	assert.NoError(t, err)
+	unlock2()
+
+	// Waiting for the lock stops once the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = argocd.lockRepo(ctx, "repo1")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, 1, argocd.repoRootsInUse["repo1"])
+
+	locked := make(chan struct{})
+	go func() {
+		unlock, err := argocd.lockRepo(context.Background(), "repo1")
+		assert.NoError(t, err)
+		defer unlock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("repository was locked twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("repository was not unlocked")
+	}
+}
+
+func TestCheckCommitVerification(t *testing.T) {
+	goodSignature := `gpg: Signature made Wed Feb 26 23:22:34 2020 CET
+gpg:                using RSA key 4AEE18F83AFDEB23
+gpg: Good signature from "GitHub (web-flow commit signing) <noreply@github.com>" [ultimate]
+`
+	badSignature := `gpg: Signature made Wed Feb 26 23:22:34 2020 CET
+gpg:                using RSA key 4AEE18F83AFDEB23
+gpg: BAD signature from "GitHub (web-flow commit signing) <noreply@github.com>" [ultimate]
+`
+	unknownKey := `gpg: Signature made Mon Aug 26 20:59:48 2019 CEST
+gpg:                using RSA key 4AEE18F83AFDEB23
+gpg: Can't check signature: No public key
+`
+
+	cases := []struct {
+		name          string
+		out           string
+		signatureKeys []string
+		expectedError string
+	}{
+		{
+			name: "good signature",
+			out:  goodSignature,
+		},
+		{
+			name:          "good signature by a signature key",
+			out:           goodSignature,
+			signatureKeys: []string{"D56C4FCA57A46444", "4AEE18F83AFDEB23"},
+		},
+		{
+			name:          "good signature by a signature key fingerprint",
+			out:           goodSignature,
+			signatureKeys: []string{"5DE3E0509C47EA3CF04A42D34AEE18F83AFDEB23"},
+		},
+		{
+			name:          "good signature by another key",
+			out:           goodSignature,
+			signatureKeys: []string{"D56C4FCA57A46444"},
+			expectedError: "commit is signed with key 4AEE18F83AFDEB23, which is not one of the signature keys",
+		},
+		{
+			name:          "bad signature",
+			out:           badSignature,
+			expectedError: "bad signature from key 4AEE18F83AFDEB23",
+		},
+		{
+			name:          "key not in keyring",
+			out:           unknownKey,
+			expectedError: "unable to verify signature: gpg: Can't check signature: No public key",
+		},
+		{
+			name:          "unsigned",
+			out:           "\n",
+			expectedError: "commit is not signed",
+		},
+	}
+
+	for _, c := range cases {
+		cc := c
+		t.Run(cc.name, func(t *testing.T) {
+			err := checkCommitVerification(cc.out, cc.signatureKeys)
+			if cc.expectedError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, cc.expectedError)
+			}
+		})
+	}
+}
@@ -51,7 +51,9 @@ func (c *ExtendedClient) GetContents(repo *Repository, path string) (bool, error
 
 var _ SCMProviderService = &BitBucketCloudProvider{}
 
-func NewBitBucketCloudProvider(ctx context.Context, owner string, user string, password string, allBranches bool) (*BitBucketCloudProvider, error) {
+// NewBitBucketCloudProvider returns a provider listing the repositories of a Bitbucket Cloud workspace. Its
+// requests use cache, if not nil.
+func NewBitBucketCloudProvider(ctx context.Context, owner string, user string, password string, allBranches bool, cache *Cache) (*BitBucketCloudProvider, error) {
 
 	client := &ExtendedClient{
 		bitbucket.NewBasicAuth(user, password),
@@ -59,6 +61,7 @@ func NewBitBucketCloudProvider(ctx context.Context, owner string, user string, p
 		password,
 		owner,
 	}
+	client.HttpClient = cache.HTTPClient()
 	return &BitBucketCloudProvider{client: client, owner: owner, allBranches: allBranches}, nil
 }
 
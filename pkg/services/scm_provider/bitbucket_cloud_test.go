@@ -86,7 +86,7 @@ func TestBitbucketHasRepo(t *testing.T) {
 
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
-			provider, _ := NewBitBucketCloudProvider(context.Background(), c.owner, "user", "password", false)
+			provider, _ := NewBitBucketCloudProvider(context.Background(), c.owner, "user", "password", false, nil)
 			repo := &Repository{
 				Organization: c.owner,
 				Repository:   c.repo,
@@ -486,7 +486,7 @@ func TestBitbucketListRepos(t *testing.T) {
 
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
-			provider, _ := NewBitBucketCloudProvider(context.Background(), c.owner, "user", "password", c.allBranches)
+			provider, _ := NewBitBucketCloudProvider(context.Background(), c.owner, "user", "password", c.allBranches, nil)
 			rawRepos, err := ListRepos(context.Background(), provider, c.filters, c.proto)
 			if c.hasError {
 				assert.Error(t, err)
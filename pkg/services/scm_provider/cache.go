@@ -0,0 +1,371 @@
+package scm_provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gocache "github.com/patrickmn/go-cache"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// responseCacheExpiration is the duration for which a response is kept, to make conditional requests for it
+	responseCacheExpiration = 24 * time.Hour
+	// immutableResultExpiration is the duration for which results which never change, such as the existence of a
+	// path at a given commit, are kept
+	immutableResultExpiration = 24 * time.Hour
+	// maxRateLimitRetries is the number of times a rate-limited request is retried
+	maxRateLimitRetries = 3
+)
+
+// Cache holds the state shared by the SCM providers of all the ApplicationSets, so that they spare the rate limits
+// of the SCM APIs:
+// - the results of the providers, which are reused for a TTL;
+// - the responses of the APIs, with their ETag or Last-Modified headers, which are revalidated by conditional
+// requests, which don't count against the GitHub rate limit;
+// - the rate limits reported by the APIs, for each API host and credentials, which are waited for before sending
+// requests which would fail.
+//
+// A nil *Cache disables caching.
+type Cache struct {
+	ttl time.Duration
+	// maxRateLimitWait is the maximum duration requests are delayed by an exhausted rate limit, before failing
+	maxRateLimitWait time.Duration
+
+	results   *gocache.Cache
+	responses *gocache.Cache
+
+	rateLimitsMu sync.Mutex
+	rateLimits   map[string]time.Time
+}
+
+// NewCache returns a Cache which keeps the results of the providers for ttl (never, if ttl is 0), and delays the
+// requests of exhausted rate limits by up to maxRateLimitWait.
+func NewCache(ttl time.Duration, maxRateLimitWait time.Duration) *Cache {
+	return &Cache{
+		ttl:              ttl,
+		maxRateLimitWait: maxRateLimitWait,
+		results:          gocache.New(ttl, 10*time.Minute),
+		responses:        gocache.New(responseCacheExpiration, 10*time.Minute),
+		rateLimits:       map[string]time.Time{},
+	}
+}
+
+// Wrap returns a provider caching the results of provider. The identity (eg the type, API URL, organization,
+// options and credentials of the provider) distinguishes the results of different providers; it is hashed, so
+// that credentials aren't kept. When refresh is true, cached results are ignored, but the new results are cached.
+func (c *Cache) Wrap(provider SCMProviderService, refresh bool, identity ...string) SCMProviderService {
+	if c == nil {
+		return provider
+	}
+	return &cachingProvider{
+		provider: provider,
+		cache:    c,
+		prefix:   hashKey(identity...) + "/",
+		refresh:  refresh,
+	}
+}
+
+// Transport returns an http.RoundTripper sending the requests of the SCM APIs with base, using conditional
+// requests for the responses which were already received, and waiting for exhausted rate limits.
+func (c *Cache) Transport(base http.RoundTripper) http.RoundTripper {
+	if c == nil {
+		return base
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &cachingTransport{base: base, cache: c}
+}
+
+// HTTPClient returns an http.Client using Transport.
+func (c *Cache) HTTPClient() *http.Client {
+	return &http.Client{Transport: c.Transport(http.DefaultTransport)}
+}
+
+func hashKey(parts ...string) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		_, _ = fmt.Fprintf(hash, "%d:%s", len(part), part)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+type cachingProvider struct {
+	provider SCMProviderService
+	cache    *Cache
+	prefix   string
+	refresh  bool
+}
+
+var _ SCMProviderService = &cachingProvider{}
+
+func (p *cachingProvider) get(key string) (interface{}, bool) {
+	if p.refresh || p.cache.ttl <= 0 {
+		return nil, false
+	}
+	return p.cache.results.Get(p.prefix + key)
+}
+
+func (p *cachingProvider) set(key string, value interface{}, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	p.cache.results.Set(p.prefix+key, value, ttl)
+}
+
+func (p *cachingProvider) ListRepos(ctx context.Context, cloneProtocol string) ([]*Repository, error) {
+	key := "repos/" + cloneProtocol
+	if repos, ok := p.get(key); ok {
+		return copyRepositories(repos.([]*Repository)), nil
+	}
+	repos, err := p.provider.ListRepos(ctx, cloneProtocol)
+	if err != nil {
+		return nil, err
+	}
+	p.set(key, copyRepositories(repos), p.cache.ttl)
+	return repos, nil
+}
+
+func (p *cachingProvider) GetBranches(ctx context.Context, repo *Repository) ([]*Repository, error) {
+	key := fmt.Sprintf("branches/%s/%s/%s/%s", repo.Organization, repo.Repository, repo.URL, repo.Branch)
+	if repos, ok := p.get(key); ok {
+		return copyRepositories(repos.([]*Repository)), nil
+	}
+	repos, err := p.provider.GetBranches(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	p.set(key, copyRepositories(repos), p.cache.ttl)
+	return repos, nil
+}
+
+func (p *cachingProvider) RepoHasPath(ctx context.Context, repo *Repository, path string) (bool, error) {
+	// The paths of a commit never change, unlike those of a branch
+	key := fmt.Sprintf("path/%s/%s/%s/%s", repo.Organization, repo.Repository, repo.Branch, path)
+	ttl := p.cache.ttl
+	if repo.SHA != "" {
+		key = fmt.Sprintf("path/%s/%s/%s/%s", repo.Organization, repo.Repository, repo.SHA, path)
+		ttl = immutableResultExpiration
+	}
+	if hasPath, ok := p.get(key); ok {
+		return hasPath.(bool), nil
+	}
+	hasPath, err := p.provider.RepoHasPath(ctx, repo, path)
+	if err != nil {
+		return false, err
+	}
+	p.set(key, hasPath, ttl)
+	return hasPath, nil
+}
+
+// copyRepositories copies repositories, so that the cached repositories aren't modified by their users.
+func copyRepositories(repos []*Repository) []*Repository {
+	res := make([]*Repository, 0, len(repos))
+	for _, repo := range repos {
+		repoCopy := *repo
+		repoCopy.Labels = append([]string(nil), repo.Labels...)
+		res = append(res, &repoCopy)
+	}
+	return res
+}
+
+// cachedResponse is a response of an SCM API, with the validators of its conditional requests
+type cachedResponse struct {
+	header http.Header
+	body   []byte
+}
+
+type cachingTransport struct {
+	base  http.RoundTripper
+	cache *Cache
+}
+
+var _ http.RoundTripper = &cachingTransport{}
+
+func (t *cachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return t.base.RoundTrip(req)
+	}
+
+	// The responses and rate limits depend on the credentials of the request, which are hashed
+	limitKey := hashKey(req.URL.Host, req.Header.Get("Authorization"), req.Header.Get("Private-Token"), req.Header.Get("Job-Token"))
+	responseKey := hashKey(limitKey, req.Method, req.URL.String(), req.Header.Get("Accept"))
+
+	for attempt := 0; ; attempt++ {
+		if err := t.cache.waitForRateLimit(req.Context(), limitKey, req.URL.Host); err != nil {
+			return nil, err
+		}
+
+		outReq := req
+		cached, hasCached := t.cache.responses.Get(responseKey)
+		if hasCached {
+			outReq = req.Clone(req.Context())
+			if etag := cached.(*cachedResponse).header.Get("ETag"); etag != "" {
+				outReq.Header.Set("If-None-Match", etag)
+			}
+			if lastModified := cached.(*cachedResponse).header.Get("Last-Modified"); lastModified != "" {
+				outReq.Header.Set("If-Modified-Since", lastModified)
+			}
+		}
+
+		resp, err := t.base.RoundTrip(outReq)
+		if err != nil {
+			return nil, err
+		}
+
+		if wait, limited := rateLimitWait(resp, attempt); limited {
+			t.cache.setRateLimitReset(limitKey, time.Now().Add(wait))
+			if attempt < maxRateLimitRetries && wait <= t.cache.maxRateLimitWait {
+				log.Infof("Rate limit of %s exceeded, retrying in %s", req.URL.Host, wait)
+				_, _ = ioutil.ReadAll(resp.Body)
+				_ = resp.Body.Close()
+				continue
+			}
+			return resp, nil
+		}
+		t.cache.updateRateLimit(limitKey, resp.Header)
+
+		if resp.StatusCode == http.StatusNotModified && hasCached {
+			_ = resp.Body.Close()
+			return cached.(*cachedResponse).response(req, resp.Header), nil
+		}
+		if resp.StatusCode == http.StatusOK && (resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != "") {
+			body, err := ioutil.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			t.cache.responses.SetDefault(responseKey, &cachedResponse{header: resp.Header.Clone(), body: body})
+			resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		return resp, nil
+	}
+}
+
+// response returns the cached response, as a response to req, with the headers of the 304 response which
+// revalidated it (such as its current rate limit).
+func (r *cachedResponse) response(req *http.Request, notModifiedHeader http.Header) *http.Response {
+	header := r.header.Clone()
+	for key, values := range notModifiedHeader {
+		header[key] = values
+	}
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(r.body)),
+		ContentLength: int64(len(r.body)),
+		Request:       req,
+	}
+}
+
+// waitForRateLimit waits for the exhausted rate limit of limitKey, if any, to be reset, or fails if it's reset
+// after maxRateLimitWait or the cancellation of ctx.
+func (c *Cache) waitForRateLimit(ctx context.Context, limitKey string, host string) error {
+	c.rateLimitsMu.Lock()
+	reset, limited := c.rateLimits[limitKey]
+	c.rateLimitsMu.Unlock()
+	if !limited {
+		return nil
+	}
+	wait := time.Until(reset)
+	if wait <= 0 {
+		return nil
+	}
+	if wait > c.maxRateLimitWait {
+		return fmt.Errorf("rate limit of %s exceeded until %s", host, reset.Format(time.RFC3339))
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Cache) setRateLimitReset(limitKey string, reset time.Time) {
+	c.rateLimitsMu.Lock()
+	defer c.rateLimitsMu.Unlock()
+	c.rateLimits[limitKey] = reset
+}
+
+// updateRateLimit records the rate limit reported by the headers of a response: when it is exhausted, the
+// following requests wait for its reset.
+func (c *Cache) updateRateLimit(limitKey string, header http.Header) {
+	remaining, reset, ok := parseRateLimit(header)
+	c.rateLimitsMu.Lock()
+	defer c.rateLimitsMu.Unlock()
+	if ok && remaining <= 0 {
+		c.rateLimits[limitKey] = reset
+	} else {
+		delete(c.rateLimits, limitKey)
+	}
+}
+
+// parseRateLimit returns the remaining requests and the reset time of the rate limit reported by the headers of a
+// response, using the headers of GitHub and Bitbucket (X-RateLimit-*) or GitLab (RateLimit-*).
+func parseRateLimit(header http.Header) (int, time.Time, bool) {
+	for _, prefix := range []string{"X-RateLimit-", "RateLimit-"} {
+		remaining, err := strconv.Atoi(header.Get(prefix + "Remaining"))
+		if err != nil {
+			continue
+		}
+		reset, err := strconv.ParseInt(header.Get(prefix+"Reset"), 10, 64)
+		if err != nil {
+			continue
+		}
+		return remaining, time.Unix(reset, 0), true
+	}
+	return 0, time.Time{}, false
+}
+
+// rateLimitWait returns whether the response reports that the request was rate limited, and how long to wait
+// before retrying it: until the time given by the Retry-After header or the reset of the rate limit, or else
+// exponentially longer with each attempt.
+func rateLimitWait(resp *http.Response, attempt int) (time.Duration, bool) {
+	retryAfter := resp.Header.Get("Retry-After")
+	remaining, reset, hasRateLimit := parseRateLimit(resp.Header)
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+	// GitHub reports exceeded rate limits as 403 errors
+	case resp.StatusCode == http.StatusForbidden && (retryAfter != "" || (hasRateLimit && remaining <= 0)):
+	default:
+		return 0, false
+	}
+
+	if retryAfter != "" {
+		if seconds, err := strconv.Atoi(strings.TrimSpace(retryAfter)); err == nil {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if date, err := http.ParseTime(retryAfter); err == nil {
+			return nonNegative(time.Until(date)), true
+		}
+	}
+	if hasRateLimit && remaining <= 0 {
+		return nonNegative(time.Until(reset)), true
+	}
+	return time.Second << attempt, true
+}
+
+func nonNegative(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return d
+}
@@ -0,0 +1,297 @@
+package scm_provider
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingProvider counts the calls to a provider, and fails them while err is set
+type countingProvider struct {
+	SCMProviderService
+	calls int
+	err   error
+}
+
+func (p *countingProvider) ListRepos(ctx context.Context, cloneProtocol string) ([]*Repository, error) {
+	p.calls++
+	if p.err != nil {
+		return nil, p.err
+	}
+	return p.SCMProviderService.ListRepos(ctx, cloneProtocol)
+}
+
+func (p *countingProvider) GetBranches(ctx context.Context, repo *Repository) ([]*Repository, error) {
+	p.calls++
+	return p.SCMProviderService.GetBranches(ctx, repo)
+}
+
+func (p *countingProvider) RepoHasPath(ctx context.Context, repo *Repository, path string) (bool, error) {
+	p.calls++
+	return p.SCMProviderService.RepoHasPath(ctx, repo, path)
+}
+
+func TestCachingProvider(t *testing.T) {
+	newProvider := func() *countingProvider {
+		return &countingProvider{SCMProviderService: &MockProvider{
+			Repos: []*Repository{
+				{Organization: "org", Repository: "repo", Branch: "main", SHA: "sha", Labels: []string{"label"}},
+			},
+		}}
+	}
+	ctx := context.Background()
+
+	t.Run("results are cached", func(t *testing.T) {
+		cache := NewCache(time.Minute, time.Minute)
+		provider := newProvider()
+		cached := cache.Wrap(provider, false, "github", "org", "token")
+
+		for i := 0; i < 2; i++ {
+			repos, err := cached.ListRepos(ctx, "https")
+			assert.NoError(t, err)
+			assert.Len(t, repos, 1)
+			// Cached results aren't modified by their users (the first results are those of the provider)
+			if i > 0 {
+				repos[0].Repository = "modified"
+			}
+		}
+		repos, err := cached.ListRepos(ctx, "https")
+		assert.NoError(t, err)
+		assert.Equal(t, "repo", repos[0].Repository)
+		assert.Equal(t, 1, provider.calls)
+
+		_, err = cached.ListRepos(ctx, "ssh")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, provider.calls, "other clone protocol")
+
+		repo := &Repository{Organization: "org", Repository: "repo", Branch: "main"}
+		for i := 0; i < 2; i++ {
+			branches, err := cached.GetBranches(ctx, repo)
+			assert.NoError(t, err)
+			assert.Len(t, branches, 1)
+			hasPath, err := cached.RepoHasPath(ctx, branches[0], "repo")
+			assert.NoError(t, err)
+			assert.True(t, hasPath)
+		}
+		assert.Equal(t, 4, provider.calls)
+	})
+
+	t.Run("results are not shared between identities", func(t *testing.T) {
+		cache := NewCache(time.Minute, time.Minute)
+		provider := newProvider()
+
+		_, err := cache.Wrap(provider, false, "github", "org", "token").ListRepos(ctx, "https")
+		assert.NoError(t, err)
+		_, err = cache.Wrap(provider, false, "github", "org", "other-token").ListRepos(ctx, "https")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, provider.calls)
+	})
+
+	t.Run("refresh ignores cached results", func(t *testing.T) {
+		cache := NewCache(time.Minute, time.Minute)
+		provider := newProvider()
+
+		_, err := cache.Wrap(provider, false, "github", "org").ListRepos(ctx, "https")
+		assert.NoError(t, err)
+		_, err = cache.Wrap(provider, true, "github", "org").ListRepos(ctx, "https")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, provider.calls)
+		_, err = cache.Wrap(provider, false, "github", "org").ListRepos(ctx, "https")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, provider.calls)
+	})
+
+	t.Run("errors are not cached", func(t *testing.T) {
+		cache := NewCache(time.Minute, time.Minute)
+		provider := newProvider()
+		cached := cache.Wrap(provider, false, "github", "org")
+
+		provider.err = errors.New("error")
+		_, err := cached.ListRepos(ctx, "https")
+		assert.EqualError(t, err, "error")
+		provider.err = nil
+		repos, err := cached.ListRepos(ctx, "https")
+		assert.NoError(t, err)
+		assert.Len(t, repos, 1)
+		assert.Equal(t, 2, provider.calls)
+	})
+
+	t.Run("no TTL", func(t *testing.T) {
+		cache := NewCache(0, time.Minute)
+		provider := newProvider()
+		cached := cache.Wrap(provider, false, "github", "org")
+
+		_, err := cached.ListRepos(ctx, "https")
+		assert.NoError(t, err)
+		_, err = cached.ListRepos(ctx, "https")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, provider.calls)
+	})
+
+	t.Run("nil cache", func(t *testing.T) {
+		var cache *Cache
+		provider := newProvider()
+		assert.Equal(t, provider, cache.Wrap(provider, false, "github", "org"))
+		assert.Equal(t, http.DefaultTransport, cache.Transport(http.DefaultTransport))
+	})
+}
+
+func TestCachingTransportConditionalRequests(t *testing.T) {
+	requests := 0
+	notModified := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		etag := `"` + r.Header.Get("Authorization") + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.Header().Set("X-RateLimit-Remaining", "10")
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		_, _ = w.Write([]byte("body of " + r.Header.Get("Authorization")))
+	}))
+	defer ts.Close()
+
+	client := NewCache(time.Minute, time.Minute).HTTPClient()
+	get := func(authorization string) (*http.Response, string) {
+		req, err := http.NewRequest("GET", ts.URL+"/repos", nil)
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", authorization)
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return resp, string(body)
+	}
+
+	resp, body := get("token-a")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "body of token-a", body)
+
+	resp, body = get("token-a")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "body of token-a", body)
+	assert.Equal(t, "10", resp.Header.Get("X-RateLimit-Remaining"), "headers of the 304 response")
+	assert.Equal(t, 1, notModified)
+
+	// The responses of other credentials are not reused
+	_, body = get("token-b")
+	assert.Equal(t, "body of token-b", body)
+	assert.Equal(t, 1, notModified)
+	assert.Equal(t, 3, requests)
+}
+
+func TestCachingTransportRateLimit(t *testing.T) {
+	t.Run("retry after a rate-limited request", func(t *testing.T) {
+		requests := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests == 1 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			_, _ = w.Write([]byte("ok"))
+		}))
+		defer ts.Close()
+
+		resp, err := NewCache(time.Minute, time.Minute).HTTPClient().Get(ts.URL)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, 2, requests)
+	})
+
+	t.Run("exhausted rate limit", func(t *testing.T) {
+		requests := 0
+		reset := time.Now().Add(time.Hour)
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		defer ts.Close()
+
+		client := NewCache(time.Minute, time.Minute).HTTPClient()
+		// The reset is after the maximum wait, so the request isn't retried
+		resp, err := client.Get(ts.URL)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		assert.Equal(t, 1, requests)
+
+		// The following requests fail until the reset, without being sent
+		_, err = client.Get(ts.URL + "/other")
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "rate limit of "+ts.Listener.Addr().String()+" exceeded until")
+		assert.Equal(t, 1, requests)
+	})
+
+	t.Run("wait for the reset of the rate limit", func(t *testing.T) {
+		requests := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests == 1 {
+				w.Header().Set("X-RateLimit-Remaining", "0")
+				w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Unix(), 10))
+			}
+			_, _ = w.Write([]byte("ok"))
+		}))
+		defer ts.Close()
+
+		client := NewCache(time.Minute, time.Minute).HTTPClient()
+		for i := 0; i < 2; i++ {
+			resp, err := client.Get(ts.URL)
+			assert.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		}
+		assert.Equal(t, 2, requests)
+	})
+}
+
+func TestRateLimitWait(t *testing.T) {
+	newResponse := func(statusCode int, header map[string]string) *http.Response {
+		resp := &http.Response{StatusCode: statusCode, Header: http.Header{}}
+		for k, v := range header {
+			resp.Header.Set(k, v)
+		}
+		return resp
+	}
+
+	_, limited := rateLimitWait(newResponse(http.StatusOK, nil), 0)
+	assert.False(t, limited)
+	_, limited = rateLimitWait(newResponse(http.StatusForbidden, map[string]string{"X-RateLimit-Remaining": "10", "X-RateLimit-Reset": "0"}), 0)
+	assert.False(t, limited, "403 for another reason than the rate limit")
+
+	wait, limited := rateLimitWait(newResponse(http.StatusTooManyRequests, map[string]string{"Retry-After": "30"}), 0)
+	assert.True(t, limited)
+	assert.Equal(t, 30*time.Second, wait)
+
+	wait, limited = rateLimitWait(newResponse(http.StatusForbidden, map[string]string{
+		"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10),
+	}), 0)
+	assert.True(t, limited)
+	assert.InDelta(t, time.Hour.Seconds(), wait.Seconds(), 5)
+
+	wait, limited = rateLimitWait(newResponse(http.StatusTooManyRequests, map[string]string{
+		"RateLimit-Remaining": "0", "RateLimit-Reset": strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10),
+	}), 0)
+	assert.True(t, limited)
+	assert.Equal(t, time.Duration(0), wait, "GitLab rate limit which was already reset")
+
+	wait, limited = rateLimitWait(newResponse(http.StatusTooManyRequests, nil), 2)
+	assert.True(t, limited)
+	assert.Equal(t, 4*time.Second, wait)
+}
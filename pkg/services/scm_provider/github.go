@@ -4,10 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 
 	"github.com/google/go-github/v35/github"
 	"golang.org/x/oauth2"
+
+	"github.com/argoproj/applicationset/pkg/services/github_app_auth"
 )
 
 type GithubProvider struct {
@@ -18,7 +21,9 @@ type GithubProvider struct {
 
 var _ SCMProviderService = &GithubProvider{}
 
-func NewGithubProvider(ctx context.Context, organization string, token string, url string, allBranches bool) (*GithubProvider, error) {
+// NewGithubProvider returns a provider listing the repositories of a GitHub organization. Its requests use cache,
+// if not nil.
+func NewGithubProvider(ctx context.Context, organization string, token string, url string, allBranches bool, cache *Cache) (*GithubProvider, error) {
 	var ts oauth2.TokenSource
 	// Undocumented environment variable to set a default token, to be used in testing to dodge anonymous rate limits.
 	if token == "" {
@@ -29,7 +34,26 @@ func NewGithubProvider(ctx context.Context, organization string, token string, u
 			&oauth2.Token{AccessToken: token},
 		)
 	}
-	httpClient := oauth2.NewClient(ctx, ts)
+	// The token is added by the oauth2 transport, on top of the caching transport, which can then tell apart the
+	// responses of different tokens
+	httpClient := oauth2.NewClient(context.WithValue(ctx, oauth2.HTTPClient, cache.HTTPClient()), ts)
+	return newGithubProvider(httpClient, organization, url, allBranches)
+}
+
+// NewGithubAppProvider returns a provider listing the repositories of a GitHub organization, authenticated as the
+// installation of a GitHub App, with a transport of transports.
+func NewGithubAppProvider(ctx context.Context, auth github_app_auth.Authentication, transports *github_app_auth.Transports, organization string, url string, allBranches bool) (*GithubProvider, error) {
+	tr, err := transports.Transport(auth, url)
+	if err != nil {
+		return nil, err
+	}
+	if url == "" {
+		url = auth.EnterpriseBaseURL
+	}
+	return newGithubProvider(&http.Client{Transport: tr}, organization, url, allBranches)
+}
+
+func newGithubProvider(httpClient *http.Client, organization string, url string, allBranches bool) (*GithubProvider, error) {
 	var client *github.Client
 	if url == "" {
 		client = github.NewClient(httpClient)
@@ -17,7 +17,8 @@ type GitlabProvider struct {
 
 var _ SCMProviderService = &GitlabProvider{}
 
-func NewGitlabProvider(ctx context.Context, organization string, token string, url string, allBranches, includeSubgroups bool) (*GitlabProvider, error) {
+// NewGitlabProvider returns a provider listing the projects of a GitLab group. Its requests use cache, if not nil.
+func NewGitlabProvider(ctx context.Context, organization string, token string, url string, allBranches, includeSubgroups bool, cache *Cache) (*GitlabProvider, error) {
 	// Undocumented environment variable to set a default token, to be used in testing to dodge anonymous rate limits.
 	if token == "" {
 		token = os.Getenv("GITLAB_TOKEN")
@@ -25,13 +26,13 @@ func NewGitlabProvider(ctx context.Context, organization string, token string, u
 	var client *gitlab.Client
 	if url == "" {
 		var err error
-		client, err = gitlab.NewClient(token)
+		client, err = gitlab.NewClient(token, gitlab.WithHTTPClient(cache.HTTPClient()))
 		if err != nil {
 			return nil, err
 		}
 	} else {
 		var err error
-		client, err = gitlab.NewClient(token, gitlab.WithBaseURL(url))
+		client, err = gitlab.NewClient(token, gitlab.WithBaseURL(url), gitlab.WithHTTPClient(cache.HTTPClient()))
 		if err != nil {
 			return nil, err
 		}
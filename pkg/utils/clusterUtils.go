@@ -11,6 +11,7 @@ import (
 
 	"github.com/argoproj/argo-cd/v2/common"
 	appv1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/argoproj/argo-cd/v2/util/collections"
 	log "github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -136,14 +137,31 @@ func secretToCluster(s *corev1.Secret) (*appv1.Cluster, error) {
 			shard = pointer.Int64Ptr(int64(val))
 		}
 	}
+
+	// copy labels and annotations excluding system ones
+	labels := map[string]string{}
+	if s.Labels != nil {
+		labels = collections.CopyStringMap(s.Labels)
+		delete(labels, common.LabelKeySecretType)
+	}
+	annotations := map[string]string{}
+	if s.Annotations != nil {
+		annotations = collections.CopyStringMap(s.Annotations)
+		delete(annotations, common.AnnotationKeyManagedBy)
+	}
+
 	cluster := appv1.Cluster{
 		ID:                 string(s.UID),
 		Server:             strings.TrimRight(string(s.Data["server"]), "/"),
 		Name:               string(s.Data["name"]),
 		Namespaces:         namespaces,
+		ClusterResources:   string(s.Data["clusterResources"]) == "true",
 		Config:             config,
 		RefreshRequestedAt: refreshRequestedAt,
 		Shard:              shard,
+		Project:            string(s.Data["project"]),
+		Labels:             labels,
+		Annotations:        annotations,
 	}
 	return &cluster, nil
 }
@@ -21,15 +21,22 @@ const (
 
 // From Argo CD util/db/cluster_test.go
 func Test_secretToCluster(t *testing.T) {
+	labels := map[string]string{"key1": "val1"}
+	annotations := map[string]string{"key2": "val2"}
 	secret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "mycluster",
-			Namespace: fakeNamespace,
+			Name:        "mycluster",
+			Namespace:   fakeNamespace,
+			Labels:      labels,
+			Annotations: annotations,
 		},
 		Data: map[string][]byte{
-			"name":   []byte("test"),
-			"server": []byte("http://mycluster"),
-			"config": []byte("{\"username\":\"foo\"}"),
+			"name":             []byte("test"),
+			"server":           []byte("http://mycluster"),
+			"config":           []byte("{\"username\":\"foo\"}"),
+			"project":          []byte("project"),
+			"namespaces":       []byte("ns1, ns2"),
+			"clusterResources": []byte("true"),
 		},
 	}
 	cluster, err := secretToCluster(secret)
@@ -40,6 +47,11 @@ func Test_secretToCluster(t *testing.T) {
 		Config: argoappv1.ClusterConfig{
 			Username: "foo",
 		},
+		Project:          "project",
+		Namespaces:       []string{"ns1", "ns2"},
+		ClusterResources: true,
+		Labels:           labels,
+		Annotations:      annotations,
 	})
 }
 
@@ -58,8 +70,10 @@ func Test_secretToCluster_NoConfig(t *testing.T) {
 	cluster, err := secretToCluster(secret)
 	assert.Nil(t, err)
 	assert.Equal(t, *cluster, argoappv1.Cluster{
-		Name:   "test",
-		Server: "http://mycluster",
+		Name:        "test",
+		Server:      "http://mycluster",
+		Labels:      map[string]string{},
+		Annotations: map[string]string{},
 	})
 }
 
@@ -0,0 +1,374 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/itchyny/gojq"
+
+	argoprojiov1alpha1 "github.com/argoproj/applicationset/api/v1alpha1"
+)
+
+const (
+	// jqEvaluationTimeout bounds the evaluation of each jq path expression, which is written by the author of the
+	// ApplicationSet and may not terminate (e.g. 'repeat(.)')
+	jqEvaluationTimeout = time.Second
+	// maxJQPaths is the maximum number of paths which a jq path expression may select
+	maxJQPaths = 1000
+)
+
+// ApplyIgnoreDifferences preserves the fields of the live Application which are selected by the ignore rules (for
+// the Application's name) in the desired Application: selected fields which exist in live are copied to desired,
+// and those which don't are removed from desired. The array elements selected by jq path expressions are matched by
+// their selection rather than by their index (see preserveJQPaths). Only the spec, labels, annotations and finalizers
+// of desired are modified.
+func ApplyIgnoreDifferences(ignoreDifferences []argoprojiov1alpha1.ApplicationSetResourceIgnoreDifferences, live *argov1alpha1.Application, desired *argov1alpha1.Application) error {
+	var rules []argoprojiov1alpha1.ApplicationSetResourceIgnoreDifferences
+	for _, rule := range ignoreDifferences {
+		if rule.Name == "" || rule.Name == desired.Name {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	liveObj, err := toJSONObject(live)
+	if err != nil {
+		return err
+	}
+	desiredObj, err := toJSONObject(desired)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		for _, pointer := range rule.JSONPointers {
+			path, err := parseJSONPointer(pointer)
+			if err != nil {
+				return err
+			}
+			desiredObj = preservePath(liveObj, desiredObj, path)
+		}
+		for _, expression := range rule.JQPathExpressions {
+			// Fields which only exist in desired are selected too, so that they are removed
+			livePaths, err := evaluateJQPaths(expression, liveObj)
+			if err != nil {
+				return err
+			}
+			desiredPaths, err := evaluateJQPaths(expression, desiredObj)
+			if err != nil {
+				return err
+			}
+			desiredObj = preserveJQPaths(liveObj, desiredObj, livePaths, desiredPaths)
+		}
+	}
+
+	bytes, err := json.Marshal(desiredObj)
+	if err != nil {
+		return err
+	}
+	preserved := argov1alpha1.Application{}
+	if err := json.Unmarshal(bytes, &preserved); err != nil {
+		return fmt.Errorf("Application is invalid after preserving ignored differences: %w", err)
+	}
+
+	desired.Spec = preserved.Spec
+	desired.Labels = preserved.Labels
+	desired.Annotations = preserved.Annotations
+	desired.Finalizers = preserved.Finalizers
+	return nil
+}
+
+func toJSONObject(app *argov1alpha1.Application) (interface{}, error) {
+	bytes, err := json.Marshal(app)
+	if err != nil {
+		return nil, err
+	}
+	var res interface{}
+	err = json.Unmarshal(bytes, &res)
+	return res, err
+}
+
+// parseJSONPointer returns the path of the RFC 6901 JSON pointer. Array indices are returned as strings, and are
+// interpreted when the path is resolved.
+func parseJSONPointer(pointer string) ([]interface{}, error) {
+	if pointer == "" {
+		return nil, fmt.Errorf("JSON pointer must not be empty")
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("JSON pointer '%s' must start with '/'", pointer)
+	}
+	path := []interface{}{}
+	for _, token := range strings.Split(pointer[1:], "/") {
+		path = append(path, strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~"))
+	}
+	return path, nil
+}
+
+// evaluateJQPaths returns the paths of the values selected by the jq path expression in obj. The evaluation fails
+// if it takes longer than jqEvaluationTimeout, or selects more than maxJQPaths paths.
+func evaluateJQPaths(expression string, obj interface{}) ([][]interface{}, error) {
+	query, err := gojq.Parse(fmt.Sprintf("path(%s)", expression))
+	if err != nil {
+		return nil, fmt.Errorf("invalid jq path expression '%s': %w", expression, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), jqEvaluationTimeout)
+	defer cancel()
+
+	res := [][]interface{}{}
+	iter := query.RunWithContext(ctx, obj)
+	for {
+		if len(res) > maxJQPaths {
+			return nil, fmt.Errorf("jq path expression '%s' selects more than %d paths", expression, maxJQPaths)
+		}
+		v, ok := iter.Next()
+		if !ok {
+			break
+		}
+		if err, isErr := v.(error); isErr {
+			return nil, fmt.Errorf("unable to evaluate jq path expression '%s': %w", expression, err)
+		}
+		if path, isPath := v.([]interface{}); isPath && len(path) > 0 {
+			res = append(res, path)
+		}
+	}
+	return res, nil
+}
+
+// preserveJQPaths preserves in desired the values selected by a jq path expression in live. The same element of an
+// array may be at different indices in live and desired (e.g. for
+// '.spec.source.helm.parameters[] | select(.name == "image.tag")' when the parameters are in a different order), so
+// the paths are not matched by index: the n-th path selected in live is matched with the n-th path selected in
+// desired with the same keys, whatever their array indices, and the value of the live path is set at the desired
+// path. Desired paths without a live match are removed from desired. Live paths without a desired match are set in
+// desired if they don't go through an array, and appended to the array if they select a whole element of a top-level
+// array; otherwise the element they belong to doesn't exist in desired, and they are left out. Returns the updated
+// desired.
+func preserveJQPaths(live interface{}, desired interface{}, livePaths [][]interface{}, desiredPaths [][]interface{}) interface{} {
+	livePaths = distinctPaths(livePaths)
+	liveByShape := map[string][][]interface{}{}
+	for _, path := range livePaths {
+		shape := pathShape(path)
+		liveByShape[shape] = append(liveByShape[shape], path)
+	}
+
+	matched := map[string]int{}
+	removed := [][]interface{}{}
+	for _, path := range distinctPaths(desiredPaths) {
+		shape := pathShape(path)
+		if matched[shape] < len(liveByShape[shape]) {
+			value, _ := getPath(live, liveByShape[shape][matched[shape]])
+			desired = setPath(desired, path, value)
+			matched[shape]++
+		} else {
+			removed = append(removed, path)
+		}
+	}
+
+	// Removing the elements of an array in descending order does not shift the indices of those removed next
+	sort.SliceStable(removed, func(i, j int) bool {
+		return comparePaths(removed[i], removed[j]) > 0
+	})
+	for _, path := range removed {
+		desired = deletePath(desired, path)
+	}
+
+	seen := map[string]int{}
+	for _, path := range livePaths {
+		shape := pathShape(path)
+		seen[shape]++
+		if seen[shape] <= matched[shape] {
+			continue
+		}
+		value, _ := getPath(live, path)
+		switch indices := arrayIndices(path); {
+		case len(indices) == 0:
+			desired = setPath(desired, path, value)
+		case len(indices) == 1 && indices[0] == len(path)-1:
+			desired = setPath(desired, append(path[:len(path)-1:len(path)-1], "-"), value)
+		}
+	}
+	return desired
+}
+
+// distinctPaths returns the paths without duplicates, in their order.
+func distinctPaths(paths [][]interface{}) [][]interface{} {
+	res := [][]interface{}{}
+	seen := map[string]bool{}
+	for _, path := range paths {
+		key := fmt.Sprintf("%#v", path)
+		if !seen[key] {
+			seen[key] = true
+			res = append(res, path)
+		}
+	}
+	return res
+}
+
+// pathShape returns the keys of the path, with its array indices left out, so that the paths of the same field of
+// different array elements have the same shape.
+func pathShape(path []interface{}) string {
+	shape := make([]string, 0, len(path))
+	for _, element := range path {
+		if key, isKey := element.(string); isKey {
+			shape = append(shape, strconv.Quote(key))
+		} else {
+			shape = append(shape, "[]")
+		}
+	}
+	return strings.Join(shape, ".")
+}
+
+// arrayIndices returns the positions of the array indices of the path.
+func arrayIndices(path []interface{}) []int {
+	var res []int
+	for i, element := range path {
+		if _, isKey := element.(string); !isKey {
+			res = append(res, i)
+		}
+	}
+	return res
+}
+
+// comparePaths compares two paths element by element: array indices numerically, and keys lexically.
+func comparePaths(a []interface{}, b []interface{}) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		ai, aIsIndex := arrayIndex(a[i], 0)
+		bi, bIsIndex := arrayIndex(b[i], 0)
+		_, aIsKey := a[i].(string)
+		_, bIsKey := b[i].(string)
+		switch {
+		case aIsIndex && bIsIndex && !aIsKey && !bIsKey:
+			if ai != bi {
+				return ai - bi
+			}
+		default:
+			if c := strings.Compare(fmt.Sprint(a[i]), fmt.Sprint(b[i])); c != 0 {
+				return c
+			}
+		}
+	}
+	return len(a) - len(b)
+}
+
+// preservePath sets the value at path in desired to the value at path in live, or removes it from desired if it does
+// not exist in live. Returns the updated desired.
+func preservePath(live interface{}, desired interface{}, path []interface{}) interface{} {
+	if value, exists := getPath(live, path); exists {
+		return setPath(desired, path, value)
+	}
+	return deletePath(desired, path)
+}
+
+// arrayIndex returns the index of the path element within an array of the given length.
+func arrayIndex(key interface{}, length int) (int, bool) {
+	switch k := key.(type) {
+	case int:
+		return k, k >= 0
+	case float64:
+		return int(k), k >= 0
+	case string:
+		if k == "-" {
+			return length, true
+		}
+		i, err := strconv.Atoi(k)
+		return i, err == nil && i >= 0
+	}
+	return 0, false
+}
+
+func getPath(obj interface{}, path []interface{}) (interface{}, bool) {
+	if len(path) == 0 {
+		return obj, true
+	}
+	switch o := obj.(type) {
+	case map[string]interface{}:
+		key, isString := path[0].(string)
+		if !isString {
+			return nil, false
+		}
+		value, exists := o[key]
+		if !exists {
+			return nil, false
+		}
+		return getPath(value, path[1:])
+	case []interface{}:
+		i, valid := arrayIndex(path[0], len(o))
+		if !valid || i >= len(o) {
+			return nil, false
+		}
+		return getPath(o[i], path[1:])
+	}
+	return nil, false
+}
+
+func setPath(obj interface{}, path []interface{}, value interface{}) interface{} {
+	if len(path) == 0 {
+		return value
+	}
+	switch o := obj.(type) {
+	case []interface{}:
+		// Elements may only be appended, so that an index beyond the end of the array doesn't pad it with nulls
+		i, valid := arrayIndex(path[0], len(o))
+		if !valid || i > len(o) {
+			return obj
+		}
+		if i == len(o) {
+			o = append(o, nil)
+		}
+		o[i] = setPath(o[i], path[1:], value)
+		return o
+	case map[string]interface{}:
+		key, isString := path[0].(string)
+		if !isString {
+			return obj
+		}
+		o[key] = setPath(o[key], path[1:], value)
+		return o
+	case nil:
+		// Create the missing parent, as an array if the live path indexes one or appends to one
+		if key, isString := path[0].(string); !isString || key == "-" {
+			return setPath([]interface{}{}, path, value)
+		}
+		return setPath(map[string]interface{}{}, path, value)
+	}
+	return obj
+}
+
+func deletePath(obj interface{}, path []interface{}) interface{} {
+	if len(path) == 0 {
+		return obj
+	}
+	switch o := obj.(type) {
+	case map[string]interface{}:
+		key, isString := path[0].(string)
+		if !isString {
+			return obj
+		}
+		if len(path) == 1 {
+			delete(o, key)
+		} else if value, exists := o[key]; exists {
+			o[key] = deletePath(value, path[1:])
+		}
+		return o
+	case []interface{}:
+		i, valid := arrayIndex(path[0], len(o))
+		if !valid || i >= len(o) {
+			return obj
+		}
+		if len(path) == 1 {
+			return append(o[:i], o[i+1:]...)
+		}
+		o[i] = deletePath(o[i], path[1:])
+		return o
+	}
+	return obj
+}
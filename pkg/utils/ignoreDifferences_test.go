@@ -0,0 +1,268 @@
+package utils
+
+import (
+	"testing"
+
+	argoprojiov1alpha1 "github.com/argoproj/applicationset/api/v1alpha1"
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestApplyIgnoreDifferences(t *testing.T) {
+	live := argov1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app",
+			Annotations: map[string]string{"example.com/a": "live"},
+		},
+		Spec: argov1alpha1.ApplicationSpec{
+			Project: "live",
+			Source: argov1alpha1.ApplicationSource{
+				RepoURL:        "https://github.com/argoproj/argocd-example-apps",
+				TargetRevision: "live",
+				Helm: &argov1alpha1.ApplicationSourceHelm{
+					Parameters: []argov1alpha1.HelmParameter{
+						{Name: "image.tag", Value: "v2"},
+						{Name: "replicas", Value: "3"},
+					},
+				},
+			},
+			SyncPolicy: &argov1alpha1.SyncPolicy{
+				SyncOptions: []string{"CreateNamespace=true"},
+			},
+		},
+	}
+
+	desired := func() *argov1alpha1.Application {
+		return &argov1alpha1.Application{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "app",
+				Labels: map[string]string{"key": "value"},
+			},
+			Spec: argov1alpha1.ApplicationSpec{
+				Project: "desired",
+				Source: argov1alpha1.ApplicationSource{
+					RepoURL:        "https://github.com/argoproj/argocd-example-apps",
+					TargetRevision: "desired",
+					Helm: &argov1alpha1.ApplicationSourceHelm{
+						Parameters: []argov1alpha1.HelmParameter{
+							{Name: "image.tag", Value: "v1"},
+						},
+					},
+				},
+				SyncPolicy: &argov1alpha1.SyncPolicy{
+					Automated: &argov1alpha1.SyncPolicyAutomated{Prune: true},
+				},
+			},
+		}
+	}
+
+	for _, c := range []struct {
+		name              string
+		ignoreDifferences []argoprojiov1alpha1.ApplicationSetResourceIgnoreDifferences
+		expected          func(app *argov1alpha1.Application)
+		expectedError     string
+	}{
+		{
+			name:              "no rules",
+			ignoreDifferences: nil,
+			expected:          func(app *argov1alpha1.Application) {},
+		},
+		{
+			name: "rule for another application",
+			ignoreDifferences: []argoprojiov1alpha1.ApplicationSetResourceIgnoreDifferences{
+				{Name: "other", JSONPointers: []string{"/spec/project"}},
+			},
+			expected: func(app *argov1alpha1.Application) {},
+		},
+		{
+			name: "JSON pointers",
+			ignoreDifferences: []argoprojiov1alpha1.ApplicationSetResourceIgnoreDifferences{
+				{Name: "app", JSONPointers: []string{"/spec/project", "/metadata/annotations/example.com~1a"}},
+			},
+			expected: func(app *argov1alpha1.Application) {
+				app.Spec.Project = "live"
+				app.Annotations = map[string]string{"example.com/a": "live"}
+			},
+		},
+		{
+			name: "JSON pointer which does not exist in the live application",
+			ignoreDifferences: []argoprojiov1alpha1.ApplicationSetResourceIgnoreDifferences{
+				{JSONPointers: []string{"/spec/syncPolicy/automated", "/metadata/labels"}},
+			},
+			expected: func(app *argov1alpha1.Application) {
+				app.Spec.SyncPolicy.Automated = nil
+				app.Labels = nil
+			},
+		},
+		{
+			name: "JSON pointer to an array element",
+			ignoreDifferences: []argoprojiov1alpha1.ApplicationSetResourceIgnoreDifferences{
+				{JSONPointers: []string{"/spec/source/helm/parameters/1"}},
+			},
+			expected: func(app *argov1alpha1.Application) {
+				app.Spec.Source.Helm.Parameters = append(app.Spec.Source.Helm.Parameters, argov1alpha1.HelmParameter{Name: "replicas", Value: "3"})
+			},
+		},
+		{
+			name: "JQ path expressions",
+			ignoreDifferences: []argoprojiov1alpha1.ApplicationSetResourceIgnoreDifferences{
+				{JQPathExpressions: []string{
+					`.spec.source.helm.parameters[] | select(.name == "image.tag").value`,
+					`.spec.syncPolicy.syncOptions`,
+				}},
+			},
+			expected: func(app *argov1alpha1.Application) {
+				app.Spec.Source.Helm.Parameters[0].Value = "v2"
+				app.Spec.SyncPolicy.SyncOptions = []string{"CreateNamespace=true"}
+			},
+		},
+		{
+			name: "JQ path expression selecting fields which only exist in the desired application",
+			ignoreDifferences: []argoprojiov1alpha1.ApplicationSetResourceIgnoreDifferences{
+				{JQPathExpressions: []string{`.spec.syncPolicy.automated`, `.metadata.labels`}},
+			},
+			expected: func(app *argov1alpha1.Application) {
+				app.Spec.SyncPolicy.Automated = nil
+				app.Labels = nil
+			},
+		},
+		{
+			name: "JQ path expression selecting array elements",
+			ignoreDifferences: []argoprojiov1alpha1.ApplicationSetResourceIgnoreDifferences{
+				{JQPathExpressions: []string{`.spec.source.helm.parameters[]`}},
+			},
+			expected: func(app *argov1alpha1.Application) {
+				app.Spec.Source.Helm.Parameters = []argov1alpha1.HelmParameter{{Name: "image.tag", Value: "v2"}, {Name: "replicas", Value: "3"}}
+			},
+		},
+		{
+			name: "JQ path expression which does not terminate",
+			ignoreDifferences: []argoprojiov1alpha1.ApplicationSetResourceIgnoreDifferences{
+				{JQPathExpressions: []string{`repeat(.)`}},
+			},
+			expectedError: "context deadline exceeded",
+		},
+		{
+			name: "JQ path expression selecting too many paths",
+			ignoreDifferences: []argoprojiov1alpha1.ApplicationSetResourceIgnoreDifferences{
+				{JQPathExpressions: []string{`.spec.info[range(2000)]`}},
+			},
+			expectedError: "selects more than 1000 paths",
+		},
+		{
+			name: "invalid JSON pointer",
+			ignoreDifferences: []argoprojiov1alpha1.ApplicationSetResourceIgnoreDifferences{
+				{JSONPointers: []string{"spec/project"}},
+			},
+			expectedError: "JSON pointer 'spec/project' must start with '/'",
+		},
+		{
+			name: "invalid JQ path expression",
+			ignoreDifferences: []argoprojiov1alpha1.ApplicationSetResourceIgnoreDifferences{
+				{JQPathExpressions: []string{".spec["}},
+			},
+			expectedError: "invalid jq path expression '.spec['",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			got := desired()
+			err := ApplyIgnoreDifferences(c.ignoreDifferences, live.DeepCopy(), got)
+
+			if c.expectedError != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), c.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+
+			expected := desired()
+			c.expected(expected)
+			assert.Equal(t, expected, got)
+		})
+	}
+}
+
+func TestApplyIgnoreDifferencesArrayOrder(t *testing.T) {
+	newApp := func(parameters ...argov1alpha1.HelmParameter) *argov1alpha1.Application {
+		return &argov1alpha1.Application{
+			ObjectMeta: metav1.ObjectMeta{Name: "app"},
+			Spec: argov1alpha1.ApplicationSpec{
+				Source: argov1alpha1.ApplicationSource{
+					Helm: &argov1alpha1.ApplicationSourceHelm{Parameters: parameters},
+				},
+			},
+		}
+	}
+	// The parameters of the live Application are in a different order than those generated from the template
+	live := newApp(
+		argov1alpha1.HelmParameter{Name: "replicas", Value: "3"},
+		argov1alpha1.HelmParameter{Name: "env", Value: "prod"},
+		argov1alpha1.HelmParameter{Name: "image.tag", Value: "v2"},
+	)
+
+	for _, c := range []struct {
+		name       string
+		expression string
+		desired    *argov1alpha1.Application
+		expected   *argov1alpha1.Application
+	}{
+		{
+			name:       "field of the selected element",
+			expression: `.spec.source.helm.parameters[] | select(.name == "image.tag").value`,
+			desired: newApp(
+				argov1alpha1.HelmParameter{Name: "image.tag", Value: "v1"},
+				argov1alpha1.HelmParameter{Name: "replicas", Value: "1"},
+			),
+			expected: newApp(
+				argov1alpha1.HelmParameter{Name: "image.tag", Value: "v2"},
+				argov1alpha1.HelmParameter{Name: "replicas", Value: "1"},
+			),
+		},
+		{
+			name:       "field of an element which is not generated",
+			expression: `.spec.source.helm.parameters[] | select(.name == "image.tag").value`,
+			desired:    newApp(argov1alpha1.HelmParameter{Name: "replicas", Value: "1"}),
+			expected:   newApp(argov1alpha1.HelmParameter{Name: "replicas", Value: "1"}),
+		},
+		{
+			name:       "selected element",
+			expression: `.spec.source.helm.parameters[] | select(.name == "image.tag")`,
+			desired: newApp(
+				argov1alpha1.HelmParameter{Name: "image.tag", Value: "v1"},
+				argov1alpha1.HelmParameter{Name: "replicas", Value: "1"},
+			),
+			expected: newApp(
+				argov1alpha1.HelmParameter{Name: "image.tag", Value: "v2"},
+				argov1alpha1.HelmParameter{Name: "replicas", Value: "1"},
+			),
+		},
+		{
+			name:       "selected element which is not generated",
+			expression: `.spec.source.helm.parameters[] | select(.name == "image.tag")`,
+			desired:    newApp(argov1alpha1.HelmParameter{Name: "replicas", Value: "1"}),
+			expected: newApp(
+				argov1alpha1.HelmParameter{Name: "replicas", Value: "1"},
+				argov1alpha1.HelmParameter{Name: "image.tag", Value: "v2"},
+			),
+		},
+		{
+			name:       "selected elements which are not in the live Application",
+			expression: `.spec.source.helm.parameters[] | select(.name | startswith("debug."))`,
+			desired: newApp(
+				argov1alpha1.HelmParameter{Name: "debug.a", Value: "1"},
+				argov1alpha1.HelmParameter{Name: "replicas", Value: "1"},
+				argov1alpha1.HelmParameter{Name: "debug.b", Value: "1"},
+			),
+			expected: newApp(argov1alpha1.HelmParameter{Name: "replicas", Value: "1"}),
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			err := ApplyIgnoreDifferences([]argoprojiov1alpha1.ApplicationSetResourceIgnoreDifferences{
+				{JQPathExpressions: []string{c.expression}},
+			}, live.DeepCopy(), c.desired)
+			assert.NoError(t, err)
+			assert.Equal(t, c.expected, c.desired)
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// webhookQueueDepth is the number of webhook events waiting to be handled
+	webhookQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "applicationset_webhook_queue_depth",
+		Help: "Number of webhook events waiting to be handled.",
+	})
+	// webhookEvents counts the parsed webhook events, by whether they were queued, deduplicated with an identical
+	// queued event, or rejected because the queue was full
+	webhookEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "applicationset_webhook_events_total",
+		Help: "Number of webhook events received, by result (queued, deduplicated or rejected).",
+	}, []string{"result"})
+)
+
+func init() {
+	// Served with the metrics of the controller, on the address set by --metrics-addr
+	metrics.Registry.MustRegister(webhookQueueDepth, webhookEvents)
+}
@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"strings"
+
+	"github.com/argoproj/argo-cd/v2/util/glob"
+)
+
+// IsNamespaceAllowed returns true if ApplicationSets (and thus their Applications) in the given namespace may be
+// reconciled: the namespace must be the Argo CD namespace, or match one of the allowed namespaces, which may be glob
+// patterns (eg 'team-*').
+func IsNamespaceAllowed(namespace string, argoCDNamespace string, allowedNamespaces []string) bool {
+	if namespace == argoCDNamespace {
+		return true
+	}
+	for _, allowed := range allowedNamespaces {
+		if glob.Match(allowed, namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsNamespaceGlob returns true if the allowed namespace is a glob pattern, rather than the name of a namespace.
+func IsNamespaceGlob(allowedNamespace string) bool {
+	return strings.ContainsAny(allowedNamespace, "*?[{\\")
+}
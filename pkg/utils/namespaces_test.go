@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsNamespaceAllowed(t *testing.T) {
+	for _, c := range []struct {
+		name              string
+		namespace         string
+		allowedNamespaces []string
+		expected          bool
+	}{
+		{
+			name:      "Argo CD namespace",
+			namespace: "argocd",
+			expected:  true,
+		},
+		{
+			name:      "other namespace, without allowed namespaces",
+			namespace: "team-a",
+			expected:  false,
+		},
+		{
+			name:              "allowed namespace",
+			namespace:         "team-a",
+			allowedNamespaces: []string{"team-b", "team-a"},
+			expected:          true,
+		},
+		{
+			name:              "namespace matching a glob",
+			namespace:         "team-a",
+			allowedNamespaces: []string{"team-*"},
+			expected:          true,
+		},
+		{
+			name:              "namespace not matching a glob",
+			namespace:         "kube-system",
+			allowedNamespaces: []string{"team-*"},
+			expected:          false,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, IsNamespaceAllowed(c.namespace, "argocd", c.allowedNamespaces))
+		})
+	}
+}
+
+func TestIsNamespaceGlob(t *testing.T) {
+	assert.False(t, IsNamespaceGlob("team-a"))
+	assert.True(t, IsNamespaceGlob("team-*"))
+	assert.True(t, IsNamespaceGlob("team-?"))
+	assert.True(t, IsNamespaceGlob("team-{a,b}"))
+}
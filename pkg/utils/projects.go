@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"strings"
+
+	"github.com/argoproj/argo-cd/v2/util/glob"
+)
+
+// IsProjectAllowed returns true if the project matches one of the allowed projects, which may be glob patterns (eg
+// 'team-a-*'). Any project is allowed if there are no allowed projects.
+func IsProjectAllowed(project string, allowedProjects []string) bool {
+	if len(allowedProjects) == 0 {
+		return true
+	}
+	for _, allowed := range allowedProjects {
+		if glob.Match(allowed, project) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseAllowedProjects parses a comma separated list of allowed projects, such as the value of the
+// AnnotationApplicationSetAllowedProjects annotation.
+func ParseAllowedProjects(value string) []string {
+	var allowedProjects []string
+	for _, project := range strings.Split(value, ",") {
+		if project = strings.TrimSpace(project); project != "" {
+			allowedProjects = append(allowedProjects, project)
+		}
+	}
+	return allowedProjects
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsProjectAllowed(t *testing.T) {
+	for _, c := range []struct {
+		name            string
+		project         string
+		allowedProjects []string
+		expected        bool
+	}{
+		{
+			name:     "no allowed projects",
+			project:  "default",
+			expected: true,
+		},
+		{
+			name:            "allowed project",
+			project:         "team-a",
+			allowedProjects: []string{"team-b", "team-a"},
+			expected:        true,
+		},
+		{
+			name:            "project matching a glob pattern",
+			project:         "team-a-prod",
+			allowedProjects: []string{"team-a-*"},
+			expected:        true,
+		},
+		{
+			name:            "project which is not allowed",
+			project:         "default",
+			allowedProjects: []string{"team-a", "team-a-*"},
+			expected:        false,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, IsProjectAllowed(c.project, c.allowedProjects))
+		})
+	}
+}
+
+func TestParseAllowedProjects(t *testing.T) {
+	assert.Nil(t, ParseAllowedProjects(""))
+	assert.Equal(t, []string{"team-a", "team-b-*"}, ParseAllowedProjects(" team-a, ,team-b-*"))
+}
@@ -0,0 +1,209 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/argoproj/applicationset/common"
+)
+
+// shardLeasePrefix is the name prefix of the Leases which are held by the replicas of a sharded ApplicationSet
+// controller, followed by the name of the replica.
+const shardLeasePrefix = "argocd-applicationset-controller-shard-"
+
+// Shards assigns ApplicationSets to the replicas of a sharded ApplicationSet controller. Each replica holds a Lease
+// in the controller namespace, which it renews periodically: the replicas whose Lease has not expired are the
+// members of the shards, ordered by name, and each replica owns the ApplicationSets of the shard at its index. The
+// members are recalculated whenever the Lease is renewed, so that ApplicationSets are reassigned when replicas are
+// added or removed. A replica which fails to renew its Lease owns no ApplicationSet once the Lease may have expired,
+// since the other replicas then take over its shard.
+type Shards struct {
+	client        kubernetes.Interface
+	namespace     string
+	replica       string
+	leaseDuration time.Duration
+
+	lock     sync.RWMutex
+	replicas []string
+	// renewed is the time at which the Lease was last renewed (or earlier), along with the members of the shards
+	renewed  time.Time
+	onChange []func()
+}
+
+// NewShards returns the Shards of the given replica of the ApplicationSet controller, whose Lease is held in the given
+// namespace. The Lease expires if it is not renewed within leaseDuration.
+func NewShards(client kubernetes.Interface, namespace string, replica string, leaseDuration time.Duration) *Shards {
+	return &Shards{
+		client:        client,
+		namespace:     namespace,
+		replica:       replica,
+		leaseDuration: leaseDuration,
+	}
+}
+
+// OnChange registers a function which is called whenever the members of the shards change, and thus the
+// ApplicationSets owned by each replica may have changed.
+func (s *Shards) OnChange(f func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.onChange = append(s.onChange, f)
+}
+
+// Start renews the Lease of the replica, and recalculates the members of the shards, until the context is done. The
+// Lease is then released, so that the other replicas take over its shard right away.
+func (s *Shards) Start(ctx context.Context) error {
+	ticker := time.NewTicker(s.leaseDuration / 3)
+	defer ticker.Stop()
+
+	for {
+		if err := s.refresh(ctx); err != nil {
+			log.WithError(err).Warn("unable to refresh the ApplicationSet controller shards")
+		}
+
+		select {
+		case <-ctx.Done():
+			releaseCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err := s.client.CoordinationV1().Leases(s.namespace).Delete(releaseCtx, shardLeasePrefix+s.replica, metav1.DeleteOptions{})
+			if err != nil && !apierr.IsNotFound(err) {
+				return fmt.Errorf("unable to release the shard lease: %w", err)
+			}
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection returns false, as all the replicas of a sharded ApplicationSet controller are active.
+func (s *Shards) NeedLeaderElection() bool {
+	return false
+}
+
+// IsOwned returns true if the ApplicationSet belongs to the shard of this replica. No ApplicationSet is owned until
+// the Lease of the replica has been acquired, nor once it has not been renewed for the Lease duration.
+func (s *Shards) IsOwned(namespace string, name string, labels map[string]string) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if time.Since(s.renewed) >= s.leaseDuration {
+		return false
+	}
+	for i, replica := range s.replicas {
+		if replica == s.replica {
+			return ApplicationSetShard(namespace, name, labels, len(s.replicas)) == i
+		}
+	}
+	return false
+}
+
+// refresh renews the Lease of the replica, and recalculates the members of the shards from the Leases which have not
+// expired.
+func (s *Shards) refresh(ctx context.Context) error {
+	// The Lease expires a lease duration after it is renewed: the time before the renewal is sent is thus on the
+	// safe side
+	renewed := time.Now()
+	if err := s.renewLease(ctx); err != nil {
+		return err
+	}
+
+	leases, err := s.client.CoordinationV1().Leases(s.namespace).List(ctx, metav1.ListOptions{LabelSelector: common.LabelApplicationSetControllerReplica})
+	if err != nil {
+		return fmt.Errorf("unable to list the shard leases: %w", err)
+	}
+
+	now := time.Now()
+	replicas := []string{}
+	for _, lease := range leases.Items {
+		if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+			continue
+		}
+		if lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second).Before(now) {
+			continue
+		}
+		replicas = append(replicas, lease.Labels[common.LabelApplicationSetControllerReplica])
+	}
+	sort.Strings(replicas)
+
+	s.lock.Lock()
+	// The ApplicationSets of the shard were not owned while the Lease may have expired
+	expired := time.Since(s.renewed) >= s.leaseDuration
+	changed := expired || !reflect.DeepEqual(s.replicas, replicas)
+	s.renewed = renewed
+	s.replicas = replicas
+	onChange := s.onChange
+	s.lock.Unlock()
+
+	if changed {
+		log.WithField("replicas", replicas).Infof("ApplicationSet controller shards changed, replica %s is one of %d", s.replica, len(replicas))
+		for _, f := range onChange {
+			f()
+		}
+	}
+	return nil
+}
+
+// renewLease creates or renews the Lease of the replica.
+func (s *Shards) renewLease(ctx context.Context) error {
+	leases := s.client.CoordinationV1().Leases(s.namespace)
+	now := metav1.NewMicroTime(time.Now())
+	leaseDurationSeconds := int32(s.leaseDuration.Seconds())
+
+	lease, err := leases.Get(ctx, shardLeasePrefix+s.replica, metav1.GetOptions{})
+	if err != nil {
+		if !apierr.IsNotFound(err) {
+			return fmt.Errorf("unable to get the shard lease: %w", err)
+		}
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      shardLeasePrefix + s.replica,
+				Namespace: s.namespace,
+				Labels:    map[string]string{common.LabelApplicationSetControllerReplica: s.replica},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &s.replica,
+				LeaseDurationSeconds: &leaseDurationSeconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		if _, err := leases.Create(ctx, lease, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("unable to create the shard lease: %w", err)
+		}
+		return nil
+	}
+
+	lease.Spec.LeaseDurationSeconds = &leaseDurationSeconds
+	lease.Spec.RenewTime = &now
+	if _, err := leases.Update(ctx, lease, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("unable to renew the shard lease: %w", err)
+	}
+	return nil
+}
+
+// ApplicationSetShard returns the shard of an ApplicationSet, out of the given number of shards: the shard set by its
+// LabelApplicationSetShard label, if any, or otherwise the hash of its namespace and name.
+func ApplicationSetShard(namespace string, name string, labels map[string]string, shards int) int {
+	if value, ok := labels[common.LabelApplicationSetShard]; ok {
+		shard, err := strconv.Atoi(value)
+		if err == nil && shard >= 0 {
+			return shard % shards
+		}
+		log.WithField("applicationset", namespace+"/"+name).Warnf("ignoring invalid %s label '%s'", common.LabelApplicationSetShard, value)
+	}
+
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(namespace + "/" + name))
+	return int(hash.Sum32() % uint32(shards))
+}
@@ -0,0 +1,181 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/argoproj/applicationset/common"
+)
+
+func TestApplicationSetShard(t *testing.T) {
+	// The shard of an ApplicationSet is deterministic, and within the number of shards
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("appset-%d", i)
+		shard := ApplicationSetShard("argocd", name, nil, 3)
+		assert.Equal(t, shard, ApplicationSetShard("argocd", name, nil, 3))
+		assert.GreaterOrEqual(t, shard, 0)
+		assert.Less(t, shard, 3)
+	}
+
+	// ApplicationSets with the same name in different namespaces are not necessarily in the same shard
+	assert.NotEqual(t, ApplicationSetShard("team-a", "appset", nil, 1000), ApplicationSetShard("team-b", "appset", nil, 1000))
+
+	for _, c := range []struct {
+		name     string
+		label    string
+		expected int
+	}{
+		{
+			name:     "shard label",
+			label:    "1",
+			expected: 1,
+		},
+		{
+			name:     "shard label beyond the number of shards",
+			label:    "4",
+			expected: 1,
+		},
+		{
+			name:     "invalid shard label",
+			label:    "not-a-shard",
+			expected: ApplicationSetShard("argocd", "appset", nil, 3),
+		},
+		{
+			name:     "negative shard label",
+			label:    "-1",
+			expected: ApplicationSetShard("argocd", "appset", nil, 3),
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			labels := map[string]string{common.LabelApplicationSetShard: c.label}
+			assert.Equal(t, c.expected, ApplicationSetShard("argocd", "appset", labels, 3))
+		})
+	}
+}
+
+func shardLease(replica string, renewTime time.Time) *coordinationv1.Lease {
+	leaseDurationSeconds := int32(30)
+	renew := metav1.NewMicroTime(renewTime)
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      shardLeasePrefix + replica,
+			Namespace: "argocd",
+			Labels:    map[string]string{common.LabelApplicationSetControllerReplica: replica},
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &replica,
+			LeaseDurationSeconds: &leaseDurationSeconds,
+			RenewTime:            &renew,
+		},
+	}
+}
+
+func TestShards(t *testing.T) {
+	client := kubefake.NewSimpleClientset(
+		shardLease("replica-a", time.Now()),
+		// The lease of a replica which has stopped
+		shardLease("replica-0", time.Now().Add(-time.Minute)),
+	)
+	shards := NewShards(client, "argocd", "replica-b", 30*time.Second)
+	changes := 0
+	shards.OnChange(func() {
+		changes++
+	})
+
+	// Nothing is owned until the lease is acquired
+	assert.False(t, shards.IsOwned("argocd", "appset", nil))
+
+	err := shards.refresh(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"replica-a", "replica-b"}, shards.replicas)
+	assert.Equal(t, 1, changes)
+
+	lease, err := client.CoordinationV1().Leases("argocd").Get(context.Background(), shardLeasePrefix+"replica-b", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "replica-b", *lease.Spec.HolderIdentity)
+	assert.Equal(t, int32(30), *lease.Spec.LeaseDurationSeconds)
+
+	// Each ApplicationSet is owned by the replica at the index of its shard
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("appset-%d", i)
+		assert.Equal(t, ApplicationSetShard("argocd", name, nil, 2) == 1, shards.IsOwned("argocd", name, nil))
+	}
+	assert.True(t, shards.IsOwned("argocd", "appset", map[string]string{common.LabelApplicationSetShard: "1"}))
+	assert.False(t, shards.IsOwned("argocd", "appset", map[string]string{common.LabelApplicationSetShard: "0"}))
+
+	// The lease is renewed, without any change to the shards
+	err = shards.refresh(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, changes)
+
+	// A replica is removed
+	err = client.CoordinationV1().Leases("argocd").Delete(context.Background(), shardLeasePrefix+"replica-a", metav1.DeleteOptions{})
+	assert.Nil(t, err)
+	err = shards.refresh(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"replica-b"}, shards.replicas)
+	assert.Equal(t, 2, changes)
+	assert.True(t, shards.IsOwned("argocd", "appset", map[string]string{common.LabelApplicationSetShard: "0"}))
+}
+
+func TestShardsLeaseNotRenewed(t *testing.T) {
+	client := kubefake.NewSimpleClientset()
+	renewalFails := false
+	client.PrependReactor("update", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if renewalFails {
+			return true, nil, fmt.Errorf("renewal failed")
+		}
+		return false, nil, nil
+	})
+	shards := NewShards(client, "argocd", "replica-a", 30*time.Second)
+	changes := 0
+	shards.OnChange(func() {
+		changes++
+	})
+
+	err := shards.refresh(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, shards.IsOwned("argocd", "appset", nil))
+
+	// The lease can't be renewed: the ApplicationSets are no longer owned once the lease may have expired, since the
+	// other replicas may then have taken over the shard
+	renewalFails = true
+	shards.renewed = time.Now().Add(-20 * time.Second)
+	err = shards.refresh(context.Background())
+	assert.EqualError(t, err, "unable to renew the shard lease: renewal failed")
+	assert.True(t, shards.IsOwned("argocd", "appset", nil), "the lease has not expired yet")
+	shards.renewed = time.Now().Add(-30 * time.Second)
+	assert.False(t, shards.IsOwned("argocd", "appset", nil))
+
+	// The ApplicationSets are owned again once the lease is renewed, as if the shards had changed
+	renewalFails = false
+	err = shards.refresh(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, shards.IsOwned("argocd", "appset", nil))
+	assert.Equal(t, 2, changes)
+}
+
+func TestShardsReleaseLease(t *testing.T) {
+	client := kubefake.NewSimpleClientset()
+	shards := NewShards(client, "argocd", "replica-a", 30*time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	shards.OnChange(cancel)
+
+	// The shards are refreshed once, after which the context is cancelled and the lease released
+	err := shards.Start(ctx)
+	assert.Nil(t, err)
+
+	_, err = client.CoordinationV1().Leases("argocd").Get(context.Background(), shardLeasePrefix+"replica-a", metav1.GetOptions{})
+	assert.True(t, apierr.IsNotFound(err))
+}
@@ -1,13 +1,21 @@
 package utils
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"html"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"path"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/argoproj/applicationset/api/v1alpha1"
 	"github.com/argoproj/applicationset/common"
@@ -16,22 +24,75 @@ import (
 	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	gogsclient "github.com/gogits/go-gogs-client"
 	log "github.com/sirupsen/logrus"
+	"gopkg.in/go-playground/webhooks.v5/bitbucket"
+	bitbucketserver "gopkg.in/go-playground/webhooks.v5/bitbucket-server"
 	"gopkg.in/go-playground/webhooks.v5/github"
 	"gopkg.in/go-playground/webhooks.v5/gitlab"
+	"gopkg.in/go-playground/webhooks.v5/gogs"
 )
 
 type WebhookHandler struct {
 	namespace string
-	github    *github.Webhook
-	gitlab    *gitlab.Webhook
-	client    client.Client
+	// applicationSetNamespaces are the namespaces, other than the Argo CD namespace, of the ApplicationSets which
+	// may be refreshed
+	applicationSetNamespaces []string
+	github                   *github.Webhook
+	gitlab                   *gitlab.Webhook
+	bitbucket                *bitbucket.Webhook
+	bitbucketServer          *bitbucketserver.Webhook
+	gogs                     *gogs.Webhook
+	// refreshSecret authenticates the requests of the refresh endpoint, which is disabled when it is empty
+	refreshSecret string
+	client        client.Client
+
+	// queue holds the parsed events waiting to be handled by the workers
+	queue chan webhookEvent
+	// pending holds the keys of the queued events, so that identical events (such as the retries of a Git provider)
+	// are only queued once
+	pending   map[string]bool
+	pendingMu sync.Mutex
+	// closed is set, under pendingMu, once the queue is closed by Shutdown
+	closed bool
+	sync.WaitGroup
+}
+
+const (
+	// maxWebhookRequestSize bounds the size of the body of a webhook event, which is at most 25MB for GitHub
+	maxWebhookRequestSize = 25 * 1024 * 1024
+	// webhookEventTimeout bounds the time taken to refresh the ApplicationSets concerned by a webhook event
+	webhookEventTimeout = time.Minute
+)
+
+// webhookEvent is a parsed webhook event, with a key identifying identical events
+type webhookEvent struct {
+	key     string
+	payload interface{}
 }
 
 type gitGeneratorInfo struct {
-	Revision    string
+	// Revisions are the branches and tags changed by the event
+	Revisions   []string
 	TouchedHead bool
-	RepoRegexp  *regexp.Regexp
+	// RepoRegexps match the URLs of the repository, one per URL given by the payload
+	RepoRegexps []*regexp.Regexp
+	// ChangedFiles are the files changed by the event, or nil if the payload does not list all of them
+	ChangedFiles *changedFiles
+}
+
+// changedFiles lists the paths, relative to the root of the repository, of the files changed by a push.
+type changedFiles struct {
+	// AddedOrRemoved are the files which were added or removed, which may also add or remove their directories
+	AddedOrRemoved []string
+	// Modified are the files whose contents were changed
+	Modified []string
+}
+
+func (c *changedFiles) add(added, removed, modified []string) {
+	c.AddedOrRemoved = append(c.AddedOrRemoved, added...)
+	c.AddedOrRemoved = append(c.AddedOrRemoved, removed...)
+	c.Modified = append(c.Modified, modified...)
 }
 
 type prGeneratorInfo struct {
@@ -44,7 +105,40 @@ type prGeneratorGithubInfo struct {
 	APIRegexp *regexp.Regexp
 }
 
-func NewWebhookHandler(namespace string, argocdSettingsMgr *argosettings.SettingsManager, client client.Client) (*WebhookHandler, error) {
+type scmProviderGeneratorInfo struct {
+	Github    *scmProviderGeneratorGithubInfo
+	Gitlab    *scmProviderGeneratorGitlabInfo
+	Bitbucket *scmProviderGeneratorBitbucketInfo
+	// TouchedHead is false when the event only changed branches other than the default branch of the repository,
+	// which are only listed by the generators which scan all branches
+	TouchedHead bool
+}
+
+type scmProviderGeneratorGithubInfo struct {
+	Organization string
+	APIRegexp    *regexp.Regexp
+}
+
+type scmProviderGeneratorGitlabInfo struct {
+	// Namespace is the full path of the group of the project
+	Namespace string
+	APIRegexp *regexp.Regexp
+}
+
+type scmProviderGeneratorBitbucketInfo struct {
+	Workspace string
+}
+
+// NewWebhookHandler returns a WebhookHandler, whose events are handled by webhookParallelism workers. At most
+// webhookQueueSize events are queued: further events are rejected until the workers catch up.
+func NewWebhookHandler(namespace string, applicationSetNamespaces []string, argocdSettingsMgr *argosettings.SettingsManager, client client.Client, webhookParallelism int, webhookQueueSize int) (*WebhookHandler, error) {
+	if webhookParallelism < 1 {
+		return nil, fmt.Errorf("webhook parallelism must be at least 1, got %d", webhookParallelism)
+	}
+	if webhookQueueSize < 1 {
+		return nil, fmt.Errorf("webhook queue size must be at least 1, got %d", webhookQueueSize)
+	}
+
 	// register the webhook secrets stored under "argocd-secret" for verifying incoming payloads
 	argocdSettings, err := argocdSettingsMgr.GetSettings()
 	if err != nil {
@@ -58,40 +152,137 @@ func NewWebhookHandler(namespace string, argocdSettingsMgr *argosettings.Setting
 	if err != nil {
 		return nil, fmt.Errorf("Unable to init GitLab webhook: %v", err)
 	}
+	bitbucketHandler, err := bitbucket.New(bitbucket.Options.UUID(argocdSettings.WebhookBitbucketUUID))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to init Bitbucket webhook: %v", err)
+	}
+	bitbucketServerHandler, err := bitbucketserver.New(bitbucketserver.Options.Secret(argocdSettings.WebhookBitbucketServerSecret))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to init Bitbucket Server webhook: %v", err)
+	}
+	gogsHandler, err := gogs.New(gogs.Options.Secret(argocdSettings.WebhookGogsSecret))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to init Gogs webhook: %v", err)
+	}
 
-	return &WebhookHandler{
-		namespace: namespace,
-		github:    githubHandler,
-		gitlab:    gitlabHandler,
-		client:    client,
-	}, nil
+	h := &WebhookHandler{
+		namespace:                namespace,
+		applicationSetNamespaces: applicationSetNamespaces,
+		github:                   githubHandler,
+		gitlab:                   gitlabHandler,
+		bitbucket:                bitbucketHandler,
+		bitbucketServer:          bitbucketServerHandler,
+		gogs:                     gogsHandler,
+		refreshSecret:            argocdSettings.Secrets[settingsWebhookRefreshSecretKey],
+		client:                   client,
+		queue:                    make(chan webhookEvent, webhookQueueSize),
+		pending:                  map[string]bool{},
+	}
+	h.startWorkerPool(webhookParallelism)
+	return h, nil
+}
+
+// startWorkerPool starts the workers handling the queued events, until the queue is closed.
+func (h *WebhookHandler) startWorkerPool(webhookParallelism int) {
+	for i := 0; i < webhookParallelism; i++ {
+		h.Add(1)
+		go func() {
+			defer h.Done()
+			for event := range h.queue {
+				webhookQueueDepth.Dec()
+				// An identical event received from now on may see changes that this one does not, so queue it again
+				h.pendingMu.Lock()
+				delete(h.pending, event.key)
+				h.pendingMu.Unlock()
+
+				h.HandleEvent(event.payload)
+			}
+		}()
+	}
+}
+
+// enqueue queues an event for the workers, and returns false if the queue is full or closed. An event identical to an event
+// which is already queued is dropped, since handling the queued event has the same effect.
+func (h *WebhookHandler) enqueue(event webhookEvent) bool {
+	h.pendingMu.Lock()
+	defer h.pendingMu.Unlock()
+
+	if h.closed {
+		webhookEvents.WithLabelValues("rejected").Inc()
+		return false
+	}
+	if h.pending[event.key] {
+		log.Debug("Ignoring webhook event identical to a queued event")
+		webhookEvents.WithLabelValues("deduplicated").Inc()
+		return true
+	}
+	select {
+	case h.queue <- event:
+		h.pending[event.key] = true
+		webhookQueueDepth.Inc()
+		webhookEvents.WithLabelValues("queued").Inc()
+		return true
+	default:
+		webhookEvents.WithLabelValues("rejected").Inc()
+		return false
+	}
+}
+
+// Shutdown stops queueing events, and waits until the queued events are handled or ctx is done.
+func (h *WebhookHandler) Shutdown(ctx context.Context) error {
+	h.pendingMu.Lock()
+	if !h.closed {
+		h.closed = true
+		close(h.queue)
+	}
+	h.pendingMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		h.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("webhook events were not all handled before shutdown: %w", ctx.Err())
+	}
 }
 
 func (h *WebhookHandler) HandleEvent(payload interface{}) {
 	gitGenInfo := getGitGeneratorInfo(payload)
 	prGenInfo := getPRGeneratorInfo(payload)
-	if gitGenInfo == nil && prGenInfo == nil {
+	scmProviderGenInfo := getSCMProviderGeneratorInfo(payload)
+	if gitGenInfo == nil && prGenInfo == nil && scmProviderGenInfo == nil {
 		return
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), webhookEventTimeout)
+	defer cancel()
+
 	appSetList := &v1alpha1.ApplicationSetList{}
-	err := h.client.List(context.Background(), appSetList, &client.ListOptions{})
+	err := h.client.List(ctx, appSetList, &client.ListOptions{})
 	if err != nil {
 		log.Errorf("Failed to list applicationsets: %v", err)
 		return
 	}
 
 	for _, appSet := range appSetList.Items {
+		if !IsNamespaceAllowed(appSet.Namespace, h.namespace, h.applicationSetNamespaces) {
+			continue
+		}
 		shouldRefresh := false
-		for _, gen := range appSet.Spec.Generators {
-			// check if the ApplicationSet uses the git generator that is relevant to the payload
-			shouldRefresh = shouldRefreshGitGenerator(gen.Git, gitGenInfo) || shouldRefreshPRGenerator(gen.PullRequest, prGenInfo)
+		for _, gen := range flattenGenerators(appSet.Spec.Generators) {
+			// check if the ApplicationSet uses a generator that is relevant to the payload
+			shouldRefresh = shouldRefreshGitGenerator(gen.Git, gitGenInfo) || shouldRefreshPRGenerator(gen.PullRequest, prGenInfo) ||
+				shouldRefreshSCMProviderGenerator(gen.SCMProvider, scmProviderGenInfo)
 			if shouldRefresh {
 				break
 			}
 		}
 		if shouldRefresh {
-			err := refreshApplicationSet(h.client, &appSet)
+			err := refreshApplicationSet(ctx, h.client, &appSet)
 			if err != nil {
 				log.Errorf("Failed to refresh ApplicationSet '%s' for controller reprocessing", appSet.Name)
 				continue
@@ -101,15 +292,91 @@ func (h *WebhookHandler) HandleEvent(payload interface{}) {
 	}
 }
 
+// flattenGenerators returns the generators of an ApplicationSet, including the generators nested within Matrix and
+// Merge generators, as terminal generators.
+func flattenGenerators(generators []v1alpha1.ApplicationSetGenerator) []v1alpha1.ApplicationSetTerminalGenerator {
+	var res []v1alpha1.ApplicationSetTerminalGenerator
+	for _, gen := range generators {
+		res = append(res, v1alpha1.ApplicationSetTerminalGenerator{
+			List:                    gen.List,
+			Clusters:                gen.Clusters,
+			Git:                     gen.Git,
+			SCMProvider:             gen.SCMProvider,
+			ClusterDecisionResource: gen.ClusterDecisionResource,
+			PullRequest:             gen.PullRequest,
+		})
+		if gen.Matrix != nil {
+			res = append(res, flattenNestedGenerators(gen.Matrix.Generators)...)
+		}
+		if gen.Merge != nil {
+			res = append(res, flattenNestedGenerators(gen.Merge.Generators)...)
+		}
+	}
+	return res
+}
+
+// flattenNestedGenerators returns the generators nested within a Matrix or Merge generator, including the generators
+// nested within nested Matrix and Merge generators, as terminal generators.
+func flattenNestedGenerators(generators []v1alpha1.ApplicationSetNestedGenerator) []v1alpha1.ApplicationSetTerminalGenerator {
+	var res []v1alpha1.ApplicationSetTerminalGenerator
+	for _, gen := range generators {
+		res = append(res, v1alpha1.ApplicationSetTerminalGenerator{
+			List:                    gen.List,
+			Clusters:                gen.Clusters,
+			Git:                     gen.Git,
+			SCMProvider:             gen.SCMProvider,
+			ClusterDecisionResource: gen.ClusterDecisionResource,
+			PullRequest:             gen.PullRequest,
+		})
+
+		// Since nested Matrix and Merge generators are represented as JSON objects in the CRD, we unmarshall them here
+		nestedMatrix, err := v1alpha1.ToNestedMatrixGenerator(gen.Matrix)
+		if err != nil {
+			log.Errorf("Failed to unmarshall nested matrix generator: %v", err)
+		} else if nestedMatrix != nil {
+			res = append(res, flattenNestedGenerators(nestedMatrix.ToMatrixGenerator().Generators)...)
+		}
+		nestedMerge, err := v1alpha1.ToNestedMergeGenerator(gen.Merge)
+		if err != nil {
+			log.Errorf("Failed to unmarshall nested merge generator: %v", err)
+		} else if nestedMerge != nil {
+			res = append(res, flattenNestedGenerators(nestedMerge.ToMergeGenerator().Generators)...)
+		}
+	}
+	return res
+}
+
+// Handler parses the webhook events of the Git providers, and queues them to be handled asynchronously, so that
+// providers are answered before they time out.
 func (h *WebhookHandler) Handler(w http.ResponseWriter, r *http.Request) {
 	var payload interface{}
 	var err error
 
+	// Identical events are identified by their headers and body, which are read before being parsed
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookRequestSize))
+	if err != nil {
+		log.Infof("Webhook processing failed: %s", err)
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
 	switch {
+	// Gogs and Gitea must be checked before GitHub, since they also send (incompatible) GitHub headers
+	case r.Header.Get("X-Gogs-Event") != "":
+		payload, err = h.gogs.Parse(r, gogs.PushEvent, gogs.PullRequestEvent)
 	case r.Header.Get("X-GitHub-Event") != "":
-		payload, err = h.github.Parse(r, github.PushEvent, github.PullRequestEvent)
+		payload, err = h.github.Parse(r, github.PushEvent, github.PullRequestEvent, github.RepositoryEvent)
 	case r.Header.Get("X-Gitlab-Event") != "":
-		payload, err = h.gitlab.Parse(r, gitlab.PushEvents, gitlab.TagEvents)
+		payload, err = h.gitlab.Parse(r, gitlab.PushEvents, gitlab.TagEvents, gitlab.MergeRequestEvents)
+	// Bitbucket Cloud must be checked before Bitbucket Server, since both send the X-Event-Key header
+	case r.Header.Get("X-Hook-UUID") != "":
+		payload, err = h.bitbucket.Parse(r, bitbucket.RepoPushEvent, bitbucket.PullRequestCreatedEvent,
+			bitbucket.PullRequestUpdatedEvent, bitbucket.PullRequestMergedEvent, bitbucket.PullRequestDeclinedEvent)
+	case r.Header.Get("X-Event-Key") != "":
+		payload, err = h.bitbucketServer.Parse(r, bitbucketserver.RepositoryReferenceChangedEvent, bitbucketserver.DiagnosticsPingEvent,
+			bitbucketserver.PullRequestOpenedEvent, bitbucketserver.PullRequestModifiedEvent, bitbucketserver.PullRequestMergedEvent,
+			bitbucketserver.PullRequestDeclinedEvent, bitbucketserver.PullRequestDeletedEvent)
 	default:
 		log.Debug("Ignoring unknown webhook event")
 		http.Error(w, "Unknown webhook event", http.StatusBadRequest)
@@ -126,9 +393,29 @@ func (h *WebhookHandler) Handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.HandleEvent(payload)
+	if !h.enqueue(webhookEvent{key: webhookEventKey(r, body), payload: payload}) {
+		log.Warn("Webhook queue is full, rejecting event")
+		http.Error(w, "Webhook queue is full", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
 }
 
+// webhookEventKey returns a key identifying identical webhook events, by the provider event headers and body of
+// their requests. Delivery IDs are left out, since they differ between the retries of an event.
+func webhookEventKey(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	for _, header := range []string{"X-Gogs-Event", "X-GitHub-Event", "X-Gitlab-Event", "X-Event-Key"} {
+		_, _ = fmt.Fprintf(hash, "%s=%s\n", header, r.Header.Get(header))
+	}
+	_, _ = hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// maxPushPayloadCommits is the number of commits which GitHub and Gogs/Gitea list at most in the payload of a push:
+// the payload of a push with as many commits may not list all of the changed files.
+const maxPushPayloadCommits = 20
+
 func parseRevision(ref string) string {
 	refParts := strings.SplitN(ref, "/", 3)
 	return refParts[len(refParts)-1]
@@ -136,40 +423,138 @@ func parseRevision(ref string) string {
 
 func getGitGeneratorInfo(payload interface{}) *gitGeneratorInfo {
 	var (
-		webURL      string
-		revision    string
+		webURLs     []string
+		revisions   []string
 		touchedHead bool
+		changed     *changedFiles
 	)
 	switch payload := payload.(type) {
 	case github.PushPayload:
-		webURL = payload.Repository.HTMLURL
-		revision = parseRevision(payload.Ref)
+		webURLs = append(webURLs, payload.Repository.HTMLURL)
+		revision := parseRevision(payload.Ref)
+		revisions = append(revisions, revision)
 		touchedHead = payload.Repository.DefaultBranch == revision
+		// The commits of created, deleted and force-pushed branches don't tell which files were changed, nor do the
+		// commits of a push which may have been truncated
+		if !payload.Created && !payload.Deleted && !payload.Forced && len(payload.Commits) > 0 &&
+			len(payload.Commits) < maxPushPayloadCommits {
+			changed = &changedFiles{}
+			for _, commit := range payload.Commits {
+				changed.add(commit.Added, commit.Removed, commit.Modified)
+			}
+		}
 	case gitlab.PushEventPayload:
-		webURL = payload.Project.WebURL
-		revision = parseRevision(payload.Ref)
+		webURLs = append(webURLs, payload.Project.WebURL)
+		revision := parseRevision(payload.Ref)
+		revisions = append(revisions, revision)
 		touchedHead = payload.Project.DefaultBranch == revision
+		// GitLab only lists the last 20 commits of a push
+		if !isZeroSHA(payload.Before) && !isZeroSHA(payload.After) && len(payload.Commits) > 0 &&
+			int64(len(payload.Commits)) == payload.TotalCommitsCount {
+			changed = &changedFiles{}
+			for _, commit := range payload.Commits {
+				changed.add(commit.Added, commit.Removed, commit.Modified)
+			}
+		}
+	case bitbucket.RepoPushPayload:
+		webURLs = append(webURLs, payload.Repository.Links.HTML.Href)
+		// A push may change several branches and tags, all of which are considered
+		for _, change := range payload.Push.Changes {
+			// The new state of a deleted branch is empty, and its old state is a branch which no longer exists
+			if change.New.Name != "" {
+				revisions = append(revisions, change.New.Name)
+			} else if change.Old.Name != "" {
+				revisions = append(revisions, change.Old.Name)
+			}
+		}
+		// The payload does not tell whether the default branch was changed, so assume it was
+		touchedHead = true
+	case bitbucketserver.RepositoryReferenceChangedPayload:
+		webURLs = append(webURLs, bitbucketServerCloneURLs(payload.Repository)...)
+		// A push may change several branches and tags, all of which are considered
+		for _, change := range payload.Changes {
+			revisions = append(revisions, parseRevision(change.Reference.ID))
+		}
+		// The payload does not tell whether the default branch was changed, so assume it was
+		touchedHead = true
+	case gogsclient.PushPayload:
+		if payload.Repo == nil {
+			return nil
+		}
+		webURLs = append(webURLs, payload.Repo.HTMLURL)
+		revision := parseRevision(payload.Ref)
+		revisions = append(revisions, revision)
+		touchedHead = payload.Repo.DefaultBranch == revision
+		if !isZeroSHA(payload.Before) && !isZeroSHA(payload.After) && len(payload.Commits) > 0 &&
+			len(payload.Commits) < maxPushPayloadCommits {
+			changed = &changedFiles{}
+			for _, commit := range payload.Commits {
+				changed.add(commit.Added, commit.Removed, commit.Modified)
+			}
+		}
 	default:
 		return nil
 	}
 
-	log.Infof("Received push event repo: %s, revision: %s, touchedHead: %v", webURL, revision, touchedHead)
-	urlObj, err := url.Parse(webURL)
-	if err != nil {
-		log.Errorf("Failed to parse repoURL '%s'", webURL)
-		return nil
+	log.Infof("Received push event repo: %v, revisions: %v, touchedHead: %v", webURLs, revisions, touchedHead)
+	var repoRegexps []*regexp.Regexp
+	for _, webURL := range webURLs {
+		repoRegexp, err := getRepoRegexp(webURL)
+		if err != nil {
+			log.Errorf("Failed to compile regexp for repoURL '%s': %v", webURL, err)
+			continue
+		}
+		repoRegexps = append(repoRegexps, repoRegexp)
 	}
-	regexpStr := `(?i)(http://|https://|\w+@|ssh://(\w+@)?)` + urlObj.Hostname() + "(:[0-9]+|)[:/]" + urlObj.Path[1:] + "(\\.git)?"
-	repoRegexp, err := regexp.Compile(regexpStr)
-	if err != nil {
-		log.Errorf("Failed to compile regexp for repoURL '%s'", webURL)
+	if len(repoRegexps) == 0 {
 		return nil
 	}
 
 	return &gitGeneratorInfo{
-		RepoRegexp:  repoRegexp,
-		TouchedHead: touchedHead,
+		Revisions:    revisions,
+		RepoRegexps:  repoRegexps,
+		TouchedHead:  touchedHead,
+		ChangedFiles: changed,
+	}
+}
+
+// isZeroSHA returns true if sha is the all-zero SHA, which push events use for the missing side of a created or
+// deleted branch.
+func isZeroSHA(sha string) bool {
+	return strings.Trim(sha, "0") == ""
+}
+
+// getRepoRegexp returns a regexp matching the HTTP(S) and SSH URLs of the repository at webURL.
+func getRepoRegexp(webURL string) (*regexp.Regexp, error) {
+	urlObj, err := url.Parse(webURL)
+	if err != nil {
+		return nil, err
+	}
+	if len(urlObj.Path) < 2 {
+		return nil, fmt.Errorf("repoURL has no path")
 	}
+	regexpStr := `(?i)(http://|https://|\w+@|ssh://(\w+@)?)` + urlObj.Hostname() + "(:[0-9]+|)[:/]" + strings.TrimSuffix(urlObj.Path[1:], ".git") + "(\\.git)?"
+	return regexp.Compile(regexpStr)
+}
+
+// bitbucketServerCloneURLs returns the HTTP and SSH clone URLs of a Bitbucket Server repository, whose links are
+// not parsed by the webhook library.
+func bitbucketServerCloneURLs(repository bitbucketserver.Repository) []string {
+	var res []string
+	cloneLinks, _ := repository.Links["clone"].([]interface{})
+	for _, l := range cloneLinks {
+		link, ok := l.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if name := link["name"]; name != "http" && name != "ssh" {
+			continue
+		}
+		if href, ok := link["href"].(string); ok {
+			res = append(res, href)
+		}
+	}
+	return res
 }
 
 func getPRGeneratorInfo(payload interface{}) *prGeneratorInfo {
@@ -181,15 +566,9 @@ func getPRGeneratorInfo(payload interface{}) *prGeneratorInfo {
 		}
 
 		apiURL := payload.Repository.URL
-		urlObj, err := url.Parse(apiURL)
-		if err != nil {
-			log.Errorf("Failed to parse repoURL '%s'", apiURL)
-			return nil
-		}
-		regexpStr := `(?i)(http://|https://|\w+@|ssh://(\w+@)?)` + urlObj.Hostname() + "(:[0-9]+|)[:/]"
-		apiRegexp, err := regexp.Compile(regexpStr)
+		apiRegexp, err := getAPIRegexp(apiURL)
 		if err != nil {
-			log.Errorf("Failed to compile regexp for repoURL '%s'", apiURL)
+			log.Errorf("Failed to compile regexp for repoURL '%s': %v", apiURL, err)
 			return nil
 		}
 		info.Github = &prGeneratorGithubInfo{
@@ -204,6 +583,79 @@ func getPRGeneratorInfo(payload interface{}) *prGeneratorInfo {
 	return &info
 }
 
+// getAPIRegexp returns a regexp matching the URLs of the host of apiURL.
+func getAPIRegexp(apiURL string) (*regexp.Regexp, error) {
+	urlObj, err := url.Parse(apiURL)
+	if err != nil {
+		return nil, err
+	}
+	regexpStr := `(?i)(http://|https://|\w+@|ssh://(\w+@)?)` + urlObj.Hostname() + "(:[0-9]+|)[:/]"
+	return regexp.Compile(regexpStr)
+}
+
+// getGithubAPIRegexp returns a regexp matching the API URL of the GitHub instance hosting the repository at webURL.
+func getGithubAPIRegexp(webURL string) (*regexp.Regexp, error) {
+	urlObj, err := url.Parse(webURL)
+	if err != nil {
+		return nil, err
+	}
+	// The API of github.com has its own host, while GitHub Enterprise serves its API from the same host
+	if strings.EqualFold(urlObj.Hostname(), "github.com") {
+		return getAPIRegexp("https://api.github.com/")
+	}
+	return getAPIRegexp(webURL)
+}
+
+func getSCMProviderGeneratorInfo(payload interface{}) *scmProviderGeneratorInfo {
+	var info scmProviderGeneratorInfo
+	switch payload := payload.(type) {
+	case github.PushPayload:
+		apiRegexp, err := getGithubAPIRegexp(payload.Repository.HTMLURL)
+		if err != nil {
+			log.Errorf("Failed to compile regexp for repoURL '%s': %v", payload.Repository.HTMLURL, err)
+			return nil
+		}
+		info.Github = &scmProviderGeneratorGithubInfo{
+			Organization: payload.Repository.Owner.Login,
+			APIRegexp:    apiRegexp,
+		}
+		info.TouchedHead = payload.Repository.DefaultBranch == parseRevision(payload.Ref)
+	case github.RepositoryPayload:
+		apiRegexp, err := getGithubAPIRegexp(payload.Repository.HTMLURL)
+		if err != nil {
+			log.Errorf("Failed to compile regexp for repoURL '%s': %v", payload.Repository.HTMLURL, err)
+			return nil
+		}
+		// The repository was created, deleted, archived, renamed, transferred, etc.
+		info.Github = &scmProviderGeneratorGithubInfo{
+			Organization: payload.Repository.Owner.Login,
+			APIRegexp:    apiRegexp,
+		}
+		info.TouchedHead = true
+	case gitlab.PushEventPayload:
+		apiRegexp, err := getAPIRegexp(payload.Project.WebURL)
+		if err != nil {
+			log.Errorf("Failed to compile regexp for repoURL '%s': %v", payload.Project.WebURL, err)
+			return nil
+		}
+		info.Gitlab = &scmProviderGeneratorGitlabInfo{
+			Namespace: path.Dir(payload.Project.PathWithNamespace),
+			APIRegexp: apiRegexp,
+		}
+		info.TouchedHead = payload.Project.DefaultBranch == parseRevision(payload.Ref)
+	case bitbucket.RepoPushPayload:
+		info.Bitbucket = &scmProviderGeneratorBitbucketInfo{
+			Workspace: strings.SplitN(payload.Repository.FullName, "/", 2)[0],
+		}
+		// The payload does not tell whether the default branch was changed, so assume it was
+		info.TouchedHead = true
+	default:
+		return nil
+	}
+
+	return &info
+}
+
 // allowedPullRequestActions is a list of actions that allow refresh
 var allowedPullRequestActions = []string{
 	"opened",
@@ -228,32 +680,157 @@ func shouldRefreshGitGenerator(gen *v1alpha1.GitGenerator, info *gitGeneratorInf
 		return false
 	}
 
-	if !gitGeneratorUsesURL(gen, info.Revision, info.RepoRegexp) {
+	if !gitGeneratorUsesURL(gen, info.RepoRegexps) {
+		return false
+	}
+	if !genRevisionHasChanged(gen, info.Revisions, info.TouchedHead) {
 		return false
 	}
-	if !genRevisionHasChanged(gen, info.Revision, info.TouchedHead) {
+	if info.ChangedFiles != nil && !gitGeneratorFilesChanged(gen, info.ChangedFiles) {
 		return false
 	}
 	return true
 }
 
-func genRevisionHasChanged(gen *v1alpha1.GitGenerator, revision string, touchedHead bool) bool {
+// gitGeneratorFilesChanged returns true if the changed files may change the parameters generated by the Git
+// generator, that is:
+// - for the files generator, if a changed file matches one of its patterns;
+// - for the directories generator, if a file was added to or removed from a directory matching one of its patterns
+// (or a subdirectory of it), or if the values file of such a directory was changed.
+func gitGeneratorFilesChanged(gen *v1alpha1.GitGenerator, changed *changedFiles) bool {
+	if len(gen.Files) == 0 && len(gen.Directories) == 0 {
+		return true
+	}
+
+	for _, filePath := range changed.AddedOrRemoved {
+		if gitFilesMatch(gen.Files, filePath) || gitDirectoriesMatchParent(gen.Directories, filePath) ||
+			isGitDirectoryValuesFile(gen.Directories, filePath) {
+			return true
+		}
+	}
+	for _, filePath := range changed.Modified {
+		if gitFilesMatch(gen.Files, filePath) || isGitDirectoryValuesFile(gen.Directories, filePath) {
+			return true
+		}
+	}
+	return false
+}
+
+// gitFilesMatch returns true if filePath matches one of the patterns of a files generator.
+func gitFilesMatch(files []v1alpha1.GitFileGeneratorItem, filePath string) bool {
+	for _, file := range files {
+		pathspec, err := pathspecRegexp(file.Path)
+		if err != nil {
+			log.Errorf("Failed to compile regexp for path '%s': %v", file.Path, err)
+			return true
+		}
+		if pathspec.MatchString(filePath) {
+			return true
+		}
+	}
+	return false
+}
+
+// gitDirectoriesMatchParent returns true if one of the parent directories of filePath matches one of the patterns,
+// whether included or excluded, of a directories generator.
+func gitDirectoriesMatchParent(directories []v1alpha1.GitDirectoryGeneratorItem, filePath string) bool {
+	for dir := path.Dir(filePath); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		for _, directory := range directories {
+			if match, err := path.Match(directory.Path, dir); err != nil || match {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isGitDirectoryValuesFile returns true if filePath is the values file of a directory matching one of the included
+// patterns of a directories generator.
+func isGitDirectoryValuesFile(directories []v1alpha1.GitDirectoryGeneratorItem, filePath string) bool {
+	for dir := path.Dir(filePath); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		for _, directory := range directories {
+			if directory.Exclude || directory.ValuesFile == "" || path.Join(dir, directory.ValuesFile) != filePath {
+				continue
+			}
+			if match, err := path.Match(directory.Path, dir); err != nil || match {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pathspecRegexp returns a regexp matching the paths matched by a Git pathspec, as used by the files generator:
+// unlike path.Match, '*' also matches '/', and a pattern also matches the files of the directories it matches.
+func pathspecRegexp(pattern string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				sb.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			}
+		case '[':
+			// A ']' right after the opening bracket, or its negation, belongs to the class
+			start := i + 1
+			if start < len(pattern) && pattern[start] == '!' {
+				start++
+			}
+			if start < len(pattern) && pattern[start] == ']' {
+				start++
+			}
+			end := strings.IndexByte(pattern[start:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			end += start
+			class := pattern[i+1 : end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i = end
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("(/.*)?$")
+	return regexp.Compile(sb.String())
+}
+
+// genRevisionHasChanged returns true if the revision of the Git generator is one of the changed revisions, or is
+// HEAD and the default branch was changed.
+func genRevisionHasChanged(gen *v1alpha1.GitGenerator, revisions []string, touchedHead bool) bool {
 	targetRev := parseRevision(gen.Revision)
 	if targetRev == "HEAD" || targetRev == "" { // revision is head
 		return touchedHead
 	}
 
-	return targetRev == revision
+	for _, revision := range revisions {
+		if targetRev == revision {
+			return true
+		}
+	}
+	return false
 }
 
-func gitGeneratorUsesURL(gen *v1alpha1.GitGenerator, webURL string, repoRegexp *regexp.Regexp) bool {
-	if !repoRegexp.MatchString(gen.RepoURL) {
-		log.Debugf("%s does not match %s", gen.RepoURL, repoRegexp.String())
-		return false
+func gitGeneratorUsesURL(gen *v1alpha1.GitGenerator, repoRegexps []*regexp.Regexp) bool {
+	for _, repoRegexp := range repoRegexps {
+		if repoRegexp.MatchString(gen.RepoURL) {
+			log.Debugf("%s matches %s", gen.RepoURL, repoRegexp.String())
+			return true
+		}
 	}
 
-	log.Debugf("%s uses repoURL %s", gen.RepoURL, webURL)
-	return true
+	log.Debugf("%s does not match any of %v", gen.RepoURL, repoRegexps)
+	return false
 }
 
 func shouldRefreshPRGenerator(gen *v1alpha1.PullRequestGenerator, info *prGeneratorInfo) bool {
@@ -282,10 +859,65 @@ func shouldRefreshPRGenerator(gen *v1alpha1.PullRequestGenerator, info *prGenera
 	return true
 }
 
-func refreshApplicationSet(c client.Client, appSet *v1alpha1.ApplicationSet) error {
+func shouldRefreshSCMProviderGenerator(gen *v1alpha1.SCMProviderGenerator, info *scmProviderGeneratorInfo) bool {
+	if gen == nil || info == nil {
+		return false
+	}
+
+	switch {
+	case gen.Github != nil && info.Github != nil:
+		if !strings.EqualFold(gen.Github.Organization, info.Github.Organization) {
+			return false
+		}
+		api := gen.Github.API
+		if api == "" {
+			api = "https://api.github.com/"
+		}
+		if !info.Github.APIRegexp.MatchString(api) {
+			log.Debugf("%s does not match %s", api, info.Github.APIRegexp.String())
+			return false
+		}
+		return info.TouchedHead || gen.Github.AllBranches
+	case gen.Gitlab != nil && info.Gitlab != nil:
+		if !gitlabGroupContainsNamespace(gen.Gitlab.Group, gen.Gitlab.IncludeSubgroups, info.Gitlab.Namespace) {
+			return false
+		}
+		api := gen.Gitlab.API
+		if api == "" {
+			api = "https://gitlab.com/"
+		}
+		if !info.Gitlab.APIRegexp.MatchString(api) {
+			log.Debugf("%s does not match %s", api, info.Gitlab.APIRegexp.String())
+			return false
+		}
+		return info.TouchedHead || gen.Gitlab.AllBranches
+	case gen.Bitbucket != nil && info.Bitbucket != nil:
+		if !strings.EqualFold(gen.Bitbucket.Owner, info.Bitbucket.Workspace) {
+			return false
+		}
+		return info.TouchedHead || gen.Bitbucket.AllBranches
+	}
+	return false
+}
+
+// gitlabGroupContainsNamespace returns true if the projects of the namespace are scanned by a generator of the group.
+// Since the group may be given by its ID, which is not part of webhook payloads, a numeric group contains all
+// namespaces.
+func gitlabGroupContainsNamespace(group string, includeSubgroups bool, namespace string) bool {
+	if _, err := strconv.Atoi(group); err == nil {
+		return true
+	}
+	group = strings.Trim(group, "/")
+	if strings.EqualFold(group, namespace) {
+		return true
+	}
+	return includeSubgroups && strings.HasPrefix(strings.ToLower(namespace), strings.ToLower(group)+"/")
+}
+
+func refreshApplicationSet(ctx context.Context, c client.Client, appSet *v1alpha1.ApplicationSet) error {
 	// patch the ApplicationSet with the refresh annotation to reconcile
 	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
-		err := c.Get(context.Background(), types.NamespacedName{Name: appSet.Name, Namespace: appSet.Namespace}, appSet)
+		err := c.Get(ctx, types.NamespacedName{Name: appSet.Name, Namespace: appSet.Namespace}, appSet)
 		if err != nil {
 			return err
 		}
@@ -293,6 +925,6 @@ func refreshApplicationSet(c client.Client, appSet *v1alpha1.ApplicationSet) err
 			appSet.Annotations = map[string]string{}
 		}
 		appSet.Annotations[common.AnnotationApplicationSetRefresh] = "true"
-		return c.Patch(context.Background(), appSet, client.Merge)
+		return c.Patch(ctx, appSet, client.Merge)
 	})
 }
@@ -0,0 +1,186 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/argoproj/applicationset/api/v1alpha1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// settingsWebhookRefreshSecretKey is the key, in argocd-secret, of the secret authenticating the requests of
+	// the refresh endpoint
+	settingsWebhookRefreshSecretKey = "webhook.applicationset.refresh.secret"
+	// refreshSignatureHeader is the header of the hex-encoded HMAC-SHA256 signature of a refresh request, prefixed by
+	// "sha256=". The signature covers the timestamp of the request and its body, as "<timestamp>.<body>".
+	refreshSignatureHeader = "X-ApplicationSet-Signature-256"
+	// refreshTimestampHeader is the header of the time at which a signed refresh request was sent, in seconds since
+	// the Unix epoch
+	refreshTimestampHeader = "X-ApplicationSet-Timestamp"
+	// maxRefreshRequestAge bounds the difference between the timestamp of a signed refresh request and the time at
+	// which it is received, so that a captured request can't be replayed later on
+	maxRefreshRequestAge = 5 * time.Minute
+	// maxRefreshRequestSize bounds the size of the body of a refresh request
+	maxRefreshRequestSize = 1024 * 1024
+)
+
+// RefreshRequest is the body of a request to the refresh endpoint, which refreshes either the ApplicationSet with
+// the given name, or all the ApplicationSets matching the given label selector.
+type RefreshRequest struct {
+	// Name is the name of the ApplicationSet to refresh
+	Name string `json:"name,omitempty"`
+	// Selector is a label selector (eg "team=a,env!=dev") of the ApplicationSets to refresh
+	Selector string `json:"selector,omitempty"`
+	// Namespace is the namespace of the ApplicationSets to refresh. It defaults to the namespace of the controller
+	// when refreshing an ApplicationSet by name, and to all the allowed namespaces when using a selector.
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// RefreshResponse is the body of the response to a refresh request whose ApplicationSets were found.
+type RefreshResponse struct {
+	// Refreshed lists the refreshed ApplicationSets, as "<namespace>/<name>"
+	Refreshed []string `json:"refreshed"`
+	// Failed lists the ApplicationSets which could not be refreshed, as "<namespace>/<name>"
+	Failed []string `json:"failed,omitempty"`
+}
+
+// RefreshHandler refreshes ApplicationSets on demand, for instance from a CI pipeline. The requests are
+// authenticated either by a bearer token, or by an HMAC-SHA256 signature of their timestamp and body, using the
+// secret stored under the "webhook.applicationset.refresh.secret" key of argocd-secret; the endpoint is disabled when
+// the secret is not set. If some of the ApplicationSets can't be refreshed, the others are refreshed all the same,
+// and the response lists both, with a 500 status.
+func (h *WebhookHandler) RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.refreshSecret == "" {
+		http.Error(w, "Refresh endpoint is disabled", http.StatusForbidden)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRefreshRequestSize))
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	if !isRefreshRequestAuthenticated(r, body, h.refreshSecret, time.Now()) {
+		log.Info("Refresh request failed authentication")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req RefreshRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid refresh request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if (req.Name == "") == (req.Selector == "") {
+		http.Error(w, "Invalid refresh request: exactly one of name and selector must be set", http.StatusBadRequest)
+		return
+	}
+
+	var appSets []v1alpha1.ApplicationSet
+	if req.Name != "" {
+		namespace := req.Namespace
+		if namespace == "" {
+			namespace = h.namespace
+		}
+		if !IsNamespaceAllowed(namespace, h.namespace, h.applicationSetNamespaces) {
+			http.Error(w, fmt.Sprintf("ApplicationSet %s/%s not found", namespace, req.Name), http.StatusNotFound)
+			return
+		}
+		appSet := v1alpha1.ApplicationSet{}
+		err := h.client.Get(r.Context(), types.NamespacedName{Name: req.Name, Namespace: namespace}, &appSet)
+		if apierr.IsNotFound(err) {
+			http.Error(w, fmt.Sprintf("ApplicationSet %s/%s not found", namespace, req.Name), http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Errorf("Failed to get ApplicationSet %s/%s: %v", namespace, req.Name, err)
+			http.Error(w, "Failed to get ApplicationSet", http.StatusInternalServerError)
+			return
+		}
+		appSets = append(appSets, appSet)
+	} else {
+		selector, err := labels.Parse(req.Selector)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid refresh request: invalid selector: %v", err), http.StatusBadRequest)
+			return
+		}
+		appSetList := &v1alpha1.ApplicationSetList{}
+		err = h.client.List(r.Context(), appSetList, &client.ListOptions{Namespace: req.Namespace, LabelSelector: selector})
+		if err != nil {
+			log.Errorf("Failed to list applicationsets: %v", err)
+			http.Error(w, "Failed to list ApplicationSets", http.StatusInternalServerError)
+			return
+		}
+		for _, appSet := range appSetList.Items {
+			if IsNamespaceAllowed(appSet.Namespace, h.namespace, h.applicationSetNamespaces) {
+				appSets = append(appSets, appSet)
+			}
+		}
+	}
+
+	res := RefreshResponse{Refreshed: []string{}}
+	for i := range appSets {
+		appSet := &appSets[i]
+		if err := refreshApplicationSet(r.Context(), h.client, appSet); err != nil {
+			log.Errorf("Failed to refresh ApplicationSet %s/%s for controller reprocessing: %v", appSet.Namespace, appSet.Name, err)
+			res.Failed = append(res.Failed, appSet.Namespace+"/"+appSet.Name)
+			continue
+		}
+		log.Infof("refresh ApplicationSet %v/%v from refresh request", appSet.Namespace, appSet.Name)
+		res.Refreshed = append(res.Refreshed, appSet.Namespace+"/"+appSet.Name)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(res.Failed) > 0 {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// isRefreshRequestAuthenticated returns true if the request carries either the secret as a bearer token, or a valid
+// HMAC-SHA256 signature of its timestamp and body, whose timestamp is within maxRefreshRequestAge of now.
+func isRefreshRequestAuthenticated(r *http.Request, body []byte, secret string, now time.Time) bool {
+	if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); token != r.Header.Get("Authorization") {
+		return subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+	}
+
+	signature := r.Header.Get(refreshSignatureHeader)
+	if !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	timestamp := r.Header.Get(refreshTimestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > maxRefreshRequestAge || age < -maxRefreshRequestAge {
+		log.Infof("Refresh request timestamp %s is not within %v of the current time", timestamp, maxRefreshRequestAge)
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(timestamp + "."))
+	_, _ = mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
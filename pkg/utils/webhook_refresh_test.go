@@ -0,0 +1,237 @@
+package utils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	argoprojiov1alpha1 "github.com/argoproj/applicationset/api/v1alpha1"
+	argosettings "github.com/argoproj/argo-cd/v2/util/settings"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRefreshHandler(t *testing.T) {
+	namespace := "test"
+	secret := "shhhh"
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	sign := func(timestamp string, body string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		_, _ = mac.Write([]byte(timestamp + "." + body))
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	tt := []struct {
+		desc               string
+		method             string
+		body               string
+		headers            map[string]string
+		disabled           bool
+		expectedStatusCode int
+		expectedRefreshed  []string
+	}{
+		{
+			desc:               "refresh by name with a bearer token",
+			body:               `{"name": "app-a"}`,
+			headers:            map[string]string{"Authorization": "Bearer " + secret},
+			expectedStatusCode: http.StatusOK,
+			expectedRefreshed:  []string{"app-a"},
+		},
+		{
+			desc:               "refresh by selector with a signature",
+			body:               `{"selector": "team=a"}`,
+			headers:            map[string]string{refreshSignatureHeader: sign(now, `{"selector": "team=a"}`), refreshTimestampHeader: now},
+			expectedStatusCode: http.StatusOK,
+			expectedRefreshed:  []string{"app-a", "app-a2", "app-other-namespace"},
+		},
+		{
+			desc:               "refresh by selector in a namespace",
+			body:               `{"selector": "team=a", "namespace": "test"}`,
+			headers:            map[string]string{"Authorization": "Bearer " + secret},
+			expectedStatusCode: http.StatusOK,
+			expectedRefreshed:  []string{"app-a", "app-a2"},
+		},
+		{
+			desc:               "refresh by name in a namespace which is not allowed",
+			body:               `{"name": "app-a", "namespace": "not-allowed"}`,
+			headers:            map[string]string{"Authorization": "Bearer " + secret},
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			desc:               "refresh of an unknown ApplicationSet",
+			body:               `{"name": "unknown"}`,
+			headers:            map[string]string{"Authorization": "Bearer " + secret},
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			desc:               "invalid bearer token",
+			body:               `{"name": "app-a"}`,
+			headers:            map[string]string{"Authorization": "Bearer wrong"},
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			desc:               "signature of another body",
+			body:               `{"name": "app-b"}`,
+			headers:            map[string]string{refreshSignatureHeader: sign(now, `{"name": "app-a"}`), refreshTimestampHeader: now},
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			desc:               "signature without timestamp",
+			body:               `{"name": "app-a"}`,
+			headers:            map[string]string{refreshSignatureHeader: sign("", `{"name": "app-a"}`)},
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			desc:               "signature of another timestamp",
+			body:               `{"name": "app-a"}`,
+			headers:            map[string]string{refreshSignatureHeader: sign(stale, `{"name": "app-a"}`), refreshTimestampHeader: now},
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			desc:               "replayed signed request",
+			body:               `{"name": "app-a"}`,
+			headers:            map[string]string{refreshSignatureHeader: sign(stale, `{"name": "app-a"}`), refreshTimestampHeader: stale},
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			desc:               "no authentication",
+			body:               `{"name": "app-a"}`,
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			desc:               "both name and selector",
+			body:               `{"name": "app-a", "selector": "team=a"}`,
+			headers:            map[string]string{"Authorization": "Bearer " + secret},
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			desc:               "invalid selector",
+			body:               `{"selector": "team in (a"}`,
+			headers:            map[string]string{"Authorization": "Bearer " + secret},
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			desc:               "GET request",
+			method:             "GET",
+			headers:            map[string]string{"Authorization": "Bearer " + secret},
+			expectedStatusCode: http.StatusMethodNotAllowed,
+		},
+		{
+			desc:               "no secret configured",
+			body:               `{"name": "app-a"}`,
+			headers:            map[string]string{"Authorization": "Bearer "},
+			disabled:           true,
+			expectedStatusCode: http.StatusForbidden,
+		},
+	}
+
+	scheme := runtime.NewScheme()
+	err := argoprojiov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+
+	for _, test := range tt {
+		t.Run(test.desc, func(t *testing.T) {
+			secretData := map[string][]byte{settingsWebhookRefreshSecretKey: []byte(secret)}
+			if test.disabled {
+				secretData = nil
+			}
+			fakeClient := newFakeClientWithSecretData(namespace, secretData)
+
+			fc := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+				fakeAppWithLabels("app-a", namespace, map[string]string{"team": "a"}),
+				fakeAppWithLabels("app-a2", namespace, map[string]string{"team": "a"}),
+				fakeAppWithLabels("app-b", namespace, map[string]string{"team": "b"}),
+				fakeAppWithLabels("app-other-namespace", "other", map[string]string{"team": "a"}),
+				fakeAppWithLabels("app-a", "not-allowed", map[string]string{"team": "a"}),
+			).Build()
+			set := argosettings.NewSettingsManager(context.TODO(), fakeClient, namespace)
+			h, err := NewWebhookHandler(namespace, []string{"other"}, set, fc, 1, 1)
+			assert.Nil(t, err)
+
+			method := test.method
+			if method == "" {
+				method = "POST"
+			}
+			req := httptest.NewRequest(method, "/api/webhook/refresh", strings.NewReader(test.body))
+			for key, value := range test.headers {
+				req.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+
+			h.RefreshHandler(w, req)
+			assert.Equal(t, test.expectedStatusCode, w.Code, w.Body.String())
+
+			list := &argoprojiov1alpha1.ApplicationSetList{}
+			err = fc.List(context.TODO(), list)
+			assert.Nil(t, err)
+			var refreshed []string
+			for _, appSet := range list.Items {
+				if appSet.RefreshRequired() {
+					refreshed = append(refreshed, appSet.Name)
+				}
+			}
+			assert.ElementsMatch(t, test.expectedRefreshed, refreshed)
+		})
+	}
+}
+
+// failingPatchClient fails to patch the ApplicationSet with the given name
+type failingPatchClient struct {
+	client.Client
+	name string
+}
+
+func (c *failingPatchClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if obj.GetName() == c.name {
+		return fmt.Errorf("patch failed")
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func TestRefreshHandlerPartialFailure(t *testing.T) {
+	namespace := "test"
+	scheme := runtime.NewScheme()
+	err := argoprojiov1alpha1.AddToScheme(scheme)
+	assert.Nil(t, err)
+
+	fakeClient := newFakeClientWithSecretData(namespace, map[string][]byte{settingsWebhookRefreshSecretKey: []byte("shhhh")})
+	fc := &failingPatchClient{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			fakeAppWithLabels("app-a", namespace, map[string]string{"team": "a"}),
+			fakeAppWithLabels("app-a2", namespace, map[string]string{"team": "a"}),
+		).Build(),
+		name: "app-a",
+	}
+	set := argosettings.NewSettingsManager(context.TODO(), fakeClient, namespace)
+	h, err := NewWebhookHandler(namespace, nil, set, fc, 1, 1)
+	assert.Nil(t, err)
+
+	req := httptest.NewRequest("POST", "/api/webhook/refresh", strings.NewReader(`{"selector": "team=a"}`))
+	req.Header.Set("Authorization", "Bearer shhhh")
+	w := httptest.NewRecorder()
+	h.RefreshHandler(w, req)
+
+	// The ApplicationSets which could be refreshed are, and the response tells which ones could not
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	var res RefreshResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, RefreshResponse{Refreshed: []string{"test/app-a2"}, Failed: []string{"test/app-a"}}, res)
+}
+
+func fakeAppWithLabels(name, namespace string, labels map[string]string) *argoprojiov1alpha1.ApplicationSet {
+	appSet := fakeAppWithGitGenerator(name, namespace, "https://github.com/org/repo")
+	appSet.Labels = labels
+	return appSet
+}
@@ -3,20 +3,29 @@ package utils
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/argoproj/applicationset/api/v1alpha1"
 	argoprojiov1alpha1 "github.com/argoproj/applicationset/api/v1alpha1"
 	"github.com/argoproj/argo-cd/v2/common"
 	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
 	argosettings "github.com/argoproj/argo-cd/v2/util/settings"
+	gogsclient "github.com/gogits/go-gogs-client"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/go-playground/webhooks.v5/bitbucket"
+	bitbucketserver "gopkg.in/go-playground/webhooks.v5/bitbucket-server"
+	"gopkg.in/go-playground/webhooks.v5/github"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	kubefake "k8s.io/client-go/kubernetes/fake"
@@ -28,6 +37,7 @@ func TestWebhookHandler(t *testing.T) {
 		desc               string
 		headerKey          string
 		headerValue        string
+		extraHeaders       map[string]string
 		effectedAppSets    []string
 		payloadFile        string
 		expectedStatusCode int
@@ -38,8 +48,8 @@ func TestWebhookHandler(t *testing.T) {
 			headerKey:          "X-GitHub-Event",
 			headerValue:        "push",
 			payloadFile:        "github-commit-event.json",
-			effectedAppSets:    []string{"git-github"},
-			expectedStatusCode: http.StatusOK,
+			effectedAppSets:    []string{"git-github", "scm-github", "matrix-git-github"},
+			expectedStatusCode: http.StatusAccepted,
 			expectedRefresh:    true,
 		},
 		{
@@ -47,8 +57,64 @@ func TestWebhookHandler(t *testing.T) {
 			headerKey:          "X-Gitlab-Event",
 			headerValue:        "Push Hook",
 			payloadFile:        "gitlab-event.json",
+			effectedAppSets:    []string{"git-gitlab", "scm-gitlab", "git-gitlab-files"},
+			expectedStatusCode: http.StatusAccepted,
+			expectedRefresh:    true,
+		},
+		{
+			desc:               "WebHook from a GitLab repository via merge request event",
+			headerKey:          "X-Gitlab-Event",
+			headerValue:        "Merge Request Hook",
+			payloadFile:        "gitlab-merge-request-event.json",
 			effectedAppSets:    []string{"git-gitlab"},
-			expectedStatusCode: http.StatusOK,
+			expectedStatusCode: http.StatusAccepted,
+			expectedRefresh:    false,
+		},
+		{
+			desc:               "WebHook from a Bitbucket Cloud repository via Commit",
+			headerKey:          "X-Event-Key",
+			headerValue:        "repo:push",
+			extraHeaders:       map[string]string{"X-Hook-UUID": "uuid"},
+			payloadFile:        "bitbucket-event.json",
+			effectedAppSets:    []string{"git-bitbucket", "scm-bitbucket"},
+			expectedStatusCode: http.StatusAccepted,
+			expectedRefresh:    true,
+		},
+		{
+			desc:               "WebHook from a Bitbucket Server repository via Commit",
+			headerKey:          "X-Event-Key",
+			headerValue:        "repo:refs_changed",
+			payloadFile:        "bitbucket-server-event.json",
+			effectedAppSets:    []string{"git-bitbucket-server"},
+			expectedStatusCode: http.StatusAccepted,
+			expectedRefresh:    true,
+		},
+		{
+			desc:               "WebHook from a Bitbucket Server via ping event",
+			headerKey:          "X-Event-Key",
+			headerValue:        "diagnostics:ping",
+			payloadFile:        "invalid-event.json",
+			effectedAppSets:    []string{"git-bitbucket-server"},
+			expectedStatusCode: http.StatusAccepted,
+			expectedRefresh:    false,
+		},
+		{
+			desc:               "WebHook from a Gitea repository via Commit",
+			headerKey:          "X-Gogs-Event",
+			headerValue:        "push",
+			extraHeaders:       map[string]string{"X-GitHub-Event": "push", "X-Gitea-Event": "push"},
+			payloadFile:        "gogs-event.json",
+			effectedAppSets:    []string{"git-gitea"},
+			expectedStatusCode: http.StatusAccepted,
+			expectedRefresh:    true,
+		},
+		{
+			desc:               "WebHook from a GitHub organization via repository created event",
+			headerKey:          "X-GitHub-Event",
+			headerValue:        "repository",
+			payloadFile:        "github-repository-event.json",
+			effectedAppSets:    []string{"scm-github"},
+			expectedStatusCode: http.StatusAccepted,
 			expectedRefresh:    true,
 		},
 		{
@@ -74,8 +140,8 @@ func TestWebhookHandler(t *testing.T) {
 			headerKey:          "X-GitHub-Event",
 			headerValue:        "pull_request",
 			payloadFile:        "github-pull-request-opened-event.json",
-			effectedAppSets:    []string{"pull-request-github"},
-			expectedStatusCode: http.StatusOK,
+			effectedAppSets:    []string{"pull-request-github", "merge-matrix-pull-request-github"},
+			expectedStatusCode: http.StatusAccepted,
 			expectedRefresh:    true,
 		},
 		{
@@ -84,7 +150,7 @@ func TestWebhookHandler(t *testing.T) {
 			headerValue:        "pull_request",
 			payloadFile:        "github-pull-request-assigned-event.json",
 			effectedAppSets:    []string{"pull-request-github"},
-			expectedStatusCode: http.StatusOK,
+			expectedStatusCode: http.StatusAccepted,
 			expectedRefresh:    false,
 		},
 	}
@@ -102,20 +168,43 @@ func TestWebhookHandler(t *testing.T) {
 			fc := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
 				fakeAppWithGitGenerator("git-github", namespace, "https://github.com/org/repo"),
 				fakeAppWithGitGenerator("git-gitlab", namespace, "https://gitlab/group/name"),
+				fakeAppWithGitGenerator("git-bitbucket", namespace, "git@bitbucket.org:org/repo.git"),
+				fakeAppWithGitGenerator("git-bitbucket-server", namespace, "ssh://git@bitbucketserver:7999/proj/repo.git"),
+				fakeAppWithGitGenerator("git-gitea", namespace, "https://gitea/org/repo.git"),
 				fakeAppWithPullRequestGenerator("pull-request-github", namespace, "Codertocat", "Hello-World"),
+				fakeAppWithSCMProviderGenerator("scm-github", namespace, argoprojiov1alpha1.SCMProviderGenerator{
+					Github: &argoprojiov1alpha1.SCMProviderGeneratorGithub{Organization: "org"},
+				}),
+				fakeAppWithSCMProviderGenerator("scm-gitlab", namespace, argoprojiov1alpha1.SCMProviderGenerator{
+					Gitlab: &argoprojiov1alpha1.SCMProviderGeneratorGitlab{Group: "group", API: "https://gitlab/"},
+				}),
+				fakeAppWithSCMProviderGenerator("scm-bitbucket", namespace, argoprojiov1alpha1.SCMProviderGenerator{
+					Bitbucket: &argoprojiov1alpha1.SCMProviderGeneratorBitbucket{Owner: "org"},
+				}),
+				fakeAppWithGitGeneratorPaths("git-gitlab-files", namespace, "https://gitlab/group/name", nil,
+					[]argoprojiov1alpha1.GitFileGeneratorItem{{Path: "*.yaml"}}),
+				fakeAppWithGitGeneratorPaths("git-gitlab-directories", namespace, "https://gitlab/group/name",
+					[]argoprojiov1alpha1.GitDirectoryGeneratorItem{{Path: "apps/*"}}, nil),
+				fakeAppWithMatrixAndGitGenerator("matrix-git-github", namespace, "https://github.com/org/repo"),
+				fakeAppWithMergeAndNestedMatrixAndPullRequestGenerator("merge-matrix-pull-request-github", namespace, "Codertocat", "Hello-World"),
 			).Build()
 			set := argosettings.NewSettingsManager(context.TODO(), fakeClient, namespace)
-			h, err := NewWebhookHandler(namespace, set, fc)
+			h, err := NewWebhookHandler(namespace, nil, set, fc, 1, 10)
 			assert.Nil(t, err)
 
 			req := httptest.NewRequest("POST", "/api/webhook", nil)
 			req.Header.Set(test.headerKey, test.headerValue)
+			for key, value := range test.extraHeaders {
+				req.Header.Set(key, value)
+			}
 			eventJSON, err := ioutil.ReadFile(filepath.Join("testdata", test.payloadFile))
 			assert.NoError(t, err)
 			req.Body = ioutil.NopCloser(bytes.NewReader(eventJSON))
 			w := httptest.NewRecorder()
 
 			h.Handler(w, req)
+			// Wait for the queued event to be handled
+			assert.NoError(t, h.Shutdown(context.Background()))
 			assert.Equal(t, w.Code, test.expectedStatusCode)
 
 			list := &argoprojiov1alpha1.ApplicationSetList{}
@@ -123,29 +212,401 @@ func TestWebhookHandler(t *testing.T) {
 			assert.Nil(t, err)
 			for i := range list.Items {
 				gotAppSet := &list.Items[i]
+				effected := false
 				for _, appSetName := range test.effectedAppSets {
-					if appSetName == gotAppSet.Name {
-						if expected, got := test.expectedRefresh, gotAppSet.RefreshRequired(); expected != got {
-							t.Errorf("unexpected RefreshRequired() expect: %v got: %v", expected, got)
-						}
-					} else {
-						assert.False(t, gotAppSet.RefreshRequired())
+					effected = effected || appSetName == gotAppSet.Name
+				}
+				if effected {
+					if expected, got := test.expectedRefresh, gotAppSet.RefreshRequired(); expected != got {
+						t.Errorf("unexpected RefreshRequired() of %s expect: %v got: %v", gotAppSet.Name, expected, got)
 					}
+				} else {
+					assert.False(t, gotAppSet.RefreshRequired(), gotAppSet.Name)
 				}
 			}
 		})
 	}
 }
 
+func TestWebhookQueue(t *testing.T) {
+	// The workers are started once the queue is filled
+	h := &WebhookHandler{
+		queue:   make(chan webhookEvent, 2),
+		pending: map[string]bool{},
+	}
+	depth := testutil.ToFloat64(webhookQueueDepth)
+
+	assert.True(t, h.enqueue(webhookEvent{key: "a"}))
+	assert.True(t, h.enqueue(webhookEvent{key: "a"}), "identical event is accepted")
+	assert.Len(t, h.queue, 1, "identical event is not queued")
+	assert.True(t, h.enqueue(webhookEvent{key: "b"}))
+	assert.False(t, h.enqueue(webhookEvent{key: "c"}), "event is rejected when the queue is full")
+	assert.Len(t, h.queue, 2)
+	assert.Equal(t, depth+2, testutil.ToFloat64(webhookQueueDepth))
+
+	h.startWorkerPool(2)
+	assert.NoError(t, h.Shutdown(context.Background()))
+	assert.Empty(t, h.pending)
+	assert.Equal(t, depth, testutil.ToFloat64(webhookQueueDepth))
+
+	assert.False(t, h.enqueue(webhookEvent{key: "d"}), "event is rejected once the handler is shut down")
+	assert.NoError(t, h.Shutdown(context.Background()), "handler can be shut down twice")
+}
+
+func TestWebhookShutdownTimeout(t *testing.T) {
+	// The queued event is never handled, since no worker is started
+	h := &WebhookHandler{
+		queue:   make(chan webhookEvent, 1),
+		pending: map[string]bool{},
+	}
+	assert.True(t, h.enqueue(webhookEvent{key: "a"}))
+	h.Add(1)
+	defer h.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := h.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	<-h.queue
+	webhookQueueDepth.Dec()
+}
+
+func TestWebhookRequestTooLarge(t *testing.T) {
+	h := &WebhookHandler{
+		queue:   make(chan webhookEvent, 1),
+		pending: map[string]bool{},
+	}
+	req := httptest.NewRequest("POST", "/api/webhook", bytes.NewReader(make([]byte, maxWebhookRequestSize+1)))
+	req.Header.Set("X-GitHub-Event", "push")
+	w := httptest.NewRecorder()
+
+	h.Handler(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Empty(t, h.queue)
+}
+
+func TestWebhookEventKey(t *testing.T) {
+	newRequest := func(event, delivery string) *http.Request {
+		req := httptest.NewRequest("POST", "/api/webhook", nil)
+		req.Header.Set("X-GitHub-Event", event)
+		req.Header.Set("X-GitHub-Delivery", delivery)
+		return req
+	}
+
+	key := webhookEventKey(newRequest("push", "1"), []byte("{}"))
+	assert.Equal(t, key, webhookEventKey(newRequest("push", "2"), []byte("{}")), "retry of the event")
+	assert.NotEqual(t, key, webhookEventKey(newRequest("push", "1"), []byte("{ }")), "other body")
+	assert.NotEqual(t, key, webhookEventKey(newRequest("pull_request", "1"), []byte("{}")), "other event")
+}
+
 func TestGenRevisionHasChanged(t *testing.T) {
-	assert.True(t, genRevisionHasChanged(&v1alpha1.GitGenerator{}, "master", true))
-	assert.False(t, genRevisionHasChanged(&v1alpha1.GitGenerator{}, "master", false))
+	assert.True(t, genRevisionHasChanged(&v1alpha1.GitGenerator{}, []string{"master"}, true))
+	assert.False(t, genRevisionHasChanged(&v1alpha1.GitGenerator{}, []string{"master"}, false))
+
+	assert.True(t, genRevisionHasChanged(&v1alpha1.GitGenerator{Revision: "dev"}, []string{"dev"}, true))
+	assert.False(t, genRevisionHasChanged(&v1alpha1.GitGenerator{Revision: "dev"}, []string{"master"}, false))
+
+	assert.True(t, genRevisionHasChanged(&v1alpha1.GitGenerator{Revision: "refs/heads/dev"}, []string{"dev"}, true))
+	assert.False(t, genRevisionHasChanged(&v1alpha1.GitGenerator{Revision: "refs/heads/dev"}, []string{"master"}, false))
+
+	// A push may change several revisions
+	assert.True(t, genRevisionHasChanged(&v1alpha1.GitGenerator{Revision: "dev"}, []string{"master", "dev"}, true))
+	assert.False(t, genRevisionHasChanged(&v1alpha1.GitGenerator{Revision: "dev"}, []string{"master", "staging"}, true))
+}
+
+func TestGetGitGeneratorInfoBitbucketPushOfSeveralBranches(t *testing.T) {
+	var bitbucketPayload bitbucket.RepoPushPayload
+	assert.NoError(t, json.Unmarshal([]byte(`{
+		"repository": {"links": {"html": {"href": "https://bitbucket.org/org/repo"}}},
+		"push": {"changes": [
+			{"new": {"name": "master"}, "old": {"name": "master"}},
+			{"new": {"name": "dev"}, "old": {"name": "dev"}},
+			{"new": null, "old": {"name": "deleted"}}
+		]}
+	}`), &bitbucketPayload))
+	var bitbucketServerPayload bitbucketserver.RepositoryReferenceChangedPayload
+	assert.NoError(t, json.Unmarshal([]byte(`{
+		"repository": {"links": {"clone": [{"href": "ssh://git@bitbucketserver:7999/proj/repo.git", "name": "ssh"}]}},
+		"changes": [
+			{"ref": {"id": "refs/heads/master"}, "type": "UPDATE"},
+			{"ref": {"id": "refs/heads/dev"}, "type": "UPDATE"},
+			{"ref": {"id": "refs/heads/deleted"}, "type": "DELETE"}
+		]
+	}`), &bitbucketServerPayload))
+
+	for _, payload := range []interface{}{bitbucketPayload, bitbucketServerPayload} {
+		info := getGitGeneratorInfo(payload)
+		if !assert.NotNil(t, info) {
+			continue
+		}
+		assert.Equal(t, []string{"master", "dev", "deleted"}, info.Revisions)
+		// Generators tracking any of the branches are refreshed
+		assert.True(t, genRevisionHasChanged(&v1alpha1.GitGenerator{Revision: "dev"}, info.Revisions, info.TouchedHead))
+		assert.True(t, genRevisionHasChanged(&v1alpha1.GitGenerator{Revision: "deleted"}, info.Revisions, info.TouchedHead))
+		assert.False(t, genRevisionHasChanged(&v1alpha1.GitGenerator{Revision: "staging"}, info.Revisions, info.TouchedHead))
+	}
+}
+
+func TestGitGeneratorFilesChanged(t *testing.T) {
+	tests := []struct {
+		name        string
+		directories []argoprojiov1alpha1.GitDirectoryGeneratorItem
+		files       []argoprojiov1alpha1.GitFileGeneratorItem
+		changed     changedFiles
+		expected    bool
+	}{
+		{
+			name:     "no directories nor files",
+			changed:  changedFiles{Modified: []string{"README.md"}},
+			expected: true,
+		},
+		{
+			name:        "file added to a matching directory",
+			directories: []argoprojiov1alpha1.GitDirectoryGeneratorItem{{Path: "apps/*"}},
+			changed:     changedFiles{AddedOrRemoved: []string{"apps/app1/deployment.yaml"}},
+			expected:    true,
+		},
+		{
+			name:        "file added to a subdirectory of a matching directory",
+			directories: []argoprojiov1alpha1.GitDirectoryGeneratorItem{{Path: "apps/*"}},
+			changed:     changedFiles{AddedOrRemoved: []string{"apps/app1/base/deployment.yaml"}},
+			expected:    true,
+		},
+		{
+			name:        "file added to an excluded directory",
+			directories: []argoprojiov1alpha1.GitDirectoryGeneratorItem{{Path: "apps/*"}, {Path: "apps/excluded", Exclude: true}},
+			changed:     changedFiles{AddedOrRemoved: []string{"apps/excluded/deployment.yaml"}},
+			expected:    true,
+		},
+		{
+			name:        "file added to another directory",
+			directories: []argoprojiov1alpha1.GitDirectoryGeneratorItem{{Path: "apps/*"}},
+			changed:     changedFiles{AddedOrRemoved: []string{"docs/README.md", "apps/README.md"}},
+			expected:    false,
+		},
+		{
+			name:        "file modified in a matching directory",
+			directories: []argoprojiov1alpha1.GitDirectoryGeneratorItem{{Path: "apps/*"}},
+			changed:     changedFiles{Modified: []string{"apps/app1/deployment.yaml"}},
+			expected:    false,
+		},
+		{
+			name:        "values file modified in a matching directory",
+			directories: []argoprojiov1alpha1.GitDirectoryGeneratorItem{{Path: "apps/*", ValuesFile: "config/values.yaml"}},
+			changed:     changedFiles{Modified: []string{"apps/app1/config/values.yaml"}},
+			expected:    true,
+		},
+		{
+			name:        "values file modified in an excluded directory",
+			directories: []argoprojiov1alpha1.GitDirectoryGeneratorItem{{Path: "apps/app1", Exclude: true, ValuesFile: "values.yaml"}},
+			changed:     changedFiles{Modified: []string{"apps/app1/values.yaml"}},
+			expected:    false,
+		},
+		{
+			name:     "file modified matching a pattern",
+			files:    []argoprojiov1alpha1.GitFileGeneratorItem{{Path: "cluster-config/**/config.json"}},
+			changed:  changedFiles{Modified: []string{"cluster-config/engineering/prod/config.json"}},
+			expected: true,
+		},
+		{
+			name:     "file removed matching a pattern",
+			files:    []argoprojiov1alpha1.GitFileGeneratorItem{{Path: "clusters/[a-c]*.yaml"}},
+			changed:  changedFiles{AddedOrRemoved: []string{"clusters/b.yaml"}},
+			expected: true,
+		},
+		{
+			name:     "file within a directory pattern",
+			files:    []argoprojiov1alpha1.GitFileGeneratorItem{{Path: "clusters"}},
+			changed:  changedFiles{Modified: []string{"clusters/a.yaml"}},
+			expected: true,
+		},
+		{
+			name:     "file not matching any pattern",
+			files:    []argoprojiov1alpha1.GitFileGeneratorItem{{Path: "clusters/[a-c]*.yaml"}, {Path: "config.json"}},
+			changed:  changedFiles{AddedOrRemoved: []string{"clusters/d.yaml"}, Modified: []string{"other/config.json"}},
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gen := &argoprojiov1alpha1.GitGenerator{Directories: test.directories, Files: test.files}
+			assert.Equal(t, test.expected, gitGeneratorFilesChanged(gen, &test.changed))
+		})
+	}
+}
+
+func TestGetGitGeneratorInfoTruncatedPush(t *testing.T) {
+	newPayload := func(commits int) []byte {
+		payload := map[string]interface{}{
+			"ref":        "refs/heads/main",
+			"before":     "1111111111111111111111111111111111111111",
+			"after":      "2222222222222222222222222222222222222222",
+			"repository": map[string]interface{}{"html_url": "https://example.com/org/repo", "default_branch": "main"},
+		}
+		var payloadCommits []map[string]interface{}
+		for i := 0; i < commits; i++ {
+			payloadCommits = append(payloadCommits, map[string]interface{}{"modified": []string{fmt.Sprintf("apps/app%d/values.yaml", i)}})
+		}
+		payload["commits"] = payloadCommits
+		data, err := json.Marshal(payload)
+		assert.NoError(t, err)
+		return data
+	}
+
+	for _, c := range []struct {
+		commits         int
+		expectedChanges bool
+	}{
+		{commits: 19, expectedChanges: true},
+		// The payload may only list some of the commits of the push, so the changed files are unknown
+		{commits: 20, expectedChanges: false},
+	} {
+		t.Run(fmt.Sprintf("%d commits", c.commits), func(t *testing.T) {
+			var githubPayload github.PushPayload
+			assert.NoError(t, json.Unmarshal(newPayload(c.commits), &githubPayload))
+			var gogsPayload gogsclient.PushPayload
+			assert.NoError(t, json.Unmarshal(newPayload(c.commits), &gogsPayload))
+
+			for _, payload := range []interface{}{githubPayload, gogsPayload} {
+				info := getGitGeneratorInfo(payload)
+				if !assert.NotNil(t, info) {
+					continue
+				}
+				assert.Equal(t, []string{"main"}, info.Revisions)
+				if c.expectedChanges {
+					if assert.NotNil(t, info.ChangedFiles) {
+						assert.Len(t, info.ChangedFiles.Modified, c.commits)
+					}
+				} else {
+					assert.Nil(t, info.ChangedFiles)
+				}
+			}
+		})
+	}
+}
 
-	assert.True(t, genRevisionHasChanged(&v1alpha1.GitGenerator{Revision: "dev"}, "dev", true))
-	assert.False(t, genRevisionHasChanged(&v1alpha1.GitGenerator{Revision: "dev"}, "master", false))
+func TestPathspecRegexp(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		path     string
+		expected bool
+	}{
+		{"*.yaml", "a.yaml", true},
+		{"*.yaml", "dir/a.yaml", true},
+		{"*.yaml", "a.json", false},
+		{"dir/?.yaml", "dir/a.yaml", true},
+		{"dir/?.yaml", "dir/ab.yaml", false},
+		{"[!a]*.yaml", "b.yaml", true},
+		{"[!a]*.yaml", "a.yaml", false},
+		{"[]]*", "]a", true},
+		{"config.json", "config.json", true},
+		{"config.json", "config.json.bak", false},
+		{"dir", "dir/config.json", true},
+		{"a+b[", "a+b[", true},
+		{`\*.yaml`, "*.yaml", true},
+		{`\*.yaml`, "a.yaml", false},
+	}
 
-	assert.True(t, genRevisionHasChanged(&v1alpha1.GitGenerator{Revision: "refs/heads/dev"}, "dev", true))
-	assert.False(t, genRevisionHasChanged(&v1alpha1.GitGenerator{Revision: "refs/heads/dev"}, "master", false))
+	for _, test := range tests {
+		pathspec, err := pathspecRegexp(test.pattern)
+		if assert.NoError(t, err, test.pattern) {
+			assert.Equal(t, test.expected, pathspec.MatchString(test.path), "%s %s", test.pattern, test.path)
+		}
+	}
+}
+
+func TestShouldRefreshSCMProviderGenerator(t *testing.T) {
+	githubAPIRegexp, err := getGithubAPIRegexp("https://github.com/org/repo")
+	assert.NoError(t, err)
+	gheAPIRegexp, err := getGithubAPIRegexp("https://ghe.example.com/org/repo")
+	assert.NoError(t, err)
+	gitlabAPIRegexp, err := getAPIRegexp("https://gitlab.com/group/subgroup/repo")
+	assert.NoError(t, err)
+
+	for _, c := range []struct {
+		name     string
+		gen      argoprojiov1alpha1.SCMProviderGenerator
+		info     scmProviderGeneratorInfo
+		expected bool
+	}{
+		{
+			name:     "github default branch",
+			gen:      argoprojiov1alpha1.SCMProviderGenerator{Github: &argoprojiov1alpha1.SCMProviderGeneratorGithub{Organization: "Org"}},
+			info:     scmProviderGeneratorInfo{Github: &scmProviderGeneratorGithubInfo{Organization: "org", APIRegexp: githubAPIRegexp}, TouchedHead: true},
+			expected: true,
+		},
+		{
+			name:     "github other branch",
+			gen:      argoprojiov1alpha1.SCMProviderGenerator{Github: &argoprojiov1alpha1.SCMProviderGeneratorGithub{Organization: "org"}},
+			info:     scmProviderGeneratorInfo{Github: &scmProviderGeneratorGithubInfo{Organization: "org", APIRegexp: githubAPIRegexp}},
+			expected: false,
+		},
+		{
+			name:     "github other branch with all branches",
+			gen:      argoprojiov1alpha1.SCMProviderGenerator{Github: &argoprojiov1alpha1.SCMProviderGeneratorGithub{Organization: "org", AllBranches: true}},
+			info:     scmProviderGeneratorInfo{Github: &scmProviderGeneratorGithubInfo{Organization: "org", APIRegexp: githubAPIRegexp}},
+			expected: true,
+		},
+		{
+			name:     "github other organization",
+			gen:      argoprojiov1alpha1.SCMProviderGenerator{Github: &argoprojiov1alpha1.SCMProviderGeneratorGithub{Organization: "other"}},
+			info:     scmProviderGeneratorInfo{Github: &scmProviderGeneratorGithubInfo{Organization: "org", APIRegexp: githubAPIRegexp}, TouchedHead: true},
+			expected: false,
+		},
+		{
+			name:     "github enterprise",
+			gen:      argoprojiov1alpha1.SCMProviderGenerator{Github: &argoprojiov1alpha1.SCMProviderGeneratorGithub{Organization: "org", API: "https://ghe.example.com/api/v3"}},
+			info:     scmProviderGeneratorInfo{Github: &scmProviderGeneratorGithubInfo{Organization: "org", APIRegexp: gheAPIRegexp}, TouchedHead: true},
+			expected: true,
+		},
+		{
+			name:     "github enterprise event for github.com generator",
+			gen:      argoprojiov1alpha1.SCMProviderGenerator{Github: &argoprojiov1alpha1.SCMProviderGeneratorGithub{Organization: "org"}},
+			info:     scmProviderGeneratorInfo{Github: &scmProviderGeneratorGithubInfo{Organization: "org", APIRegexp: gheAPIRegexp}, TouchedHead: true},
+			expected: false,
+		},
+		{
+			name:     "gitlab event for github generator",
+			gen:      argoprojiov1alpha1.SCMProviderGenerator{Github: &argoprojiov1alpha1.SCMProviderGeneratorGithub{Organization: "group"}},
+			info:     scmProviderGeneratorInfo{Gitlab: &scmProviderGeneratorGitlabInfo{Namespace: "group", APIRegexp: gitlabAPIRegexp}, TouchedHead: true},
+			expected: false,
+		},
+		{
+			name:     "gitlab subgroup",
+			gen:      argoprojiov1alpha1.SCMProviderGenerator{Gitlab: &argoprojiov1alpha1.SCMProviderGeneratorGitlab{Group: "group"}},
+			info:     scmProviderGeneratorInfo{Gitlab: &scmProviderGeneratorGitlabInfo{Namespace: "group/subgroup", APIRegexp: gitlabAPIRegexp}, TouchedHead: true},
+			expected: false,
+		},
+		{
+			name:     "gitlab subgroup with include subgroups",
+			gen:      argoprojiov1alpha1.SCMProviderGenerator{Gitlab: &argoprojiov1alpha1.SCMProviderGeneratorGitlab{Group: "group", IncludeSubgroups: true}},
+			info:     scmProviderGeneratorInfo{Gitlab: &scmProviderGeneratorGitlabInfo{Namespace: "group/subgroup", APIRegexp: gitlabAPIRegexp}, TouchedHead: true},
+			expected: true,
+		},
+		{
+			name:     "gitlab group ID",
+			gen:      argoprojiov1alpha1.SCMProviderGenerator{Gitlab: &argoprojiov1alpha1.SCMProviderGeneratorGitlab{Group: "1234"}},
+			info:     scmProviderGeneratorInfo{Gitlab: &scmProviderGeneratorGitlabInfo{Namespace: "group/subgroup", APIRegexp: gitlabAPIRegexp}, TouchedHead: true},
+			expected: true,
+		},
+		{
+			name:     "gitlab other host",
+			gen:      argoprojiov1alpha1.SCMProviderGenerator{Gitlab: &argoprojiov1alpha1.SCMProviderGeneratorGitlab{Group: "group/subgroup", API: "https://gitlab.example.com/"}},
+			info:     scmProviderGeneratorInfo{Gitlab: &scmProviderGeneratorGitlabInfo{Namespace: "group/subgroup", APIRegexp: gitlabAPIRegexp}, TouchedHead: true},
+			expected: false,
+		},
+		{
+			name:     "bitbucket workspace",
+			gen:      argoprojiov1alpha1.SCMProviderGenerator{Bitbucket: &argoprojiov1alpha1.SCMProviderGeneratorBitbucket{Owner: "workspace"}},
+			info:     scmProviderGeneratorInfo{Bitbucket: &scmProviderGeneratorBitbucketInfo{Workspace: "workspace"}, TouchedHead: true},
+			expected: true,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, shouldRefreshSCMProviderGenerator(&c.gen, &c.info))
+		})
+	}
 }
 
 func fakeAppWithGitGenerator(name, namespace, repo string) *argoprojiov1alpha1.ApplicationSet {
@@ -166,6 +627,13 @@ func fakeAppWithGitGenerator(name, namespace, repo string) *argoprojiov1alpha1.A
 	}
 }
 
+func fakeAppWithGitGeneratorPaths(name, namespace, repo string, directories []argoprojiov1alpha1.GitDirectoryGeneratorItem, files []argoprojiov1alpha1.GitFileGeneratorItem) *argoprojiov1alpha1.ApplicationSet {
+	appSet := fakeAppWithGitGenerator(name, namespace, repo)
+	appSet.Spec.Generators[0].Git.Directories = directories
+	appSet.Spec.Generators[0].Git.Files = files
+	return appSet
+}
+
 func fakeAppWithPullRequestGenerator(name, namespace, owner, repo string) *argoprojiov1alpha1.ApplicationSet {
 	return &argoprojiov1alpha1.ApplicationSet{
 		ObjectMeta: metav1.ObjectMeta{
@@ -187,7 +655,93 @@ func fakeAppWithPullRequestGenerator(name, namespace, owner, repo string) *argop
 	}
 }
 
+func fakeAppWithSCMProviderGenerator(name, namespace string, scmProvider argoprojiov1alpha1.SCMProviderGenerator) *argoprojiov1alpha1.ApplicationSet {
+	return &argoprojiov1alpha1.ApplicationSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: argoprojiov1alpha1.ApplicationSetSpec{
+			Generators: []argoprojiov1alpha1.ApplicationSetGenerator{
+				{
+					SCMProvider: &scmProvider,
+				},
+			},
+		},
+	}
+}
+
+func fakeAppWithMatrixAndGitGenerator(name, namespace, repo string) *argoprojiov1alpha1.ApplicationSet {
+	return &argoprojiov1alpha1.ApplicationSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: argoprojiov1alpha1.ApplicationSetSpec{
+			Generators: []argoprojiov1alpha1.ApplicationSetGenerator{
+				{
+					Matrix: &argoprojiov1alpha1.MatrixGenerator{
+						Generators: []argoprojiov1alpha1.ApplicationSetNestedGenerator{
+							{
+								List: &argoprojiov1alpha1.ListGenerator{},
+							},
+							{
+								Git: &argoprojiov1alpha1.GitGenerator{
+									RepoURL: repo,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func fakeAppWithMergeAndNestedMatrixAndPullRequestGenerator(name, namespace, owner, repo string) *argoprojiov1alpha1.ApplicationSet {
+	return &argoprojiov1alpha1.ApplicationSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: argoprojiov1alpha1.ApplicationSetSpec{
+			Generators: []argoprojiov1alpha1.ApplicationSetGenerator{
+				{
+					Merge: &argoprojiov1alpha1.MergeGenerator{
+						Generators: []argoprojiov1alpha1.ApplicationSetNestedGenerator{
+							{
+								List: &argoprojiov1alpha1.ListGenerator{},
+							},
+							{
+								Matrix: &apiextensionsv1.JSON{
+									Raw: []byte(fmt.Sprintf(`{
+										"generators": [
+											{"list": {"elements": []}},
+											{"pullRequest": {"github": {"owner": "%s", "repo": "%s"}}}
+										]
+									}`, owner, repo)),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
 func newFakeClient(ns string) *kubefake.Clientset {
+	return newFakeClientWithSecretData(ns, nil)
+}
+
+// newFakeClientWithSecretData returns a client of the Argo CD settings, with the given additional data in argocd-secret
+func newFakeClientWithSecretData(ns string, secretData map[string][]byte) *kubefake.Clientset {
+	data := map[string][]byte{
+		"server.secretkey": nil,
+	}
+	for k, v := range secretData {
+		data[k] = v
+	}
 	s := runtime.NewScheme()
 	s.AddKnownTypes(argoprojiov1alpha1.GroupVersion, &argoprojiov1alpha1.ApplicationSet{})
 	return kubefake.NewSimpleClientset(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "argocd-cm", Namespace: ns, Labels: map[string]string{
@@ -200,8 +754,6 @@ func newFakeClient(ns string) *kubefake.Clientset {
 				"app.kubernetes.io/part-of": "argocd",
 			},
 		},
-		Data: map[string][]byte{
-			"server.secretkey": nil,
-		},
+		Data: data,
 	})
 }